@@ -0,0 +1,126 @@
+// Package analyzer provides a go/analysis Analyzer
+// that reports errors returned without errtrace instrumentation.
+//
+// It applies the same rules as 'errtrace -check' with the same flags,
+// and suggests fixes that make the same changes as 'errtrace -w'.
+// It doesn't read errtrace.toml files:
+// use the flags of the same names instead of the no-wrapn, sentinels,
+// and generated settings,
+// and other tools to exclude packages and files, e.g. golangci-lint's exclusions.
+// Use it with 'go vet -vettool', golangci-lint,
+// or editors that support analysis fixes.
+// The errtracevet command runs it as a standalone tool:
+//
+//	go install braces.dev/errtrace/analyzer/cmd/errtracevet@latest
+//	go vet -vettool=$(which errtracevet) ./...
+package analyzer
+
+import (
+	"errors"
+	"go/ast"
+	"go/token"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+
+	"braces.dev/errtrace/internal/rewrite"
+)
+
+// Analyzer reports errors that aren't wrapped with errtrace,
+// return sites that errtrace can't instrument,
+// and unused //errtrace:skip directives.
+//
+// Diagnostics for unwrapped errors come with a suggested fix
+// that wraps them, adding the errtrace import if needed.
+var Analyzer = &analysis.Analyzer{
+	Name: "errtrace",
+	Doc:  "report errors returned without errtrace instrumentation",
+	URL:  "https://pkg.go.dev/braces.dev/errtrace/analyzer",
+	Run:  run,
+
+	// Error results are found syntactically by default,
+	// so packages don't need to type-check.
+	RunDespiteErrors: true,
+}
+
+var (
+	_noWrapN   bool
+	_types     bool
+	_nilCheck  bool
+	_sentinels string
+
+	_includeGenerated bool
+)
+
+func init() {
+	Analyzer.Flags.BoolVar(&_noWrapN, "no-wrapn", false,
+		"wrap multiple return values without using errtrace.WrapN")
+	Analyzer.Flags.BoolVar(&_types, "types", false,
+		"use type information to find error results")
+	Analyzer.Flags.BoolVar(&_nilCheck, "nil-check", false,
+		"with -types, check concrete error values for nil before wrapping them")
+	Analyzer.Flags.StringVar(&_sentinels, "sentinels", "",
+		"comma-separated list of errors to return as-is, e.g. example.com/store.ErrNotFound")
+	Analyzer.Flags.BoolVar(&_includeGenerated, "include-generated", false,
+		"report errors in generated files")
+}
+
+func run(pass *analysis.Pass) (any, error) {
+	if _nilCheck && !_types {
+		return nil, errors.New("-nil-check requires -types")
+	}
+
+	opts := rewrite.Options{
+		NoWrapN:  _noWrapN,
+		NilCheck: _nilCheck,
+		PkgPath:  pass.Pkg.Path(),
+	}
+	if _sentinels != "" {
+		opts.Sentinels = strings.Split(_sentinels, ",")
+	}
+	for _, file := range pass.Files {
+		tokFile := pass.Fset.File(file.Pos())
+		if tokFile == nil || (ast.IsGenerated(file) && !_includeGenerated) {
+			continue
+		}
+
+		sf := &rewrite.File{Fset: pass.Fset, Syntax: file}
+		if _types {
+			sf.Pkg = pass.Pkg
+			sf.Info = pass.TypesInfo
+		}
+
+		result := rewrite.Inspect(sf, opts)
+		for _, c := range result.Changes() {
+			edits := make([]analysis.TextEdit, len(c.Edits))
+			for i, e := range c.Edits {
+				edits[i] = analysis.TextEdit{
+					Pos:     e.Pos,
+					End:     e.End,
+					NewText: []byte(e.NewText),
+				}
+			}
+
+			d := diagnostic(tokFile, c.Diagnostic)
+			d.SuggestedFixes = []analysis.SuggestedFix{{
+				Message:   "Wrap with errtrace",
+				TextEdits: edits,
+			}}
+			pass.Report(d)
+		}
+
+		for _, d := range result.Diagnostics {
+			pass.Report(diagnostic(tokFile, d))
+		}
+	}
+
+	return nil, nil
+}
+
+func diagnostic(tokFile *token.File, d rewrite.Diagnostic) analysis.Diagnostic {
+	return analysis.Diagnostic{
+		Pos:      tokFile.Pos(d.Pos.Offset),
+		Category: string(d.Category),
+		Message:  d.Message,
+	}
+}
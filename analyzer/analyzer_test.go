@@ -0,0 +1,315 @@
+package analyzer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/format"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+
+	"golang.org/x/tools/go/analysis/analysistest"
+)
+
+// TestGolden runs the analyzer on the golden files of cmd/errtrace.
+//
+// The suggested fixes must produce the .golden files,
+// and the diagnostics must match the output of 'errtrace -check'
+// for the same file.
+func TestGolden(t *testing.T) {
+	files, err := filepath.Glob("../cmd/errtrace/testdata/golden/*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no golden files found")
+	}
+
+	errtrace := buildErrtrace(t)
+	for _, file := range files {
+		name := strings.TrimSuffix(filepath.Base(file), ".go")
+		t.Run(name, func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var flags []string
+			switch {
+			case bytes.Contains(src, []byte("@runIf options=types")),
+				bytes.Contains(src, []byte("@runIf options=nil-check")):
+				// These need type information,
+				// which the golden files don't have.
+				t.Skip("requires type information")
+			case bytes.Contains(src, []byte("@runIf options=no-wrapn")):
+				flags = append(flags, "-no-wrapn")
+			}
+
+			golden, err := os.ReadFile(file + ".golden")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			wants := checkDiagnostics(t, errtrace, flags, file)
+			header := wantHeader(wants)
+
+			// analysistest loads packages from dir/src/<pkg>.
+			dir := t.TempDir()
+			pkgDir := filepath.Join(dir, "src", name)
+			if err := os.MkdirAll(pkgDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for path, content := range map[string][]byte{
+				filepath.Join(pkgDir, name+".go"):             src,
+				filepath.Join(pkgDir, name+".go") + ".golden": golden,
+			} {
+				content = append([]byte(header), sanitize(content)...)
+				if err := os.WriteFile(path, content, 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			for _, flag := range flags {
+				setFlag(t, strings.TrimPrefix(flag, "-"), "true")
+			}
+			runWithSuggestedFixes(t, dir, name)
+		})
+	}
+}
+
+func TestIncludeGenerated(t *testing.T) {
+	const src = `// Code generated by foo. DO NOT EDIT.
+
+package gen
+
+func f() error {
+	return g() // want "^error is not wrapped with errtrace$"
+}
+`
+	const fixed = `// Code generated by foo. DO NOT EDIT.
+
+package gen
+
+import "braces.dev/errtrace"
+
+func f() error {
+	return errtrace.Wrap(g()) // want "^error is not wrapped with errtrace$"
+}
+`
+
+	tests := []struct {
+		name string
+		flag string
+		src  string
+		want string // fixed file, or empty for no fixes
+	}{
+		{
+			name: "skipped",
+			flag: "false",
+			src:  strings.ReplaceAll(src, " // want", " // skipped:"),
+		},
+		{
+			name: "included",
+			flag: "true",
+			src:  src,
+			want: fixed,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			pkgDir := filepath.Join(dir, "src", "gen")
+			if err := os.MkdirAll(pkgDir, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			file := filepath.Join(pkgDir, "gen.go")
+			if err := os.WriteFile(file, []byte(tt.src), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if tt.want != "" {
+				if err := os.WriteFile(file+".golden", []byte(tt.want), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			setFlag(t, "include-generated", tt.flag)
+			runWithSuggestedFixes(t, dir, "gen")
+		})
+	}
+}
+
+// diagnosticJSON is a diagnostic printed by 'errtrace -check -json'.
+type diagnosticJSON struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
+
+// buildErrtrace builds cmd/errtrace and returns the path to the binary.
+func buildErrtrace(t *testing.T) string {
+	exe := filepath.Join(t.TempDir(), "errtrace")
+	cmd := exec.Command("go", "build", "-o", exe, "braces.dev/errtrace/cmd/errtrace")
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("build errtrace: %v\n%s", err, out)
+	}
+	return exe
+}
+
+// checkDiagnostics returns the diagnostics that 'errtrace -check' reports for file.
+func checkDiagnostics(t *testing.T, errtrace string, flags []string, file string) []diagnosticJSON {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(errtrace, append(flags, "-check", "-json", file)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil && stdout.Len() == 0 {
+		t.Fatalf("errtrace -check: %v\n%s", err, stderr.String())
+	}
+
+	var diags []diagnosticJSON
+	decoder := json.NewDecoder(&stdout)
+	for decoder.More() {
+		var d diagnosticJSON
+		if err := decoder.Decode(&d); err != nil {
+			t.Fatal(err)
+		}
+		diags = append(diags, d)
+	}
+	return diags
+}
+
+// wantHeader returns comments to add to the top of a file
+// so that analysistest expects the given diagnostics.
+// Expectations use the '+N' form to refer to lines below the header,
+// since the golden files already use comments at the end of lines.
+func wantHeader(diags []diagnosticJSON) string {
+	// Diagnostics on the same line share a comment.
+	var (
+		lines    []int
+		patterns = make(map[int][]string)
+	)
+	for _, d := range diags {
+		if _, ok := patterns[d.Line]; !ok {
+			lines = append(lines, d.Line)
+		}
+		pattern := strconv.Quote("^" + regexp.QuoteMeta(d.Message) + "$")
+		patterns[d.Line] = append(patterns[d.Line], pattern)
+	}
+
+	var sb strings.Builder
+	for i, line := range lines {
+		// Line numbers move down by the size of the header.
+		delta := line + len(lines) - (i + 1)
+		fmt.Fprintf(&sb, "// want +%d %s\n", delta, strings.Join(patterns[line], " "))
+	}
+	return sb.String()
+}
+
+var _sanitizer = strings.NewReplacer(
+	// Include the files in the package.
+	"//go:build ignore", "// go:build ignore",
+	// Expected log messages are checked against 'errtrace -check' instead.
+	"// want:", "// logs:",
+)
+
+// sanitize prepares the contents of a golden file for analysistest.
+func sanitize(src []byte) []byte {
+	return []byte(_sanitizer.Replace(string(src)))
+}
+
+// runWithSuggestedFixes runs the analyzer on pkg like analysistest.Run,
+// applies all suggested fixes to each file,
+// and compares the result with the file's .golden file.
+//
+// Unlike analysistest.RunWithSuggestedFixes,
+// identical edits from different fixes (e.g. adding the errtrace import)
+// are applied once, like 'errtracevet -fix' does.
+func runWithSuggestedFixes(t *testing.T, dir, pkg string) {
+	type edit struct {
+		start, end int
+		text       string
+	}
+
+	for _, result := range analysistest.Run(t, dir, Analyzer, pkg) {
+		fileEdits := make(map[string]map[edit]struct{})
+		for _, d := range result.Diagnostics {
+			for _, fix := range d.SuggestedFixes {
+				for _, e := range fix.TextEdits {
+					file := result.Pass.Fset.File(e.Pos)
+					if fileEdits[file.Name()] == nil {
+						fileEdits[file.Name()] = make(map[edit]struct{})
+					}
+					fileEdits[file.Name()][edit{
+						start: file.Offset(e.Pos),
+						end:   file.Offset(e.End),
+						text:  string(e.NewText),
+					}] = struct{}{}
+				}
+			}
+		}
+
+		for name, set := range fileEdits {
+			edits := make([]edit, 0, len(set))
+			for e := range set {
+				edits = append(edits, e)
+			}
+			sort.Slice(edits, func(i, j int) bool {
+				if edits[i].start != edits[j].start {
+					return edits[i].start < edits[j].start
+				}
+				return edits[i].end < edits[j].end
+			})
+
+			src, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var (
+				out  []byte
+				last int
+			)
+			for _, e := range edits {
+				if e.start < last {
+					t.Fatalf("%s: overlapping edits at offset %d", name, e.start)
+				}
+				out = append(out, src[last:e.start]...)
+				out = append(out, e.text...)
+				last = e.end
+			}
+			out = append(out, src[last:]...)
+
+			got, err := format.Source(out)
+			if err != nil {
+				t.Fatalf("%s: format fixed file: %v\n%s", name, err, out)
+			}
+			golden, err := os.ReadFile(name + ".golden")
+			if err != nil {
+				t.Fatal(err)
+			}
+			want, err := format.Source(golden)
+			if err != nil {
+				t.Fatalf("%s.golden: %v", name, err)
+			}
+			if !bytes.Equal(got, want) {
+				t.Errorf("%s: suggested fixes don't match golden file\ngot:\n%s\nwant:\n%s", name, got, want)
+			}
+		}
+	}
+}
+
+// setFlag sets a flag of the analyzer for the duration of the test.
+func setFlag(t *testing.T, name, value string) {
+	flag := Analyzer.Flags.Lookup(name)
+	old := flag.Value.String()
+	if err := flag.Value.Set(value); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		_ = flag.Value.Set(old)
+	})
+}
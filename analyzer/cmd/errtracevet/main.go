@@ -0,0 +1,19 @@
+// errtracevet reports errors returned without errtrace instrumentation.
+//
+// It runs the errtrace analyzer as a standalone tool,
+// or as a 'go vet' tool:
+//
+//	errtracevet ./...
+//	errtracevet -fix ./...
+//	go vet -vettool=$(which errtracevet) ./...
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/singlechecker"
+
+	"braces.dev/errtrace/analyzer"
+)
+
+func main() {
+	singlechecker.Main(analyzer.Analyzer)
+}
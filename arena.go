@@ -1,58 +1,61 @@
 package errtrace
 
 import (
-	"sync"
+	"sync/atomic"
+)
+
+// _arenaSlabSize is the number of objects allocated together by an arena.
+//
+// Every object taken from a slab keeps the whole slab alive,
+// so slabs are kept small to bound the memory retained
+// by a single long-lived error.
+const _arenaSlabSize = 16
+
+// _arenaShards is the number of slabs an arena takes objects from
+// at the same time, so goroutines wrapping errors at different locations
+// don't all contend on the same slab.
+const (
+	_arenaShardBits = 3
+	_arenaShards    = 1 << _arenaShardBits
 )
 
 // arena is a lock-free allocator for a fixed-size type.
 // It is intended to be used for allocating errTrace objects in batches.
 type arena[T any] struct {
-	slabSize int
-	pool     sync.Pool
-}
-
-func newArena[T any](slabSize int) *arena[T] {
-	return &arena[T]{
-		slabSize: slabSize,
-	}
+	shards [_arenaShards]atomic.Pointer[arenaSlab[T]]
 }
 
 // Take returns a pointer to a new object from the arena.
-func (a *arena[T]) Take() *T {
-	for {
-		slab, ok := a.pool.Get().(*arenaSlab[T])
-		if !ok {
-			slab = newArenaSlab[T](a.slabSize)
-		}
-
-		if e, ok := slab.take(); ok {
-			a.pool.Put(slab)
-			return e
+// key picks the slab to take it from.
+func (a *arena[T]) Take(key uintptr) *T {
+	// Fibonacci hashing spreads nearby keys (e.g. program counters)
+	// across shards.
+	shard := &a.shards[(uint64(key)*0x9E3779B97F4A7C15)>>(64-_arenaShardBits)]
+
+	if slab := shard.Load(); slab != nil {
+		if i := slab.idx.Add(1) - 1; i < _arenaSlabSize {
+			return &slab.buf[i]
 		}
 	}
+
+	// The slab is exhausted.
+	// If multiple goroutines get here at the same time,
+	// the last one to store its slab wins,
+	// and the others' slabs are freed along with the objects taken from them.
+	slab := new(arenaSlab[T])
+	slab.idx.Store(1)
+	shard.Store(slab)
+	return &slab.buf[0]
 }
 
 // arenaSlab is a slab of objects in an arena.
 //
-// Each slab has a fixed number of objects in it.
 // Pointers are taken from the slab in order.
 type arenaSlab[T any] struct {
 	// Full list of objects in the slab.
-	buf []T
+	buf [_arenaSlabSize]T
 
 	// Index of the next object to be taken.
-	idx int
-}
-
-func newArenaSlab[T any](sz int) *arenaSlab[T] {
-	return &arenaSlab[T]{buf: make([]T, sz)}
-}
-
-func (a *arenaSlab[T]) take() (*T, bool) {
-	if a.idx >= len(a.buf) {
-		return nil, false
-	}
-	ptr := &a.buf[a.idx]
-	a.idx++
-	return ptr, true
+	// It keeps growing past the end of buf once the slab is exhausted.
+	idx atomic.Int32
 }
@@ -20,7 +20,8 @@ func BenchmarkErrtrace(b *testing.B) {
 		err = recurseErrtrace(10)
 	}
 
-	if wantMin, got := 10, strings.Count(errtrace.FormatString(err), "errtrace_test.go"); got < wantMin {
-		b.Fatalf("missing expected stack frames, expected >%v, got %v", wantMin, got)
+	// Recursive returns are collapsed into a single frame.
+	if want := "(returned 10 times)"; !strings.Contains(errtrace.FormatString(err), want) {
+		b.Fatalf("missing expected repeated frame %q:\n%v", want, errtrace.FormatString(err))
 	}
 }
@@ -1,6 +1,6 @@
 module braces.dev/errtrace/benchext
 
-go 1.21
+go 1.22.0
 
 replace braces.dev/errtrace => ../
 
@@ -0,0 +1,63 @@
+package errtrace
+
+import "braces.dev/errtrace/internal/pc"
+
+// CallerMode specifies how errtrace captures
+// the program counter of the caller in [Wrap], [GetCaller], and friends.
+type CallerMode int
+
+const (
+	// CallerModeAsm reads return addresses from stack frames
+	// using Go assembly.
+	// This is the fastest mode, used on [supported systems] by default.
+	//
+	// [supported systems]: https://github.com/bracesdev/errtrace#supported-systems
+	CallerModeAsm CallerMode = iota + 1
+
+	// CallerModeSafe uses [runtime.Callers]
+	// because errtrace was built with the safe build tag,
+	// or the system doesn't support assembly mode.
+	CallerModeSafe
+
+	// CallerModeFallback uses [runtime.Callers]
+	// because assembly mode is supported on the system,
+	// but it failed a self-check at program startup.
+	// This may happen with a new Go release or unusual compiler flags
+	// that change the layout of stack frames.
+	CallerModeFallback
+)
+
+// String returns the name of the mode.
+func (m CallerMode) String() string {
+	switch m {
+	case CallerModeAsm:
+		return "asm"
+	case CallerModeSafe:
+		return "safe"
+	case CallerModeFallback:
+		return "fallback"
+	default:
+		return "unknown"
+	}
+}
+
+// GetCallerMode reports how errtrace captures program counters
+// in this program.
+//
+// Assembly mode is verified against [runtime.Callers] at startup.
+// If the two disagree, errtrace switches to the safe implementation
+// and reports [CallerModeFallback].
+//
+// On riscv64, assembly mode only applies to [Wrap] and friends.
+// [GetCaller] and [GetCallerSkip] always use [runtime.Callers]
+// because the caller's caller can't be found without frame pointers.
+func GetCallerMode() CallerMode {
+	switch pc.CurrentMode() {
+	case pc.ModeAsm:
+		return CallerModeAsm
+	case pc.ModeFallback:
+		return CallerModeFallback
+	default:
+		return CallerModeSafe
+	}
+}
@@ -0,0 +1,280 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+type aggregateParams struct {
+	JSON  bool     // -json
+	Files []string // log files to read, "-" for stdin
+}
+
+func (p *aggregateParams) Parse(w io.Writer, args []string) error {
+	flag := flag.NewFlagSet("errtrace aggregate", flag.ContinueOnError)
+	flag.SetOutput(w)
+	flag.Usage = func() {
+		logln(w, "usage: errtrace aggregate [options] [log files]")
+		flag.PrintDefaults()
+	}
+
+	flag.BoolVar(&p.JSON, "json", false,
+		"print the report as JSON.")
+
+	if err := flag.Parse(args); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	p.Files = flag.Args()
+	if len(p.Files) == 0 {
+		p.Files = []string{"-"}
+	}
+
+	return nil
+}
+
+// runAggregate runs the 'errtrace aggregate' subcommand.
+//
+// It finds return traces in log files (or stdin),
+// groups them by the path the error took through the program,
+// and prints a report of the groups ordered by how often they occurred.
+func (cmd *mainCmd) runAggregate(args []string) (exitCode int) {
+	var p aggregateParams
+	if err := p.Parse(cmd.Stderr, args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		cmd.log.Printf("errtrace: %+v", err)
+		return 1
+	}
+
+	var agg traceAggregator
+	for _, file := range p.Files {
+		if err := cmd.aggregateFile(&agg, file); err != nil {
+			cmd.log.Printf("%s:%+v", file, err)
+			exitCode = 1
+		}
+	}
+
+	groups := agg.Groups()
+	var err error
+	if p.JSON {
+		err = writeAggregateJSON(cmd.Stdout, groups)
+	} else {
+		err = writeAggregateText(cmd.Stdout, groups)
+	}
+	if err != nil {
+		cmd.log.Printf("errtrace: %+v", err)
+		return 1
+	}
+
+	return exitCode
+}
+
+func (cmd *mainCmd) aggregateFile(agg *traceAggregator, file string) error {
+	if file == "-" {
+		return errtrace.Wrap(scanTraces(cmd.Stdin, "stdin", agg.Add))
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return errtrace.Wrap(err)
+	}
+	defer f.Close() //nolint:errcheck // read-only
+
+	return errtrace.Wrap(scanTraces(f, file, agg.Add))
+}
+
+// traceGroup is a set of traces for errors
+// that took the same path through the program.
+type traceGroup struct {
+	Count int
+
+	// First and Last are the earliest and latest occurrences.
+	// First is also used as the representative of the group.
+	First, Last traceOccurrence
+
+	order int // index of the group in order of first appearance
+}
+
+// traceAggregator groups traces by their return path.
+type traceAggregator struct {
+	groups map[string]*traceGroup
+}
+
+// Add adds an occurrence of a trace to its group.
+func (a *traceAggregator) Add(occ traceOccurrence) {
+	if a.groups == nil {
+		a.groups = make(map[string]*traceGroup)
+	}
+
+	key := traceKey(occ.Tree)
+	g, ok := a.groups[key]
+	if !ok {
+		a.groups[key] = &traceGroup{
+			Count: 1,
+			First: occ,
+			Last:  occ,
+			order: len(a.groups),
+		}
+		return
+	}
+
+	g.Count++
+	// Logs are usually in chronological order,
+	// but when aggregating multiple files, timestamps are more reliable.
+	if occurredBefore(occ, g.First) {
+		g.First = occ
+	}
+	if !occurredBefore(occ, g.Last) {
+		g.Last = occ
+	}
+}
+
+// Groups returns the groups ordered by count, most frequent first.
+// Groups with the same count are ordered by first appearance.
+func (a *traceAggregator) Groups() []*traceGroup {
+	groups := make([]*traceGroup, 0, len(a.groups))
+	for _, g := range a.groups {
+		groups = append(groups, g)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		if groups[i].Count != groups[j].Count {
+			return groups[i].Count > groups[j].Count
+		}
+		return groups[i].order < groups[j].order
+	})
+	return groups
+}
+
+// occurredBefore reports whether a is known to have occurred before b.
+func occurredBefore(a, b traceOccurrence) bool {
+	if a.Time.IsZero() || b.Time.IsZero() {
+		return false
+	}
+	return a.Time.Before(b.Time)
+}
+
+// traceKey identifies the return path of a trace tree.
+// Error messages are not part of the key
+// because they often contain request-specific details.
+func traceKey(tree errtrace.TraceTree) string {
+	var s strings.Builder
+	var write func(errtrace.TraceTree)
+	write = func(t errtrace.TraceTree) {
+		s.WriteString("(")
+		for _, f := range t.Trace {
+			fmt.Fprintf(&s, "%s %s:%d;", f.Function, f.File, f.Line)
+		}
+		for _, child := range t.Children {
+			write(child)
+		}
+		s.WriteString(")")
+	}
+	write(tree)
+	return s.String()
+}
+
+func writeAggregateText(w io.Writer, groups []*traceGroup) error {
+	for i, g := range groups {
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return errtrace.Wrap(err)
+			}
+		}
+
+		times := "times"
+		if g.Count == 1 {
+			times = "time"
+		}
+		if _, err := fmt.Fprintf(w, "=== #%d: seen %d %s\nfirst seen: %s\nlast seen:  %s\n\n%s",
+			i+1, g.Count, times, seenAt(g.First), seenAt(g.Last), g.First.Text); err != nil {
+			return errtrace.Wrap(err)
+		}
+	}
+	return nil
+}
+
+func seenAt(occ traceOccurrence) string {
+	s := fmt.Sprintf("%s:%d", occ.Source, occ.Line)
+	if !occ.Time.IsZero() {
+		s += " at " + occ.Time.Format(time.RFC3339Nano)
+	}
+	return s
+}
+
+type aggregateJSONGroup struct {
+	Count     int               `json:"count"`
+	FirstSeen aggregateJSONSeen `json:"firstSeen"`
+	LastSeen  aggregateJSONSeen `json:"lastSeen"`
+	Message   string            `json:"message"`
+	Trace     aggregateJSONTree `json:"trace"`
+}
+
+type aggregateJSONSeen struct {
+	Source string     `json:"source"`
+	Line   int        `json:"line"`
+	Time   *time.Time `json:"time,omitempty"`
+}
+
+type aggregateJSONTree struct {
+	Message  string               `json:"message"`
+	Frames   []aggregateJSONFrame `json:"frames,omitempty"`
+	Children []aggregateJSONTree  `json:"children,omitempty"`
+}
+
+type aggregateJSONFrame struct {
+	Function string `json:"function"`
+	File     string `json:"file"`
+	Line     int    `json:"line"`
+}
+
+func writeAggregateJSON(w io.Writer, groups []*traceGroup) error {
+	out := make([]aggregateJSONGroup, len(groups))
+	for i, g := range groups {
+		out[i] = aggregateJSONGroup{
+			Count:     g.Count,
+			FirstSeen: jsonSeen(g.First),
+			LastSeen:  jsonSeen(g.Last),
+			Message:   g.First.Tree.Message,
+			Trace:     jsonTree(g.First.Tree),
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return errtrace.Wrap(enc.Encode(out))
+}
+
+func jsonSeen(occ traceOccurrence) aggregateJSONSeen {
+	seen := aggregateJSONSeen{Source: occ.Source, Line: occ.Line}
+	if !occ.Time.IsZero() {
+		t := occ.Time
+		seen.Time = &t
+	}
+	return seen
+}
+
+func jsonTree(tree errtrace.TraceTree) aggregateJSONTree {
+	out := aggregateJSONTree{Message: tree.Message}
+	for _, f := range tree.Trace {
+		out.Frames = append(out.Frames, aggregateJSONFrame{
+			Function: f.Function,
+			File:     f.File,
+			Line:     f.Line,
+		})
+	}
+	for _, child := range tree.Children {
+		out.Children = append(out.Children, jsonTree(child))
+	}
+	return out
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace/internal/diff"
+)
+
+const _aggregateLog = `2024/01/02 15:04:05 starting server
+2024/01/02 15:04:06 open config: no such file
+
+example.com/app.loadConfig
+	/src/app/config.go:12
+example.com/app.main
+	/src/app/main.go:30
+2024/01/02 15:04:07 request failed: timeout
+
+example.com/app.fetch
+	/src/app/fetch.go:8
+2024/01/02 15:04:08 open config: permission denied
+
+example.com/app.loadConfig
+	/src/app/config.go:12
+example.com/app.main
+	/src/app/main.go:30
+`
+
+func TestAggregate(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	if err := os.WriteFile(logFile, []byte(_aggregateLog), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"=== #1: seen 2 times",
+		"first seen: " + logFile + ":2 at 2024-01-02T15:04:06Z",
+		"last seen:  " + logFile + ":12 at 2024-01-02T15:04:08Z",
+		"",
+		"open config: no such file",
+		"",
+		"example.com/app.loadConfig",
+		"	/src/app/config.go:12",
+		"example.com/app.main",
+		"	/src/app/main.go:30",
+		"",
+		"=== #2: seen 1 time",
+		"first seen: " + logFile + ":8 at 2024-01-02T15:04:07Z",
+		"last seen:  " + logFile + ":8 at 2024-01-02T15:04:07Z",
+		"",
+		"request failed: timeout",
+		"",
+		"example.com/app.fetch",
+		"	/src/app/fetch.go:8",
+		"",
+	}, "\n")
+
+	var stdout bytes.Buffer
+	exitCode := (&mainCmd{
+		Stdout: &stdout,
+		Stderr: testWriter{t},
+	}).Run([]string{"aggregate", logFile})
+	if want := 0; exitCode != want {
+		t.Errorf("exit code = %d, want %d", exitCode, want)
+	}
+
+	if got := stdout.String(); got != want {
+		t.Errorf("output mismatch:\n%s", diff.Lines(want, got))
+	}
+}
+
+func TestAggregate_logPrefix(t *testing.T) {
+	tests := []struct {
+		name    string
+		give    string
+		wantMsg string
+	}{
+		{
+			name: "single line",
+			give: "2024-01-02 15:04:06 ERROR boom\n" +
+				"\n" +
+				"example.com/app.run\n" +
+				"	/src/app/run.go:5\n",
+			wantMsg: "boom",
+		},
+		{
+			name: "multi-line",
+			give: "2024-01-02 15:04:06 ERROR boom\n" +
+				"second line\n" +
+				"\n" +
+				"example.com/app.run\n" +
+				"	/src/app/run.go:5\n",
+			wantMsg: "boom\nsecond line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logFile := filepath.Join(t.TempDir(), "app.log")
+			if err := os.WriteFile(logFile, []byte("2024-01-02 15:04:05 INFO starting\n"+tt.give), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			want := strings.Join([]string{
+				"=== #1: seen 1 time",
+				"first seen: " + logFile + ":2 at 2024-01-02T15:04:06Z",
+				"last seen:  " + logFile + ":2 at 2024-01-02T15:04:06Z",
+				"",
+				tt.wantMsg,
+				"",
+				"example.com/app.run",
+				"	/src/app/run.go:5",
+				"",
+			}, "\n")
+
+			var stdout bytes.Buffer
+			exitCode := (&mainCmd{
+				Stdout: &stdout,
+				Stderr: testWriter{t},
+			}).Run([]string{"aggregate", logFile})
+			if want := 0; exitCode != want {
+				t.Errorf("exit code = %d, want %d", exitCode, want)
+			}
+
+			if got := stdout.String(); got != want {
+				t.Errorf("output mismatch:\n%s", diff.Lines(want, got))
+			}
+		})
+	}
+}
+
+func TestAggregate_JSON(t *testing.T) {
+	var stdout bytes.Buffer
+	exitCode := (&mainCmd{
+		Stdin:  strings.NewReader(_aggregateLog),
+		Stdout: &stdout,
+		Stderr: testWriter{t},
+	}).Run([]string{"aggregate", "-json"})
+	if want := 0; exitCode != want {
+		t.Errorf("exit code = %d, want %d", exitCode, want)
+	}
+
+	var got []aggregateJSONGroup
+	if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+		t.Fatalf("decode output: %v\n%s", err, stdout.String())
+	}
+
+	if len(got) != 2 {
+		t.Fatalf("want 2 groups, got %d:\n%s", len(got), stdout.String())
+	}
+
+	first := got[0]
+	if want := 2; first.Count != want {
+		t.Errorf("count = %d, want %d", first.Count, want)
+	}
+	if want := "open config: no such file"; first.Message != want {
+		t.Errorf("message = %q, want %q", first.Message, want)
+	}
+	if want := (aggregateJSONSeen{Source: "stdin", Line: 12}); first.LastSeen.Source != want.Source || first.LastSeen.Line != want.Line {
+		t.Errorf("last seen = %+v, want %+v", first.LastSeen, want)
+	}
+	if first.LastSeen.Time == nil {
+		t.Errorf("last seen time is missing")
+	}
+
+	wantFrames := []aggregateJSONFrame{
+		{Function: "example.com/app.loadConfig", File: "/src/app/config.go", Line: 12},
+		{Function: "example.com/app.main", File: "/src/app/main.go", Line: 30},
+	}
+	if d := diff.Diff(wantFrames, first.Trace.Frames); d != "" {
+		t.Errorf("frames mismatch:\n%s", d)
+	}
+}
+
+func TestAggregate_missingFile(t *testing.T) {
+	var stderr bytes.Buffer
+	exitCode := (&mainCmd{
+		Stdout: testWriter{t},
+		Stderr: &stderr,
+	}).Run([]string{"aggregate", filepath.Join(t.TempDir(), "missing.log")})
+	if want := 1; exitCode != want {
+		t.Errorf("exit code = %d, want %d", exitCode, want)
+	}
+
+	if want := "no such file"; !strings.Contains(stderr.String(), want) {
+		t.Errorf("stderr = %q, want to contain %q", stderr.String(), want)
+	}
+}
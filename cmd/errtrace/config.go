@@ -0,0 +1,665 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/md5"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+// configFileName is the name of the errtrace configuration file.
+// It applies to packages in the same directory and below,
+// up to the root of the module it's in.
+const configFileName = "errtrace.toml"
+
+// config is the contents of an errtrace.toml file.
+//
+//	# Packages to instrument, as import paths or patterns like "foo/...".
+//	# All packages are included by default.
+//	[packages]
+//	include = ["example.com/app/..."]
+//	exclude = ["example.com/app/internal/mocks/..."]
+//
+//	# Files to instrument, as globs relative to the directory of errtrace.toml.
+//	# Globs without a "/" match the file name in any directory,
+//	# and "dir/..." matches all files inside dir.
+//	[files]
+//	exclude = ["*.pb.go", "testdata/..."]
+//
+//	# Whether to instrument generated files: "skip" (default) or "include".
+//	generated = "include"
+//
+//	# Errors that are returned as-is because callers compare them with ==,
+//	# in addition to io.EOF and other errors from the standard library.
+//	sentinels = ["example.com/app/store.ErrNotFound"]
+//
+//	# Whether to also return the standard library errors as-is (default true).
+//	default-sentinels = false
+//
+//	# Same as the -no-wrapn flag.
+//	no-wrapn = true
+//
+//	# Same as the toolexec flags of the same name.
+//	[toolexec]
+//	required-packages = ["example.com/app/..."]
+//	unsafe-packages = []
+type config struct {
+	// Dir is the directory containing the file.
+	Dir string
+
+	Packages patterns // [packages]
+	Files    patterns // [files]
+
+	Generated generatedPolicy // generated
+	Sentinels []string        // sentinels
+	NoWrapN   bool            // no-wrapn
+
+	NoDefaultSentinels bool // default-sentinels = false
+
+	RequiredPackages []string // [toolexec] required-packages
+	UnsafePackages   []string // [toolexec] unsafe-packages
+
+	// Hash identifies the contents of the file.
+	// It's empty if there's no file.
+	Hash string
+}
+
+// patterns is a list of patterns to include and exclude.
+// Everything is included if Include is empty.
+type patterns struct {
+	Include []string
+	Exclude []string
+}
+
+func (p patterns) match(match func(pattern string) bool) bool {
+	if len(p.Include) > 0 && !anyMatch(p.Include, match) {
+		return false
+	}
+	return !anyMatch(p.Exclude, match)
+}
+
+func anyMatch(patterns []string, match func(string) bool) bool {
+	for _, pattern := range patterns {
+		if match(pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// generatedPolicy specifies what to do with generated files.
+type generatedPolicy int
+
+const (
+	// generatedSkip leaves generated files unchanged.
+	//
+	// This is the default.
+	generatedSkip generatedPolicy = iota
+
+	// generatedInclude instruments generated files like any other file.
+	generatedInclude
+)
+
+func (g *generatedPolicy) Set(s string) error {
+	switch s {
+	case "skip":
+		*g = generatedSkip
+	case "include":
+		*g = generatedInclude
+	default:
+		return errtrace.Wrap(fmt.Errorf("invalid generated policy %q is not one of [skip, include]", s))
+	}
+	return nil
+}
+
+func (g generatedPolicy) String() string {
+	switch g {
+	case generatedSkip:
+		return "skip"
+	case generatedInclude:
+		return "include"
+	default:
+		return fmt.Sprintf("generatedPolicy(%d)", int(g))
+	}
+}
+
+// IncludesPackage reports whether the package with the given import path
+// should be instrumented.
+func (c *config) IncludesPackage(pkg string) bool {
+	if pkg == "" {
+		return true
+	}
+	return c.Packages.match(func(selector string) bool {
+		return packageSelectorMatch(selector, pkg)
+	})
+}
+
+// IncludesFile reports whether the file at the given path
+// should be instrumented.
+func (c *config) IncludesFile(file string) bool {
+	rel, err := filepath.Rel(c.Dir, file)
+	if err != nil {
+		return true
+	}
+	rel = filepath.ToSlash(rel)
+
+	return c.Files.match(func(pattern string) bool {
+		if dir, ok := strings.CutSuffix(pattern, "/..."); ok {
+			return strings.HasPrefix(rel, dir+"/")
+		}
+
+		name := rel
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(rel)
+		}
+		ok, _ := path.Match(pattern, name) // validated when parsing
+		return ok
+	})
+}
+
+// dirConfig holds the configuration for a directory of Go files.
+type dirConfig struct {
+	// Config is the errtrace.toml that applies to the directory,
+	// or an empty config if there isn't one.
+	Config *config
+
+	// PkgPath is the import path of the directory
+	// if it's inside a module.
+	PkgPath string
+
+	// ModDir is the root directory of the module
+	// if the directory is inside one.
+	ModDir string
+}
+
+// configLoader finds the configuration for directories,
+// caching the results.
+type configLoader struct {
+	dirs  map[string]*dirConfig // by absolute path of the directory
+	files map[string]*config    // by absolute path of errtrace.toml
+}
+
+// Load returns the configuration for the given directory.
+//
+// It looks for an errtrace.toml in the directory and its parents,
+// stopping at the root of the module the directory is in.
+func (l *configLoader) Load(dir string) (*dirConfig, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	if dc, ok := l.dirs[dir]; ok {
+		return dc, nil
+	}
+
+	dc := new(dirConfig)
+	for d := dir; ; {
+		if dc.Config == nil {
+			cfg, err := l.loadFile(filepath.Join(d, configFileName))
+			if err != nil {
+				return nil, errtrace.Wrap(err)
+			}
+			dc.Config = cfg
+		}
+
+		modPath, ok, err := readModulePath(filepath.Join(d, "go.mod"))
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		if ok {
+			rel, err := filepath.Rel(d, dir)
+			if err != nil {
+				return nil, errtrace.Wrap(err)
+			}
+			dc.PkgPath = path.Join(modPath, filepath.ToSlash(rel))
+			dc.ModDir = d
+			break
+		}
+
+		parent := filepath.Dir(d)
+		if parent == d {
+			break
+		}
+		d = parent
+	}
+
+	if dc.Config == nil {
+		dc.Config = &config{Dir: dir}
+	}
+
+	if l.dirs == nil {
+		l.dirs = make(map[string]*dirConfig)
+	}
+	l.dirs[dir] = dc
+	return dc, nil
+}
+
+// HashTree returns a hash of the errtrace.toml files
+// in the module rooted at dir and its subdirectories.
+// It skips nested modules and directories that the go command ignores,
+// such as testdata.
+func (l *configLoader) HashTree(dir string) (string, error) {
+	h := md5.New()
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return errtrace.Wrap(err)
+		}
+
+		if d.IsDir() {
+			if path == dir {
+				return nil
+			}
+			if name := d.Name(); name == "testdata" || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(path, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Name() != configFileName || !d.Type().IsRegular() {
+			return nil
+		}
+		cfg, err := l.loadFile(path)
+		if err != nil {
+			return errtrace.Wrap(err)
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return errtrace.Wrap(err)
+		}
+		fmt.Fprintf(h, "%s %s\n", filepath.ToSlash(rel), cfg.Hash)
+		return nil
+	})
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// loadFile parses the errtrace.toml at the given path,
+// returning nil if it doesn't exist.
+func (l *configLoader) loadFile(file string) (*config, error) {
+	if cfg, ok := l.files[file]; ok {
+		return cfg, nil
+	}
+
+	src, err := os.ReadFile(file)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return nil, errtrace.Wrap(err)
+	}
+
+	cfg, err := parseConfig(file, src)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	if l.files == nil {
+		l.files = make(map[string]*config)
+	}
+	l.files[file] = cfg
+	return cfg, nil
+}
+
+// readModulePath returns the module path declared in the given go.mod file,
+// and false if the file doesn't exist.
+func readModulePath(gomod string) (_ string, ok bool, _ error) {
+	src, err := os.ReadFile(gomod)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+		return "", false, errtrace.Wrap(err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(src))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		modPath, ok := strings.CutPrefix(line, "module")
+		if !ok || modPath == "" || (modPath[0] != ' ' && modPath[0] != '\t' && modPath[0] != '"') {
+			continue
+		}
+
+		modPath, _, _ = strings.Cut(modPath, "//")
+		modPath = strings.TrimSpace(modPath)
+		if unquoted, err := strconv.Unquote(modPath); err == nil {
+			modPath = unquoted
+		}
+		return modPath, true, nil
+	}
+
+	return "", false, errtrace.Wrap(fmt.Errorf("%s: no module directive", gomod))
+}
+
+// parseConfig parses the contents of an errtrace.toml file.
+//
+// It supports the subset of TOML that errtrace needs:
+// comments, tables, and keys set to strings, booleans, or arrays of strings.
+func parseConfig(file string, src []byte) (*config, error) {
+	hash := md5.Sum(src)
+	cfg := &config{
+		Dir:  filepath.Dir(file),
+		Hash: hex.EncodeToString(hash[:]),
+	}
+
+	p := tomlParser{file: file, src: string(src), line: 1}
+	var table string
+	seen := make(map[string]bool)
+	for {
+		p.skipSpace(true /* newlines */)
+		if p.eof() {
+			break
+		}
+
+		if p.peek() == '[' {
+			p.pos++
+			name := p.key()
+			if p.err == nil && !p.consume(']') {
+				p.errorf("expected ']' after table name")
+			}
+			if p.err != nil {
+				return nil, errtrace.Wrap(p.err)
+			}
+			table = name
+			switch table {
+			case "packages", "files", "toolexec":
+			default:
+				return nil, errtrace.Wrap(p.errorf("unknown table %q", table))
+			}
+		} else {
+			line := p.line
+			key := p.key()
+			p.skipSpace(false)
+			if p.err == nil && !p.consume('=') {
+				p.errorf("expected '=' after key %q", key)
+			}
+			p.skipSpace(false)
+			value := p.value()
+			if p.err != nil {
+				return nil, errtrace.Wrap(p.err)
+			}
+
+			qualified := key
+			if table != "" {
+				qualified = table + "." + key
+			}
+			if seen[qualified] {
+				return nil, errtrace.Wrap(fmt.Errorf("%s:%d: duplicate key %q", file, line, qualified))
+			}
+			seen[qualified] = true
+
+			if err := cfg.set(qualified, value); err != nil {
+				return nil, errtrace.Wrap(fmt.Errorf("%s:%d: %w", file, line, err))
+			}
+		}
+
+		p.skipSpace(false)
+		if !p.eof() && !p.consume('\n') {
+			return nil, errtrace.Wrap(p.errorf("expected end of line"))
+		}
+	}
+
+	return cfg, nil
+}
+
+// set sets the value of a key in the config.
+func (c *config) set(key string, value any) error {
+	var err error
+	switch key {
+	case "packages.include":
+		c.Packages.Include, err = stringList(key, value)
+	case "packages.exclude":
+		c.Packages.Exclude, err = stringList(key, value)
+	case "files.include":
+		c.Files.Include, err = globList(key, value)
+	case "files.exclude":
+		c.Files.Exclude, err = globList(key, value)
+	case "generated":
+		s, ok := value.(string)
+		if !ok {
+			return errtrace.Wrap(fmt.Errorf("%v must be a string", key))
+		}
+		err = c.Generated.Set(s)
+	case "sentinels":
+		c.Sentinels, err = sentinelList(key, value)
+	case "default-sentinels":
+		b, ok := value.(bool)
+		if !ok {
+			return errtrace.Wrap(fmt.Errorf("%v must be a boolean", key))
+		}
+		c.NoDefaultSentinels = !b
+	case "no-wrapn":
+		b, ok := value.(bool)
+		if !ok {
+			return errtrace.Wrap(fmt.Errorf("%v must be a boolean", key))
+		}
+		c.NoWrapN = b
+	case "toolexec.required-packages":
+		c.RequiredPackages, err = stringList(key, value)
+	case "toolexec.unsafe-packages":
+		c.UnsafePackages, err = stringList(key, value)
+	default:
+		return errtrace.Wrap(fmt.Errorf("unknown key %q", key))
+	}
+	return errtrace.Wrap(err)
+}
+
+func stringList(key string, value any) ([]string, error) {
+	list, ok := value.([]string)
+	if !ok {
+		return nil, errtrace.Wrap(fmt.Errorf("%v must be an array of strings", key))
+	}
+	return list, nil
+}
+
+func sentinelList(key string, value any) ([]string, error) {
+	list, err := stringList(key, value)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	for _, s := range list {
+		if err := checkSentinel(s); err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+	}
+	return list, nil
+}
+
+// checkSentinel reports an error if s isn't in the form "import/path.Name".
+func checkSentinel(s string) error {
+	if i := strings.LastIndexByte(s, '.'); i <= 0 || i == len(s)-1 || strings.Contains(s[i:], "/") {
+		return errtrace.Wrap(fmt.Errorf("invalid sentinel %q: must be in the form import/path.Name", s))
+	}
+	return nil
+}
+
+func globList(key string, value any) ([]string, error) {
+	list, err := stringList(key, value)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	for _, pattern := range list {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, errtrace.Wrap(fmt.Errorf("invalid pattern %q: %w", pattern, err))
+		}
+	}
+	return list, nil
+}
+
+// tomlParser parses the subset of TOML supported by parseConfig.
+// The first error is recorded in err, and stops further parsing.
+type tomlParser struct {
+	file string
+	src  string
+	pos  int
+	line int
+	err  error
+}
+
+func (p *tomlParser) eof() bool {
+	return p.err != nil || p.pos >= len(p.src)
+}
+
+func (p *tomlParser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.src[p.pos]
+}
+
+func (p *tomlParser) consume(c byte) bool {
+	if p.peek() != c || p.eof() {
+		return false
+	}
+	p.pos++
+	if c == '\n' {
+		p.line++
+	}
+	return true
+}
+
+func (p *tomlParser) errorf(format string, args ...any) error {
+	if p.err == nil {
+		p.err = fmt.Errorf("%s:%d: %s", p.file, p.line, fmt.Sprintf(format, args...))
+	}
+	return errtrace.Wrap(p.err)
+}
+
+// skipSpace skips whitespace and comments,
+// and newlines if requested.
+func (p *tomlParser) skipSpace(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r':
+			p.pos++
+		case c == '\n' && newlines:
+			p.consume('\n')
+		case c == '#':
+			end := strings.IndexByte(p.src[p.pos:], '\n')
+			if end < 0 {
+				end = len(p.src) - p.pos
+			}
+			p.pos += end
+		default:
+			return
+		}
+	}
+}
+
+// key parses a bare or quoted key.
+func (p *tomlParser) key() string {
+	p.skipSpace(false)
+	if c := p.peek(); c == '"' || c == '\'' {
+		return p.str()
+	}
+
+	start := p.pos
+	for !p.eof() {
+		c := p.peek()
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9' || c == '-' || c == '_') {
+			break
+		}
+		p.pos++
+	}
+	if start == p.pos {
+		p.errorf("expected key")
+	}
+	key := p.src[start:p.pos]
+	p.skipSpace(false)
+	return key
+}
+
+// value parses a string, boolean, or array of strings.
+func (p *tomlParser) value() any {
+	switch c := p.peek(); {
+	case c == '"' || c == '\'':
+		return p.str()
+	case c == '[':
+		return p.array()
+	case strings.HasPrefix(p.src[p.pos:], "true"):
+		p.pos += len("true")
+		return true
+	case strings.HasPrefix(p.src[p.pos:], "false"):
+		p.pos += len("false")
+		return false
+	default:
+		p.errorf("expected a string, boolean, or array")
+		return nil
+	}
+}
+
+// array parses an array of strings, which may span multiple lines.
+func (p *tomlParser) array() []string {
+	p.consume('[')
+	list := []string{}
+	for {
+		p.skipSpace(true)
+		if p.consume(']') {
+			return list
+		}
+		if p.eof() {
+			break
+		}
+		if c := p.peek(); c != '"' && c != '\'' {
+			p.errorf("expected a string in array")
+			return nil
+		}
+		list = append(list, p.str())
+
+		p.skipSpace(true)
+		if p.consume(']') {
+			return list
+		}
+		if p.eof() {
+			break
+		}
+		if !p.consume(',') {
+			p.errorf("expected ',' or ']' in array")
+			return nil
+		}
+	}
+	p.errorf("unterminated array")
+	return nil
+}
+
+// str parses a basic ("...") or literal ('...') string.
+func (p *tomlParser) str() string {
+	quote := p.src[p.pos]
+	end := p.pos + 1
+	for end < len(p.src) && p.src[end] != quote && p.src[end] != '\n' {
+		if quote == '"' && p.src[end] == '\\' {
+			end++
+		}
+		end++
+	}
+	if end >= len(p.src) || p.src[end] != quote {
+		p.errorf("unterminated string")
+		return ""
+	}
+
+	lit := p.src[p.pos : end+1]
+	p.pos = end + 1
+	if quote == '\'' {
+		return lit[1 : len(lit)-1]
+	}
+
+	s, err := strconv.Unquote(lit)
+	if err != nil {
+		p.errorf("invalid string %s: %v", lit, err)
+	}
+	return s
+}
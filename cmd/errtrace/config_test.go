@@ -0,0 +1,467 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseConfig(t *testing.T) {
+	src := strings.Join([]string{
+		"# Top-level settings.",
+		`generated = "include"`,
+		`sentinels = ["example.com/foo.ErrDone", 'example.com/bar/v2.ErrStop'] # trailing comment`,
+		"default-sentinels = false",
+		"no-wrapn = true",
+		"",
+		"[packages]",
+		`include = [`,
+		`  "example.com/foo/...", # comment in array`,
+		`  "example.com/bar",`,
+		`]`,
+		`exclude = []`,
+		"",
+		"[files]",
+		`exclude = ["*.pb.go", "testdata/..."]`,
+		"",
+		"[toolexec]",
+		`required-packages = ["example.com/foo/..."]`,
+		`"unsafe-packages" = ["example.com/bar"]`,
+	}, "\n")
+
+	got, err := parseConfig("errtrace.toml", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got.Hash = "" // not interesting
+
+	want := &config{
+		Dir: ".",
+		Packages: patterns{
+			Include: []string{"example.com/foo/...", "example.com/bar"},
+			Exclude: []string{},
+		},
+		Files: patterns{
+			Exclude: []string{"*.pb.go", "testdata/..."},
+		},
+		Generated:          generatedInclude,
+		Sentinels:          []string{"example.com/foo.ErrDone", "example.com/bar/v2.ErrStop"},
+		NoWrapN:            true,
+		NoDefaultSentinels: true,
+		RequiredPackages:   []string{"example.com/foo/..."},
+		UnsafePackages:     []string{"example.com/bar"},
+	}
+	if !reflect.DeepEqual(want, got) {
+		t.Errorf("got: %+v\nwant: %+v", got, want)
+	}
+}
+
+func TestParseConfigErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		want string
+	}{
+		{
+			name: "unknown key",
+			src:  "\nfoo = true",
+			want: `errtrace.toml:2: unknown key "foo"`,
+		},
+		{
+			name: "unknown key in table",
+			src:  "[files]\ngenerated = 'skip'",
+			want: `errtrace.toml:2: unknown key "files.generated"`,
+		},
+		{
+			name: "unknown table",
+			src:  "[foo]",
+			want: `errtrace.toml:1: unknown table "foo"`,
+		},
+		{
+			name: "duplicate key",
+			src:  "no-wrapn = true\nno-wrapn = false",
+			want: `errtrace.toml:2: duplicate key "no-wrapn"`,
+		},
+		{
+			name: "wrong type",
+			src:  "no-wrapn = 'yes'",
+			want: "no-wrapn must be a boolean",
+		},
+		{
+			name: "not a list",
+			src:  "sentinels = 'io.EOF'",
+			want: "sentinels must be an array of strings",
+		},
+		{
+			name: "invalid generated policy",
+			src:  "generated = 'maybe'",
+			want: `invalid generated policy "maybe"`,
+		},
+		{
+			name: "invalid sentinel",
+			src:  "sentinels = ['EOF']",
+			want: `invalid sentinel "EOF"`,
+		},
+		{
+			name: "invalid glob",
+			src:  "[files]\nexclude = ['[']",
+			want: `invalid pattern "["`,
+		},
+		{
+			name: "missing equals",
+			src:  "no-wrapn true",
+			want: `errtrace.toml:1: expected '=' after key "no-wrapn"`,
+		},
+		{
+			name: "unsupported value",
+			src:  "no-wrapn = 1",
+			want: "expected a string, boolean, or array",
+		},
+		{
+			name: "unterminated string",
+			src:  "generated = 'skip",
+			want: "unterminated string",
+		},
+		{
+			name: "unterminated array",
+			src:  "sentinels = ['io.EOF',\n",
+			want: "errtrace.toml:2: unterminated array",
+		},
+		{
+			name: "default sentinels not a boolean",
+			src:  "default-sentinels = 'no'",
+			want: "default-sentinels must be a boolean",
+		},
+		{
+			name: "array of booleans",
+			src:  "sentinels = [true]",
+			want: "expected a string in array",
+		},
+		{
+			name: "trailing text",
+			src:  "no-wrapn = true false",
+			want: "expected end of line",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseConfig("errtrace.toml", []byte(tt.src))
+			if err == nil {
+				t.Fatalf("expected error %q", tt.want)
+			}
+			if got := err.Error(); !strings.Contains(got, tt.want) {
+				t.Errorf("error %q does not contain %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestConfigIncludes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "root")
+	cfg := &config{
+		Dir: dir,
+		Packages: patterns{
+			Include: []string{"example.com/foo/..."},
+			Exclude: []string{"example.com/foo/internal/mocks/..."},
+		},
+		Files: patterns{
+			Exclude: []string{"*_mock.go", "testdata/...", "sub/skip.go"},
+		},
+	}
+
+	packages := map[string]bool{
+		"example.com/foo":                      true,
+		"example.com/foo/bar":                  true,
+		"example.com/foobar":                   false,
+		"example.com/foo/internal/mocks":       false,
+		"example.com/foo/internal/mocks/inner": false,
+		"":                                     true, // unknown package
+	}
+	for pkg, want := range packages {
+		if got := cfg.IncludesPackage(pkg); got != want {
+			t.Errorf("IncludesPackage(%q) = %v, want %v", pkg, got, want)
+		}
+	}
+
+	files := map[string]bool{
+		"foo.go":                 true,
+		"foo_mock.go":            false,
+		"sub/foo_mock.go":        false,
+		"testdata/foo.go":        false,
+		"sub/testdata/foo.go":    true,
+		"sub/skip.go":            false,
+		"skip.go":                true,
+		"../outside/foo.go":      true,
+		"../outside/foo_mock.go": false,
+	}
+	for file, want := range files {
+		if got := cfg.IncludesFile(filepath.Join(dir, file)); got != want {
+			t.Errorf("IncludesFile(%q) = %v, want %v", file, got, want)
+		}
+	}
+}
+
+func TestConfigLoader(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		// Outside the module: ignored.
+		"errtrace.toml":       "no-wrapn = true\n",
+		"mod/go.mod":          "module example.com/mod // comment\n\ngo 1.21\n",
+		"mod/errtrace.toml":   "sentinels = ['example.com/mod.ErrRoot']\n",
+		"mod/a/a.go":          "package a\n",
+		"mod/b/errtrace.toml": "sentinels = ['example.com/mod/b.ErrB']\n",
+		"mod/b/c/c.go":        "package c\n",
+		"nomod/foo.go":        "package foo\n",
+	})
+
+	var loader configLoader
+	tests := []struct {
+		dir           string
+		wantPkgPath   string
+		wantSentinels []string
+		wantNoWrapN   bool
+	}{
+		{
+			dir:           "mod",
+			wantPkgPath:   "example.com/mod",
+			wantSentinels: []string{"example.com/mod.ErrRoot"},
+		},
+		{
+			dir:           "mod/a",
+			wantPkgPath:   "example.com/mod/a",
+			wantSentinels: []string{"example.com/mod.ErrRoot"},
+		},
+		{
+			dir:           "mod/b/c",
+			wantPkgPath:   "example.com/mod/b/c",
+			wantSentinels: []string{"example.com/mod/b.ErrB"},
+		},
+		{
+			// Not in a module, so the search continues to the root.
+			dir:         "nomod",
+			wantNoWrapN: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.dir, func(t *testing.T) {
+			dc, err := loader.Load(filepath.Join(root, tt.dir))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if dc.PkgPath != tt.wantPkgPath {
+				t.Errorf("PkgPath = %q, want %q", dc.PkgPath, tt.wantPkgPath)
+			}
+			if !reflect.DeepEqual(dc.Config.Sentinels, tt.wantSentinels) {
+				t.Errorf("Sentinels = %q, want %q", dc.Config.Sentinels, tt.wantSentinels)
+			}
+			if dc.Config.NoWrapN != tt.wantNoWrapN {
+				t.Errorf("NoWrapN = %v, want %v", dc.Config.NoWrapN, tt.wantNoWrapN)
+			}
+		})
+	}
+
+	t.Run("invalid config", func(t *testing.T) {
+		dir := t.TempDir()
+		writeFiles(t, dir, map[string]string{
+			"go.mod":        "module example.com/bad\n",
+			"errtrace.toml": "foo = true\n",
+		})
+
+		if _, err := loader.Load(dir); err == nil || !strings.Contains(err.Error(), `unknown key "foo"`) {
+			t.Errorf("expected unknown key error, got %v", err)
+		}
+	})
+}
+
+func TestConfigLoaderHashTree(t *testing.T) {
+	root := t.TempDir()
+	writeFiles(t, root, map[string]string{
+		"go.mod":        "module example.com/mod\n",
+		"errtrace.toml": "no-wrapn = true\n",
+		"a/a.go":        "package a\n",
+	})
+
+	hashTree := func() string {
+		t.Helper()
+
+		var loader configLoader // files are cached
+		hash, err := loader.HashTree(root)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	hash := hashTree()
+	tests := []struct {
+		name    string
+		file    string
+		changed bool
+	}{
+		{name: "nested", file: "a/b/errtrace.toml", changed: true},
+		{name: "testdata", file: "a/testdata/errtrace.toml"},
+		{name: "hidden", file: ".git/errtrace.toml"},
+		{name: "nested module", file: "sub/errtrace.toml"},
+	}
+	writeFiles(t, root, map[string]string{"sub/go.mod": "module example.com/sub\n"})
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeFiles(t, root, map[string]string{tt.file: "sentinels = ['example.com/mod.ErrFoo']\n"})
+			got := hashTree()
+			if changed := got != hash; changed != tt.changed {
+				t.Errorf("hash changed = %v, want %v", changed, tt.changed)
+			}
+			hash = got
+		})
+	}
+
+	t.Run("invalid config", func(t *testing.T) {
+		writeFiles(t, root, map[string]string{"a/errtrace.toml": "foo = true\n"})
+
+		var loader configLoader
+		if _, err := loader.HashTree(root); err == nil || !strings.Contains(err.Error(), `unknown key "foo"`) {
+			t.Errorf("expected unknown key error, got %v", err)
+		}
+	})
+}
+
+func TestConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/foo\ngo 1.21\n",
+		"errtrace.toml": strings.Join([]string{
+			`sentinels = ["example.com/foo.ErrDone"]`,
+			"[packages]",
+			`exclude = ["example.com/foo/internal/..."]`,
+			"[files]",
+			`exclude = ["*_mock.go"]`,
+		}, "\n"),
+		"foo.go": strings.Join([]string{
+			"package foo",
+			`import "errors"`,
+			`var ErrDone = errors.New("done")`,
+			"func foo() error {",
+			"	return ErrDone",
+			"}",
+		}, "\n"),
+		"bar.go": strings.Join([]string{
+			"package foo",
+			"func bar() error {",
+			"	return foo()",
+			"}",
+		}, "\n"),
+		"bar_mock.go": strings.Join([]string{
+			"package foo",
+			"func barMock() error {",
+			"	return foo()",
+			"}",
+		}, "\n"),
+		"gen.go": strings.Join([]string{
+			"// Code generated by hand. DO NOT EDIT.",
+			"",
+			"package foo",
+			"func gen() error {",
+			"	return foo()",
+			"}",
+		}, "\n"),
+		"internal/baz/baz.go": strings.Join([]string{
+			"package baz",
+			"func baz() error {",
+			"	return baz()",
+			"}",
+		}, "\n"),
+	})
+
+	defer chdir(t, dir)()
+
+	t.Run("list", func(t *testing.T) {
+		var out bytes.Buffer
+		exitCode := (&mainCmd{
+			Stdout: &out,
+			Stderr: testWriter{t},
+		}).Run([]string{"-l", "./..."})
+		if want := 0; exitCode != want {
+			t.Errorf("exit code = %d, want %d", exitCode, want)
+		}
+
+		if want, got := "bar.go\n", out.String(); got != want {
+			t.Errorf("got:\n%s\nwant:\n%s", indent(got), indent(want))
+		}
+	})
+
+	t.Run("stdout", func(t *testing.T) {
+		// Skipped files are printed unchanged.
+		for _, file := range []string{"gen.go", "bar_mock.go", "foo.go"} {
+			var out bytes.Buffer
+			exitCode := (&mainCmd{
+				Stdout: &out,
+				Stderr: testWriter{t},
+			}).Run([]string{file})
+			if want := 0; exitCode != want {
+				t.Errorf("exit code = %d, want %d", exitCode, want)
+			}
+
+			want, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := out.String(); got != string(want) {
+				t.Errorf("%v: got:\n%s\nwant:\n%s", file, indent(got), indent(string(want)))
+			}
+		}
+	})
+}
+
+func TestToolExecConfig(t *testing.T) {
+	p := toolExecParams{
+		RequiredPkgSelectors: []string{"example.com/foo"},
+		UnsafePkgSelectors:   []string{""},
+	}
+	keyWithoutConfig := p.versionCacheKey()
+
+	p.configHash = "abc"
+	if p.versionCacheKey() == keyWithoutConfig {
+		t.Errorf("versionCacheKey should change with the config")
+	}
+
+	p.config = &config{
+		RequiredPackages: []string{"example.com/bar/..."},
+		UnsafePackages:   []string{"example.com/baz"},
+	}
+
+	for _, pkg := range []string{"example.com/foo", "example.com/bar", "example.com/bar/qux"} {
+		if !p.requiredPackage(pkg) {
+			t.Errorf("requiredPackage(%q) = false, want true", pkg)
+		}
+	}
+	if p.requiredPackage("example.com/baz") {
+		t.Errorf("requiredPackage(%q) = true, want false", "example.com/baz")
+	}
+
+	if !p.unsafeRewrite("example.com/baz") {
+		t.Errorf("unsafeRewrite(%q) = false, want true", "example.com/baz")
+	}
+	if p.unsafeRewrite("example.com/foo") {
+		t.Errorf("unsafeRewrite(%q) = true, want false", "example.com/foo")
+	}
+}
+
+func writeFiles(t testing.TB, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, src := range files {
+		dst := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(dst), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(dst, []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -21,9 +21,178 @@
 //
 //	-format
 //	      whether to format ouput; one of: [auto, always, never].
-//	      auto is the default and will format if the output is being written to a file.
+//	      auto is the default and will format if the output is being written to a file
+//	      or printed as a diff.
 //	-w    write result to the given source files instead of stdout.
 //	-l    list files that would be modified without making any changes.
+//	-d    print a unified diff of the changes instead of the rewritten source.
+//	-types
+//	      type-check packages to find error results.
+//	-nil-check
+//	      with -types, check concrete error values for nil before wrapping them.
+//	-remove
+//	      remove errtrace instrumentation instead of adding it.
+//	-check
+//	      report errors that aren't wrapped and other problems without making any changes.
+//	      Exits with a non-zero status if anything was reported.
+//	-json with -check, print one JSON object per line for each problem.
+//	-include-generated
+//	      instrument generated files, which are skipped by default.
+//	-sentinels
+//	      comma-separated list of errors to return as-is, e.g. example.com/store.ErrNotFound.
+//	-v    log decisions made about each file, e.g. sentinel errors left unwrapped.
+//
+// Generated files are files with a comment like
+// "// Code generated by foo. DO NOT EDIT." before the package clause.
+// They're left unchanged unless -include-generated is used.
+//
+// By default, errtrace finds error results syntactically:
+// they must be spelled 'error'.
+// With -types, errtrace type-checks the packages the files belong to,
+// and also instruments results that are aliases of error
+// or named interfaces that are equivalent to it.
+// Files that can't be type-checked with a package,
+// e.g. because the package has type errors
+// or the files are excluded by build constraints,
+// fall back to the default behavior with a warning.
+//
+// With -types, errtrace also reports functions
+// that return concrete error types (e.g. *MyError).
+// Errors returned by them are not traced
+// because wrapping them would change their type.
+// Callers that return these values as an error
+// turn a nil *MyError into a non-nil error, with or without errtrace.
+// Use -nil-check to rewrite these returns to check for nil explicitly
+// and trace only non-nil values:
+//
+//	return newMyError()
+//	// becomes
+//	{ r1 := newMyError(); if r1 == nil { return r1 }; return errtrace.Wrap(r1) }
+//
+// Nil values are returned unchanged, so a nil *MyError
+// is still returned as a non-nil error.
+//
+// # Configuration
+//
+// errtrace reads its configuration from an errtrace.toml file
+// in the directory of each file or package, or its parents
+// up to the root of the module.
+// The same files are used with -toolexec.
+//
+//	[packages] # import paths or patterns like "foo/..."
+//	include = ["example.com/app/..."]
+//	exclude = ["example.com/app/internal/mocks/..."]
+//
+//	[files] # globs relative to errtrace.toml
+//	exclude = ["*.pb.go", "testdata/..."]
+//
+//	generated = "include" # or "skip" (default)
+//	sentinels = ["example.com/app/store.ErrNotFound"]
+//	no-wrapn = true
+//
+//	[toolexec]
+//	required-packages = ["example.com/app/..."]
+//	unsafe-packages = []
+//
+// Excluded files are left unchanged,
+// and so are generated files unless generated = "include".
+//
+// # Sentinel errors
+//
+// Some errors are returned as-is because callers compare them with ==.
+// By default, these are the following errors from the standard library:
+//
+//	database/sql.ErrNoRows
+//	io.EOF
+//	io.ErrUnexpectedEOF
+//	io/fs.SkipAll
+//	io/fs.SkipDir
+//	net/http.ErrUseLastResponse
+//	path/filepath.SkipAll
+//	path/filepath.SkipDir
+//
+// Add others with the -sentinels flag or the sentinels setting,
+// and use default-sentinels = false to wrap the errors above.
+// A return is left unwrapped only if it names the sentinel directly,
+// e.g. 'return 0, io.EOF'.
+//
+// errtrace also recognizes functions whose callers compare errors with ==
+// by their signature: Read and Write methods that implement
+// io.Reader and io.Writer, and fs.WalkDirFunc and filepath.WalkFunc callbacks.
+// In these functions, only errors created by the return statement
+// with errors.New, fmt.Errorf, or a composite literal are wrapped.
+//
+// Use -v to log each return that's left unwrapped.
+//
+// # Checking instrumentation
+//
+//	errtrace -check [-json] [options] <source files | patterns>
+//
+// This will report the following problems without changing any files,
+// and exit with a non-zero status if there are any:
+//
+//   - unwrapped: errors that errtrace would wrap
+//   - skipped: return sites that errtrace can't instrument,
+//     e.g. in functions with multiple error results
+//   - concrete-error: concrete error types reported by -types
+//   - unused-skip: //errtrace:skip directives that don't apply to anything
+//
+// An //errtrace:skip directive also silences problems reported on its line.
+// In the doc comment of a function, or on the line before a function literal,
+// //errtrace:skip opts out the whole function,
+// and //errtrace:skipfile anywhere in a file opts out the whole file.
+// These are reported as unused-skip if they don't skip anything.
+// With -json, each problem is printed as a JSON object on its own line
+// with the fields file, line, column, category, and message.
+//
+// # Removing instrumentation
+//
+//	errtrace -remove [options] <source files | patterns>
+//
+// This will undo the changes made by errtrace:
+// calls to errtrace.Wrap and WrapN are replaced by their arguments,
+// blocks generated for -no-wrapn and -nil-check become return statements again,
+// and the errtrace import is dropped if it's no longer used.
+// Other uses of errtrace, e.g. errtrace.New, are kept.
+// The -w, -l, -d, and -format flags work as usual.
+//
+// # Aggregating traces
+//
+//	errtrace aggregate [options] [log files]
+//
+// This will find return traces printed by errtrace in the given log files
+// (or the standard input if none are given),
+// group them by the path the error took through the program,
+// and print a report of the groups with the most frequent first.
+// For each group, the report includes the number of occurrences,
+// where and when it was first and last seen,
+// and the trace of its first occurrence.
+//
+// Traces may be printed as-is, prefixed by a timestamp or other log prefix,
+// or embedded inside JSON log entries (e.g. from log/slog's JSONHandler).
+// The timestamp and log level are not part of the message,
+// even if the message spans multiple lines.
+//
+// Use the following flags to control the output:
+//
+//	-json print the report as JSON.
+//
+// # Viewing traces
+//
+//	errtrace view [options] [file]
+//
+// This will read a trace printed by errtrace from the given file
+// (or the standard input if none is given),
+// and print it with a few lines of source code around each return site.
+// Source files are looked up inside the current module
+// if the paths in the trace don't exist on this machine.
+//
+// Use the following flags to control the output:
+//
+//	-C    number of lines of source to show before and after each return site.
+//	-color
+//	      whether to colorize output; one of: [auto, always, never].
+//	      auto is the default and will colorize if the output is a terminal.
 package main
 
 import (
@@ -41,12 +210,13 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
+	"slices"
 	"sort"
-	"strconv"
 	"strings"
 
 	"braces.dev/errtrace"
+	"braces.dev/errtrace/internal/diff"
+	"braces.dev/errtrace/internal/rewrite"
 )
 
 const errtracePkgImport = "braces.dev/errtrace"
@@ -65,17 +235,28 @@ func main() {
 type mainParams struct {
 	Write    bool     // -w
 	List     bool     // -l
+	Diff     bool     // -d
 	Format   format   // -format
 	NoWrapN  bool     // -no-wrapn
+	Types    bool     // -types
+	NilCheck bool     // -nil-check
+	Remove   bool     // -remove
+	Check    bool     // -check
+	JSON     bool     // -json
+	Verbose  bool     // -v
 	Patterns []string // list of files to process
 
+	Sentinels []string // -sentinels
+
+	IncludeGenerated bool // -include-generated
+
 	ImplicitStdin bool // whether stdin was picked because there were no args
 }
 
 func (p *mainParams) shouldFormat() bool {
 	switch p.Format {
 	case formatAuto:
-		return p.Write
+		return p.Write || p.Diff
 	case formatAlways:
 		return true
 	case formatNever:
@@ -94,19 +275,62 @@ func (p *mainParams) Parse(w io.Writer, args []string) error {
 	}
 
 	flag.Var(&p.Format, "format", "whether to format ouput; one of: [auto, always, never].\n"+
-		"auto is the default and will format if the output is being written to a file.")
+		"auto is the default and will format if the output is being written to a file\n"+
+		"or printed as a diff.")
 	flag.BoolVar(&p.Write, "w", false,
 		"write result to the given source files instead of stdout.")
 	flag.BoolVar(&p.List, "l", false,
 		"list files that would be modified without making any changes.")
+	flag.BoolVar(&p.Diff, "d", false,
+		"print a unified diff of the changes instead of the rewritten source.")
 	flag.BoolVar(&p.NoWrapN, "no-wrapn", false,
 		"wrap multiple return values without using errtrace.WrapN",
 	)
+	flag.BoolVar(&p.Types, "types", false,
+		"type-check packages to find error results.")
+	flag.BoolVar(&p.NilCheck, "nil-check", false,
+		"with -types, check concrete error values for nil before wrapping them.")
+	flag.BoolVar(&p.Remove, "remove", false,
+		"remove errtrace instrumentation instead of adding it.")
+	flag.BoolVar(&p.Check, "check", false,
+		"report errors that aren't wrapped and other problems without making any changes.\n"+
+			"Exits with a non-zero status if anything was reported.")
+	flag.BoolVar(&p.JSON, "json", false,
+		"with -check, print one JSON object per line for each problem.")
+	flag.BoolVar(&p.IncludeGenerated, "include-generated", false,
+		"instrument generated files, which are skipped by default.")
+	var sentinels string
+	flag.StringVar(&sentinels, "sentinels", "",
+		"comma-separated list of errors to return as-is, e.g. example.com/store.ErrNotFound.")
+	flag.BoolVar(&p.Verbose, "v", false,
+		"log decisions made about each file, e.g. sentinel errors left unwrapped.")
 
 	if err := flag.Parse(args); err != nil {
 		return errtrace.Wrap(err)
 	}
 
+	if sentinels != "" {
+		p.Sentinels = strings.Split(sentinels, ",")
+		for _, s := range p.Sentinels {
+			if err := checkSentinel(s); err != nil {
+				return errtrace.Wrap(err)
+			}
+		}
+	}
+
+	if p.NilCheck && !p.Types {
+		return errtrace.Wrap(errors.New("-nil-check requires -types"))
+	}
+	if p.Remove && p.Types {
+		return errtrace.Wrap(errors.New("-remove can't be used with -types"))
+	}
+	if p.Check && (p.Write || p.List || p.Diff || p.Remove) {
+		return errtrace.Wrap(errors.New("-check can't be used with -w, -l, -d, or -remove"))
+	}
+	if p.JSON && !p.Check {
+		return errtrace.Wrap(errors.New("-json requires -check"))
+	}
+
 	p.Patterns = flag.Args()
 	if len(p.Patterns) == 0 {
 		// Read file from stdin when there's no args, similar to gofmt.
@@ -182,6 +406,12 @@ type mainCmd struct {
 	Getenv func(string) string
 
 	log *log.Logger
+
+	// configs holds the errtrace.toml files used by each directory.
+	configs configLoader
+
+	// reported is set if -check reported any diagnostics.
+	reported bool
 }
 
 func (cmd *mainCmd) Run(args []string) (exitCode int) {
@@ -191,6 +421,15 @@ func (cmd *mainCmd) Run(args []string) (exitCode int) {
 		return exitCode
 	}
 
+	if len(args) > 0 {
+		switch args[0] {
+		case "aggregate":
+			return cmd.runAggregate(args[1:])
+		case "view":
+			return cmd.runView(args[1:])
+		}
+	}
+
 	var p mainParams
 	if err := p.Parse(cmd.Stderr, args); err != nil {
 		if errors.Is(err, flag.ErrHelp) {
@@ -206,6 +445,18 @@ func (cmd *mainCmd) Run(args []string) (exitCode int) {
 		return 1
 	}
 
+	var (
+		typed    map[string]*rewrite.File // by absolute path
+		typeErrs map[string]error         // by absolute path
+	)
+	if p.Types {
+		typed, typeErrs, err = loadTypes(files)
+		if err != nil {
+			cmd.log.Printf("errtrace: %+v", err)
+			return 1
+		}
+	}
+
 	// Paths will be printed relative to CWD.
 	// Paths outside it will be printed as-is.
 	var workDir string
@@ -224,23 +475,61 @@ func (cmd *mainCmd) Run(args []string) (exitCode int) {
 			display = "stdin"
 		}
 
+		// Files given explicitly and stdin
+		// use the configuration of the current directory.
+		dir := "."
+		if file != "-" {
+			dir = filepath.Dir(file)
+		}
+		dc, err := cmd.configs.Load(dir)
+		if err != nil {
+			cmd.log.Printf("errtrace: %+v", err)
+			exitCode = 1
+			continue
+		}
+		cfg := dc.Config
+
 		req := fileRequest{
 			Format:        p.shouldFormat(),
 			Write:         p.Write,
 			List:          p.List,
+			Diff:          p.Diff,
+			Remove:        p.Remove,
+			Check:         p.Check,
+			JSON:          p.JSON,
+			Verbose:       p.Verbose,
 			Filename:      display,
 			Filepath:      file,
 			ImplicitStdin: p.ImplicitStdin,
-			RewriteOpts: rewriteOpts{
-				NoWrapN: p.NoWrapN,
+			Types:         p.Types,
+			SkipGenerated: cfg.Generated == generatedSkip && !p.IncludeGenerated,
+			RewriteOpts: rewrite.Options{
+				NoWrapN:            p.NoWrapN || cfg.NoWrapN,
+				NilCheck:           p.NilCheck,
+				Sentinels:          append(slices.Clip(cfg.Sentinels), p.Sentinels...),
+				NoDefaultSentinels: cfg.NoDefaultSentinels,
+				PkgPath:            dc.PkgPath,
 			},
 		}
+		if file != "-" {
+			if abs, err := filepath.Abs(file); err == nil {
+				req.Excluded = !cfg.IncludesPackage(dc.PkgPath) || !cfg.IncludesFile(abs)
+				if p.Types {
+					req.Typed = typed[abs]
+					req.TypeErr = typeErrs[abs]
+				}
+			}
+		}
 		if err := cmd.processFile(req); err != nil {
 			cmd.log.Printf("%s:%+v", display, err)
 			exitCode = 1
 		}
 	}
 
+	if cmd.reported {
+		exitCode = 1
+	}
+
 	return exitCode
 }
 
@@ -341,16 +630,29 @@ type fileRequest struct {
 	Format      bool
 	Write       bool
 	List        bool
-	RewriteOpts rewriteOpts
+	Diff        bool
+	Remove      bool
+	Check       bool
+	JSON        bool
+	Verbose     bool
+	RewriteOpts rewrite.Options
 
 	Filename string // name displayed to the user
 	Filepath string // actual location on disk, or "-" for stdin
 
 	ImplicitStdin bool
-}
 
-type rewriteOpts struct {
-	NoWrapN bool
+	// Excluded is set if errtrace.toml excludes the file.
+	// SkipGenerated is set if generated files should be left unchanged.
+	Excluded      bool
+	SkipGenerated bool
+
+	// Types requests type information for the file.
+	// Typed holds the file if it was type-checked with its package,
+	// and TypeErr holds the error if its package failed to type-check.
+	Types   bool
+	Typed   *rewrite.File
+	TypeErr error
 }
 
 // processFile processes a single file.
@@ -362,866 +664,207 @@ type rewriteOpts struct {
 // The collected information is used to pick a package name,
 // whether we need an import, etc. and *then* the edits are applied.
 func (cmd *mainCmd) processFile(r fileRequest) error {
-	src, err := cmd.readFile(r)
-	if err != nil {
-		return errtrace.Wrap(err)
-	}
-
-	parsed, err := cmd.parseFile(r.Filename, src, r.RewriteOpts)
-	if err != nil {
-		return errtrace.Wrap(err)
+	if r.Remove {
+		return errtrace.Wrap(cmd.processRemove(r))
 	}
 
-	for _, line := range parsed.unusedOptouts {
-		cmd.log.Printf("%s:%d:unused errtrace:skip", r.Filename, line)
-	}
-	if r.List {
-		if len(parsed.inserts) > 0 {
-			_, err = fmt.Fprintf(cmd.Stdout, "%s\n", r.Filename)
-		}
-		return errtrace.Wrap(err)
-	}
-
-	var out bytes.Buffer
-	if err := cmd.rewriteFile(parsed, &out); err != nil {
-		return errtrace.Wrap(err)
+	sf := r.Typed
+	if sf != nil && cmd.skipFile(r, sf.Src) {
+		return errtrace.Wrap(cmd.passThrough(r, sf.Src))
 	}
-
-	outSrc := out.Bytes()
-	if r.Format {
-		outSrc, err = gofmt.Source(outSrc)
+	if sf == nil {
+		src, err := cmd.readFile(r)
 		if err != nil {
-			return errtrace.Wrap(fmt.Errorf("format: %w", err))
+			return errtrace.Wrap(err)
+		}
+		if cmd.skipFile(r, src) {
+			return errtrace.Wrap(cmd.passThrough(r, src))
+		}
+
+		if r.TypeErr != nil {
+			// The file's package doesn't type-check.
+			// Fall back to finding errors syntactically.
+			cmd.log.Printf("%s:no type information: %v", r.Filename, r.TypeErr)
+		} else if r.Types {
+			// The file isn't part of a package that we could load,
+			// e.g. stdin or files excluded by build constraints.
+			// Try to type-check it on its own,
+			// and fall back to finding errors syntactically.
+			sf, err = typeCheckFile(r.Filename, src)
+			if err != nil {
+				cmd.log.Printf("%s:no type information: %v", r.Filename, err)
+			}
 		}
-	}
-
-	if r.Write {
-		err = os.WriteFile(r.Filename, outSrc, 0o644)
-	} else {
-		_, err = cmd.Stdout.Write(outSrc)
-	}
-	return errtrace.Wrap(err)
-}
-
-type parsedFile struct {
-	src  []byte
-	fset *token.FileSet
-	file *ast.File
-
-	errtracePkg     string
-	importsErrtrace bool // includes blank imports
-	inserts         []insert
-	unusedOptouts   []int // list of line numbers
-
-	// Used for toolexec unsafe mode, when rewriting packages that don't import
-	// errtrace, so we use go:linkname wrappers.
-	errtraceUnsafePrefix string
-}
-
-func (f *parsedFile) errtracePkgPrefix() string {
-	if f.errtraceUnsafePrefix == "" {
-		return fmt.Sprintf("%s.", f.errtracePkg)
-	}
-	return f.errtraceUnsafePrefix
-}
-
-func (cmd *mainCmd) parseFile(filename string, src []byte, opts rewriteOpts) (parsedFile, error) {
-	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
-	if err != nil {
-		return parsedFile{}, errtrace.Wrap(err)
-	}
-
-	errtracePkg := "errtrace"  // name to use for errtrace package
-	var importsErrtrace bool   // whether there's any errtrace import, including blank imports
-	needErrtraceImport := true // whether to add a new import.
-	for _, imp := range f.Imports {
-		if imp.Path.Value == `"`+errtracePkgImport+`"` {
-			importsErrtrace = true
-			if imp.Name != nil {
-				if imp.Name.Name == "_" {
-					// Can't use a blank import, keep processing imports.
-					continue
-				}
-				// If the file already imports errtrace,
-				// we'll want to use the name it's imported under.
-				errtracePkg = imp.Name.Name
+		if sf == nil {
+			sf, err = rewrite.ParseFile(r.Filename, src)
+			if err != nil {
+				return errtrace.Wrap(err)
 			}
-			needErrtraceImport = false
-			break
 		}
 	}
 
-	if needErrtraceImport {
-		// If the file doesn't import errtrace already,
-		// do a quick check to find an unused identifier name.
-		idents := make(map[string]struct{})
-		ast.Inspect(f, func(n ast.Node) bool {
-			if ident, ok := n.(*ast.Ident); ok {
-				idents[ident.Name] = struct{}{}
-			}
-			return true
-		})
-
-		// Pick a name that isn't already used.
-		// Prefer "errtrace" if it's available.
-		for i := 1; ; i++ {
-			candidate := errtracePkg
-			if i > 1 {
-				candidate += strconv.Itoa(i)
-			}
-
-			if _, ok := idents[candidate]; !ok {
-				errtracePkg = candidate
-				break
-			}
+	parsed := rewrite.Inspect(sf, r.RewriteOpts)
+	if r.Verbose {
+		for _, d := range parsed.Notes {
+			cmd.log.Print(d)
 		}
 	}
-
-	var inserts []insert
-	w := walker{
-		fset:        fset,
-		optouts:     optoutLines(fset, f.Comments),
-		errtracePkg: errtracePkg,
-		logger:      cmd.log,
-		inserts:     &inserts,
-		opts:        opts,
+	if r.Check {
+		return errtrace.Wrap(cmd.reportDiagnostics(r, parsed))
 	}
-	ast.Walk(&w, f)
-
-	// Look for unused optouts and warn about them.
-	var unusedOptouts []int
-	if len(w.optouts) > 0 {
-		unusedOptouts = make([]int, 0, len(w.optouts))
-		for line, used := range w.optouts {
-			if used == 0 {
-				unusedOptouts = append(unusedOptouts, line)
-			}
-		}
-		sort.Ints(unusedOptouts)
+	for _, d := range parsed.Diagnostics {
+		cmd.log.Print(d)
 	}
 
-	// If errtrace isn't imported, but at least one insert was made,
-	// we'll need to import errtrace.
-	// Add an import declaration to the file.
-	if needErrtraceImport && len(inserts) > 0 {
-		// We want to insert the import after the last existing import.
-		// If the last import is part of a group, we'll make it part of the group.
-		//
-		//	import (
-		//		"foo"
-		//	)
-		//	// becomes
-		//	import (
-		//		"foo"; "brace.dev/errtrace"
-		//	)
-		//
-		// Otherwise, we'll add a new import statement group.
-		//
-		//	import "foo"
-		//	// becomes
-		//	import "foo"; import "brace.dev/errtrace"
-		var (
-			lastImportSpec *ast.ImportSpec
-			lastImportDecl *ast.GenDecl
-		)
-		for _, imp := range f.Decls {
-			decl, ok := imp.(*ast.GenDecl)
-			if !ok || decl.Tok != token.IMPORT {
-				break
-			}
-			lastImportDecl = decl
-			if decl.Lparen.IsValid() && len(decl.Specs) > 0 {
-				// There's an import group.
-				lastImportSpec, _ = decl.Specs[len(decl.Specs)-1].(*ast.ImportSpec)
-			}
-		}
-
-		var i insertImportErrtrace
-		switch {
-		case lastImportSpec != nil:
-			// import ("foo")
-			i.At = lastImportSpec.End()
-		case lastImportDecl != nil:
-			// import "foo"
-			i.At = lastImportDecl.End()
-			i.AddKeyword = true
-		default:
-			// package foo
-			i.At = f.Name.End()
-			i.AddKeyword = true
+	if r.List {
+		var err error
+		if parsed.Changed() {
+			_, err = fmt.Fprintf(cmd.Stdout, "%s\n", r.Filename)
 		}
-		inserts = append(inserts, &i)
+		return errtrace.Wrap(err)
 	}
 
-	sort.Slice(inserts, func(i, j int) bool {
-		return inserts[i].Pos() < inserts[j].Pos()
-	})
-
-	return parsedFile{
-		src:             src,
-		fset:            fset,
-		file:            f,
-		errtracePkg:     errtracePkg,
-		importsErrtrace: importsErrtrace,
-		inserts:         inserts,
-		unusedOptouts:   unusedOptouts,
-	}, nil
+	return errtrace.Wrap(cmd.writeOutput(r, sf.Src, parsed.Rewrite()))
 }
 
-func (cmd *mainCmd) rewriteFile(f parsedFile, out *bytes.Buffer) error {
-	var lastOffset int
-	filePos := f.fset.File(f.file.Pos()) // position information for this file
-	for _, it := range f.inserts {
-		offset := filePos.Offset(it.Pos())
-		_, _ = out.Write(f.src[lastOffset:offset])
-		lastOffset = offset
-
-		switch it := it.(type) {
-		case *insertImportErrtrace:
-			_, _ = io.WriteString(out, "; ")
-			if it.AddKeyword {
-				_, _ = io.WriteString(out, "import ")
-			}
-
-			if f.errtraceUnsafePrefix != "" {
-				// unsafe mode uses go:link, which requires importing "unsafe" instead
-				// of errtrace.  Since duplicate blank imports are allowed by Go, we can
-				// always add it, without checking if it has been imported.
-				fmt.Fprint(out, `_ "unsafe"`)
-			} else if f.errtracePkg == "errtrace" {
-				// Don't use named imports if we're using the default name.
-				fmt.Fprintf(out, "%q", errtracePkgImport)
-			} else {
-				fmt.Fprintf(out, "%s %q", f.errtracePkg, errtracePkgImport)
-			}
-
-		case *insertWrapOpen:
-			fmt.Fprintf(out, "%sWrap", f.errtracePkgPrefix())
-			if it.N > 1 {
-				fmt.Fprintf(out, "%d", it.N)
-			}
-			_, _ = out.WriteString("(")
-
-		case *insertWrapClose:
-			_, _ = out.WriteString(")")
-
-		case *insertReturnNBlockStart:
-			vars := nVars("r", it.N)
-			fmt.Fprintf(out, "{ %s := ", strings.Join(vars, ", "))
-
-			// Update last offset, so we skip writing the "return", as it's
-			// followed by the expression we want to assign to.
-			// The "return" is added in insertReturnNBlockClose.
-			lastOffset = filePos.Offset(it.SkipReturn)
-
-		case *insertReturnNBlockClose:
-			vars := nVars("r", it.N) // must match insertReturnNBlockStart
-
-			// Last return is an error, wrap it.
-			last := &vars[len(vars)-1]
-			*last = fmt.Sprintf("%sWrap(%v)", f.errtracePkgPrefix(), *last)
-
-			fmt.Fprintf(out, "; return %s }", strings.Join(vars, ", "))
-
-		case *insertWrapAssign:
-			// Turns this:
-			//	return
-			// Into this:
-			//	x, y = errtrace.Wrap(x), errtrace.Wrap(y); return
-			for i, name := range it.Names {
-				if i > 0 {
-					_, _ = out.WriteString(", ")
-				}
-				fmt.Fprintf(out, "%s", name)
-			}
-			_, _ = out.WriteString(" = ")
-			for i, name := range it.Names {
-				if i > 0 {
-					_, _ = out.WriteString(", ")
-				}
-				fmt.Fprintf(out, "%sWrap(%s)", f.errtracePkgPrefix(), name)
-			}
-			_, _ = out.WriteString("; ")
-
-		default:
-			cmd.log.Panicf("unhandled insertion type %T", it)
-		}
-	}
-	_, _ = out.Write(f.src[lastOffset:]) // flush remaining
-	return nil
-}
-
-func (cmd *mainCmd) readFile(r fileRequest) ([]byte, error) {
-	if r.Filepath != "-" {
-		return errtrace.Wrap2(os.ReadFile(r.Filename))
-	}
-
-	if r.Write {
-		return nil, errtrace.Wrap(fmt.Errorf("can't use -w with stdin"))
-	}
+// reportDiagnostics prints all diagnostics for a file to stdout (-check),
+// including errors that would be wrapped.
+func (cmd *mainCmd) reportDiagnostics(r fileRequest, parsed *rewrite.Result) error {
+	diags := append(parsed.Unwrapped(), parsed.Diagnostics...)
+	sort.SliceStable(diags, func(i, j int) bool {
+		return diags[i].Pos.Offset < diags[j].Pos.Offset
+	})
 
-	if r.ImplicitStdin {
-		// Running with no args reads from stdin, but this is not obvious
-		// so print a usage hint to stderr, if we think stdin is a TTY.
-		// Best-effort check for a TTY by looking for a character device.
-		type statter interface {
-			Stat() (os.FileInfo, error)
+	enc := json.NewEncoder(cmd.Stdout)
+	for _, d := range diags {
+		cmd.reported = true
+
+		var err error
+		if r.JSON {
+			err = enc.Encode(diagnosticJSON{
+				File:     d.Pos.Filename,
+				Line:     d.Pos.Line,
+				Column:   d.Pos.Column,
+				Category: d.Category,
+				Message:  d.Message,
+			})
+		} else {
+			_, err = fmt.Fprintln(cmd.Stdout, d)
 		}
-		if st, ok := cmd.Stdin.(statter); ok {
-			if fi, err := st.Stat(); err == nil &&
-				fi.Mode()&os.ModeCharDevice == os.ModeCharDevice {
-				cmd.log.Println("reading from stdin; use '-h' for help")
-			}
+		if err != nil {
+			return errtrace.Wrap(err)
 		}
 	}
-
-	return errtrace.Wrap2(io.ReadAll(cmd.Stdin))
-}
-
-type walker struct {
-	// Inputs
-
-	fset        *token.FileSet // file set for positional information
-	errtracePkg string         // name of the errtrace package
-	logger      *log.Logger
-	opts        rewriteOpts
-
-	optouts map[int]int // map from line to number of uses
-
-	// Outputs
-
-	// inserts is the list of inserts to make.
-	inserts *[]insert
-
-	// State
-
-	// Function information:
-
-	numReturns   int                      // number of return values
-	errorIdents  []*ast.Ident             // identifiers for error return values (only if unnamed returns)
-	errorObjs    map[*ast.Object]struct{} // objects for error return values (only if named returns)
-	errorIndices []int                    // indices of error return values
-
-	// Block information:
-
-	// Errors that are wrapped in this block.
-	alreadyWrapped map[*ast.Object]struct{}
-	// The logic to detect re-wraps is pretty simplistic
-	// since it doesn't do any control flow analysis.
-	// If this becomes a necessity, we can add it later.
+	return nil
 }
 
-var _ ast.Visitor = (*walker)(nil)
-
-func (t *walker) logf(pos token.Pos, format string, args ...interface{}) {
-	msg := fmt.Sprintf(format, args...)
-	t.logger.Printf("%s:%s", t.fset.Position(pos), msg)
+// diagnosticJSON is the JSON representation of a diagnostic (-check -json).
+type diagnosticJSON struct {
+	File     string           `json:"file"`
+	Line     int              `json:"line"`
+	Column   int              `json:"column"`
+	Category rewrite.Category `json:"category"`
+	Message  string           `json:"message"`
 }
 
-func (t *walker) Visit(n ast.Node) ast.Visitor {
-	switch n := n.(type) {
-	case *ast.FuncDecl:
-		return t.funcType(n, n.Type)
-
-	case *ast.BlockStmt:
-		newT := *t
-		newT.alreadyWrapped = make(map[*ast.Object]struct{})
-		return &newT
-
-	case *ast.AssignStmt:
-		t.assignStmt(n)
-
-	case *ast.DeferStmt:
-		// This is a bit inefficient;
-		// we'll recurse into the DeferStmt's function literal (if any) twice.
-		t.deferStmt(n)
-
-	case *ast.FuncLit:
-		return t.funcType(n, n.Type)
-
-	case *ast.ReturnStmt:
-		return t.returnStmt(n)
+// processRemove removes errtrace instrumentation from a file (-remove).
+func (cmd *mainCmd) processRemove(r fileRequest) error {
+	src, err := cmd.readFile(r)
+	if err != nil {
+		return errtrace.Wrap(err)
 	}
-
-	return t
-}
-
-func (t *walker) funcType(parent ast.Node, ft *ast.FuncType) ast.Visitor {
-	// Clear state in case we're recursing into a function literal
-	// inside a function that returns an error.
-	newT := *t
-	newT.errorObjs = nil
-	newT.errorIdents = nil
-	newT.errorIndices = nil
-	newT.numReturns = 0
-	t = &newT
-
-	// If the function does not return anything,
-	// we still need to recurse into any function literals.
-	// Just return this visitor to continue recursing.
-	if ft.Results == nil {
-		return t
+	if cmd.skipFile(r, src) {
+		return errtrace.Wrap(cmd.passThrough(r, src))
 	}
 
-	// If the function has return values,
-	// we need to consider the following cases:
-	//
-	//   - no error return value
-	//   - unnamed error return
-	//   - named error return
-	var (
-		objs    []*ast.Object // objects of error return values
-		idents  []*ast.Ident  // identifiers of named error return values
-		indices []int         // indices of error return values
-		count   int           // total number of return values
-		// Invariants:
-		//  len(indices) <= count
-		//  len(names) == 0 || len(names) == len(indices)
-	)
-	for _, field := range ft.Results.List {
-		isError := isIdent(field.Type, "error")
-
-		// field.Names is nil for unnamed return values.
-		// Either all returns are named or none are.
-		if len(field.Names) > 0 {
-			for _, name := range field.Names {
-				if isError {
-					objs = append(objs, name.Obj)
-					idents = append(idents, name)
-					indices = append(indices, count)
-				}
-				count++
-			}
-		} else {
-			if isError {
-				indices = append(indices, count)
-			}
-			count++
-		}
-	}
-
-	// If there are no error return values,
-	// recurse to look for function literals.
-	if len(indices) == 0 {
-		return t
+	outSrc, err := rewrite.Remove(r.Filename, src)
+	if err != nil {
+		return errtrace.Wrap(err)
 	}
 
-	// If there's a single error return,
-	// and this function is a method named "Unwrap",
-	// don't wrap it so it plays nice with errors.Unwrap.
-	if len(indices) == 1 {
-		if decl, ok := parent.(*ast.FuncDecl); ok {
-			if decl.Recv != nil && isIdent(decl.Name, "Unwrap") {
-				return t
-			}
+	if r.List {
+		if !bytes.Equal(src, outSrc) {
+			_, err = fmt.Fprintf(cmd.Stdout, "%s\n", r.Filename)
 		}
+		return errtrace.Wrap(err)
 	}
 
-	newT.errorObjs = setOf(objs)
-	newT.errorIdents = idents
-	newT.errorIndices = indices
-	newT.numReturns = count
-	return &newT
+	return errtrace.Wrap(cmd.writeOutput(r, src, outSrc))
 }
 
-func (t *walker) returnStmt(n *ast.ReturnStmt) ast.Visitor {
-	// Doesn't return errors. Continue recursing.
-	if len(t.errorIndices) == 0 {
-		return t
-	}
-
-	// Naked return.
-	// We want to add assignments to the named return values.
-	if n.Results == nil {
-		if t.optout(n.Pos()) {
-			return nil
-		}
-
-		// Ignore errors that have already been wrapped.
-		names := make([]string, 0, len(t.errorIndices))
-		for _, ident := range t.errorIdents {
-			if _, ok := t.alreadyWrapped[ident.Obj]; ok {
-				continue
-			}
-			names = append(names, ident.Name)
-		}
-
-		if len(names) > 0 {
-			*t.inserts = append(*t.inserts, &insertWrapAssign{
-				Names:  names,
-				Before: n.Pos(),
-			})
-		}
+// skipFile reports whether a file should be left unchanged
+// because of the configuration in errtrace.toml.
+func (cmd *mainCmd) skipFile(r fileRequest, src []byte) bool {
+	return r.Excluded || (r.SkipGenerated && isGenerated(r.Filename, src))
+}
 
+// passThrough handles a file that's left unchanged.
+// It's printed as-is if the output goes to stdout.
+func (cmd *mainCmd) passThrough(r fileRequest, src []byte) error {
+	if r.Write || r.List || r.Diff || r.Check {
 		return nil
 	}
-
-	// Return with multiple return values being automatically expanded
-	// E.g.,
-	//	func foo() (int, error) {
-	//		return bar()
-	//	}
-	// This needs to become:
-	//	func foo() (int, error) {
-	//		return Wrap2(bar())
-	//	}
-	// This is only supported if numReturns <= 6 and only the last return value is an error.
-	if len(n.Results) == 1 && t.numReturns > 1 {
-		if _, ok := n.Results[0].(*ast.CallExpr); !ok {
-			t.logf(n.Pos(), "skipping function with incorrect number of return values: got %d, want %d", len(n.Results), t.numReturns)
-			return t
-		}
-
-		t.wrapReturnCall(t.numReturns, n)
-		return t
-	}
-
-	for _, idx := range t.errorIndices {
-		t.wrapExpr(1, n.Results[idx])
-	}
-
-	return t
+	_, err := cmd.Stdout.Write(src)
+	return errtrace.Wrap(err)
 }
 
-func (t *walker) assignStmt(n *ast.AssignStmt) {
-	// Record assignments to named error return values.
-	// We'll use this to detect re-wraps.
-	for i, lhs := range n.Lhs {
-		ident, ok := lhs.(*ast.Ident)
-		if !ok {
-			continue // not an identifier
-		}
-
-		_, ok = t.errorObjs[ident.Obj]
-		if !ok {
-			continue // not an error assignment
-		}
-
-		if i < len(n.Rhs) && t.isErrtraceWrap(n.Rhs[i]) {
-			// Assigning to a named error return value.
-			t.alreadyWrapped[ident.Obj] = struct{}{}
-		}
+// isGenerated reports whether the source has a comment
+// marking it as generated code, e.g. "// Code generated by foo. DO NOT EDIT."
+func isGenerated(filename string, src []byte) bool {
+	f, err := parser.ParseFile(token.NewFileSet(), filename, src, parser.PackageClauseOnly|parser.ParseComments)
+	if err != nil {
+		return false
 	}
+	return ast.IsGenerated(f)
 }
 
-func (t *walker) deferStmt(n *ast.DeferStmt) {
-	// If there's a defer statement with a function literal,
-	// *and* this function has named return values,
-	// we'll want to watch for assignments to those return values.
-
-	if len(t.errorIdents) == 0 {
-		return // no named returns
-	}
-
-	funcLit, ok := n.Call.Fun.(*ast.FuncLit)
-	if !ok {
-		return // not a function literal
+// writeOutput writes the rewritten source of a file
+// as requested by the flags: formatted, as a diff, to the file, or to stdout.
+func (cmd *mainCmd) writeOutput(r fileRequest, src, outSrc []byte) error {
+	var err error
+	if r.Format {
+		outSrc, err = gofmt.Source(outSrc)
+		if err != nil {
+			return errtrace.Wrap(fmt.Errorf("format: %w", err))
+		}
 	}
 
-	ast.Inspect(funcLit.Body, func(n ast.Node) bool {
-		assign, ok := n.(*ast.AssignStmt)
-		if !ok {
-			return true
+	if r.Diff {
+		d := diff.Unified(r.Filename+".orig", r.Filename, string(src), string(outSrc))
+		if _, err := io.WriteString(cmd.Stdout, d); err != nil {
+			return errtrace.Wrap(err)
 		}
-		for i, lhs := range assign.Lhs {
-			ident, ok := lhs.(*ast.Ident)
-			if !ok {
-				continue // not an identifier
-			}
-
-			if _, ok := t.errorObjs[ident.Obj]; !ok {
-				continue // not an error assignment
-			}
-
-			// Assignment to an error return value.
-			// This will take one of the following forms:
-			//
-			//  (1) x, y, err = f1(), f2(), f3()
-			//  (2) x, y, err = f() // returns multiple values
-			//  (3) x, err, z = f() // returns multiple values
-			//
-			// For (1), we can wrap just the function
-			// that returns the error. (f3 in this case)
-			//
-			// For (2), we can use a WrapN function
-			// to wrap the entire function call.
-			//
-			// For (3), we could use an inline function call,
-			// but that's not implemented yet.
-
-			if i < len(assign.Rhs) && len(assign.Lhs) == len(assign.Rhs) {
-				// Case (1):
-				// Wrap the function that returns the error.
-				t.wrapExpr(1, assign.Rhs[i])
-			} else if i == len(assign.Lhs)-1 && len(assign.Rhs) == 1 {
-				// Case (2):
-				// Wrap the entire function call.
-				t.wrapExpr(len(assign.Lhs), assign.Rhs[0])
-			} else {
-				t.logf(assign.Pos(), "skipping assignment: error is not the last return value")
-			}
+		if !r.Write {
+			return nil
 		}
-
-		return true
-	})
-}
-
-func (t *walker) wrapReturnCall(n int, ret *ast.ReturnStmt) {
-	// Common validation
-	switch {
-	case len(t.errorIndices) != 1:
-		t.logf(ret.Pos(), "skipping function with multiple error returns")
-		return
-	case t.errorIndices[0] != t.numReturns-1:
-		t.logf(ret.Pos(), "skipping function with non-final error return")
-		return
-	case t.isErrtraceWrap(ret.Results[0]):
-		return
-	case t.optout(ret.Pos()):
-		return
-	}
-
-	if t.opts.NoWrapN {
-		*t.inserts = append(*t.inserts,
-			&insertReturnNBlockStart{N: n, Before: ret.Pos(), SkipReturn: ret.Results[0].Pos()},
-			&insertReturnNBlockClose{N: n, After: ret.End()},
-		)
-		return
-	}
-
-	if n > 6 {
-		t.logf(ret.Pos(), "skipping function with too many return values")
-		return
-	}
-
-	t.wrapExpr(n, ret.Results[0])
-}
-
-func (t *walker) wrapExpr(n int, expr ast.Expr) {
-	switch {
-	case t.isErrtraceWrap(expr):
-		return // already wrapped
-
-	case isIdent(expr, "nil"):
-		// Optimization: ignore if it's "nil".
-		return
-	}
-
-	if t.optout(expr.Pos()) {
-		return
 	}
 
-	*t.inserts = append(*t.inserts,
-		&insertWrapOpen{N: n, Before: expr.Pos()},
-		&insertWrapClose{After: expr.End()},
-	)
-}
-
-// Detects if an expression is in the form errtrace.Wrap(e) or errtrace.Wrap{n}(e).
-func (t *walker) isErrtraceWrap(expr ast.Expr) bool {
-	call, ok := expr.(*ast.CallExpr)
-	if !ok {
-		return false
-	}
-
-	// Ignore if it's already errtrace.Wrap(...).
-	sel, ok := call.Fun.(*ast.SelectorExpr)
-	if !ok {
-		return false
-	}
-
-	if !isIdent(sel.X, t.errtracePkg) {
-		return false
-	}
-
-	return strings.HasPrefix(sel.Sel.Name, "Wrap") ||
-		sel.Sel.Name == "New" ||
-		sel.Sel.Name == "Errorf"
-}
-
-// optout reports whether the line at the given position
-// is opted out of tracing, incrementing uses if so.
-func (t *walker) optout(pos token.Pos) bool {
-	line := t.fset.Position(pos).Line
-	_, ok := t.optouts[line]
-	if ok {
-		t.optouts[line]++
-	}
-	return ok
-}
-
-// insert is a request to add something to the source code.
-type insert interface {
-	Pos() token.Pos // position to insert at
-	String() string // description for debugging
-}
-
-// insertImportErrtrace adds an import declaration to the file
-// right after the given node.
-type insertImportErrtrace struct {
-	AddKeyword bool      // whether the "import" keyword should be added
-	At         token.Pos // position to insert at
-}
-
-func (e *insertImportErrtrace) Pos() token.Pos {
-	return e.At
-}
-
-func (e *insertImportErrtrace) String() string {
-	if e.AddKeyword {
-		return "add import statement"
+	if r.Write {
+		err = os.WriteFile(r.Filename, outSrc, 0o644)
+	} else {
+		_, err = cmd.Stdout.Write(outSrc)
 	}
-	return "add import"
-}
-
-// insertWrapOpen adds a errtrace.Wrap call before an expression.
-//
-//	foo() -> errtrace.Wrap(foo()
-//
-// This needs a corresponding insertWrapClose to close the call.
-type insertWrapOpen struct {
-	// N specifies the number of parameters the Wrap function takes.
-	// Defaults to 1.
-	N int
-
-	Before token.Pos // position to insert before
-}
-
-func (e *insertWrapOpen) Pos() token.Pos {
-	return e.Before
-}
-
-func (e *insertWrapOpen) String() string {
-	return "<errtrace.Wrap>"
-}
-
-// insertWrapClose closes a errtrace.Wrap call.
-//
-//	foo() -> foo())
-//
-// This needs a corresponding insertWrapOpen to open the call.
-type insertWrapClose struct {
-	After token.Pos // position to insert after
-}
-
-func (e *insertWrapClose) Pos() token.Pos {
-	return e.After
-}
-
-func (e *insertWrapClose) String() string {
-	return "</errtrace.Wrap>"
-}
-
-type insertReturnNBlockStart struct {
-	N          int       // number of returns
-	Before     token.Pos // position to insert before
-	SkipReturn token.Pos // skipped content, used to drop "return"
-}
-
-func (i *insertReturnNBlockStart) Pos() token.Pos {
-	return i.Before
-}
-
-func (i *insertReturnNBlockStart) String() string {
-	return "<return-block-errtrace.Wrap>"
-}
-
-type insertReturnNBlockClose struct {
-	N     int       // number of returns
-	After token.Pos // position to insert after
-}
-
-func (i *insertReturnNBlockClose) Pos() token.Pos {
-	return i.After
-}
-
-func (i *insertReturnNBlockClose) String() string {
-	return "</return-block-enrrtrace-Wrap>"
-}
-
-// insertWrapAssign wraps a variable in-place with an errtrace.Wrap call.
-// This is used for naked returns in functions with named return values
-//
-// For example, it will turn this:
-//
-//	func foo() (err error) {
-//		// ...
-//		return
-//	}
-//
-// Into this:
-//
-//	func foo() (err error) {
-//		// ...
-//		err = errtrace.Wrap(err); return
-//	}
-type insertWrapAssign struct {
-	Names  []string  // names of variables to wrap
-	Before token.Pos // position to insert before
-}
-
-func (e *insertWrapAssign) Pos() token.Pos {
-	return e.Before
-}
-
-func (e *insertWrapAssign) String() string {
-	return fmt.Sprintf("assign errors before %v", e.Names)
-}
-
-func isIdent(expr ast.Expr, name string) bool {
-	ident, ok := expr.(*ast.Ident)
-	return ok && ident.Name == name
+	return errtrace.Wrap(err)
 }
 
-func setOf[T comparable](xs []T) map[T]struct{} {
-	if len(xs) == 0 {
-		return nil
+func (cmd *mainCmd) readFile(r fileRequest) ([]byte, error) {
+	if r.Filepath != "-" {
+		return errtrace.Wrap2(os.ReadFile(r.Filename))
 	}
 
-	set := make(map[T]struct{})
-	for _, x := range xs {
-		set[x] = struct{}{}
+	if r.Write {
+		return nil, errtrace.Wrap(fmt.Errorf("can't use -w with stdin"))
 	}
-	return set
-}
-
-var _errtraceSkip = regexp.MustCompile(`(^| )//errtrace:skip($|[ \(])`)
-
-// optoutLines returns the line numbers
-// that have a comment in the form:
-//
-//	//errtrace:skip
-//
-// It may be followed by other text, e.g.,
-//
-//	//errtrace:skip // for reasons
-func optoutLines(
-	fset *token.FileSet,
-	comments []*ast.CommentGroup,
-) map[int]int {
-	lines := make(map[int]int)
-	for _, cg := range comments {
-		if len(cg.List) > 1 {
-			// skip multiline comments which are full line comments, not tied to a return.
-			continue
-		}
 
-		c := cg.List[0]
-		if _errtraceSkip.MatchString(c.Text) {
-			lineNo := fset.Position(c.Pos()).Line
-			lines[lineNo] = 0
+	if r.ImplicitStdin {
+		// Running with no args reads from stdin, but this is not obvious
+		// so print a usage hint to stderr, if we think stdin is a TTY.
+		if isTerminal(cmd.Stdin) {
+			cmd.log.Println("reading from stdin; use '-h' for help")
 		}
 	}
-	return lines
-}
 
-func nVars(prefix string, n int) []string {
-	vars := make([]string, n)
-	for i := 0; i < n; i++ {
-		vars[i] = fmt.Sprintf("%s%d", prefix, i+1)
-	}
-	return vars
+	return errtrace.Wrap2(io.ReadAll(cmd.Stdin))
 }
 
 func logln(w io.Writer, s string) {
@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"go/parser"
@@ -20,6 +21,7 @@ import (
 
 	"braces.dev/errtrace"
 	"braces.dev/errtrace/internal/diff"
+	"braces.dev/errtrace/internal/rewrite"
 )
 
 func TestErrHelp(t *testing.T) {
@@ -51,6 +53,8 @@ func TestGolden(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	defer shareGoListExport()()
+
 	for _, file := range files {
 		name := strings.TrimSuffix(filepath.Base(file), ".go")
 		t.Run(name, func(t *testing.T) {
@@ -59,6 +63,53 @@ func TestGolden(t *testing.T) {
 	}
 }
 
+// shareGoListExport makes 'go list -export' share its results
+// for imports of files type-checked on their own,
+// instead of running once for every file and every run.
+// Packages listed by earlier calls are listed again with the new ones,
+// so the shared result only grows.
+func shareGoListExport() (restore func()) {
+	var (
+		mu       sync.Mutex
+		patterns []string                // all requested import paths
+		listed   = make(map[string]bool) // import paths in pkgs
+		pkgs     []*goListPackage        // result for patterns
+		old      = _goListExport
+	)
+	_goListExport = func(test bool, imports []string) ([]*goListPackage, error) {
+		if test {
+			// Directories of packages in temporary directories.
+			return errtrace.Wrap2(old(test, imports))
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		var missing bool
+		for _, imp := range imports {
+			if !listed[imp] {
+				missing = true
+				patterns = append(patterns, imp)
+			}
+		}
+		if !missing {
+			return pkgs, nil
+		}
+
+		newPkgs, err := old(false, patterns)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		pkgs = newPkgs
+		for _, pkg := range pkgs {
+			listed[pkg.ImportPath] = true
+		}
+		return pkgs, nil
+	}
+
+	return func() { _goListExport = old }
+}
+
 func testGoldenFile(t *testing.T, file string) {
 	giveSrc, err := os.ReadFile(file)
 	if err != nil {
@@ -71,16 +122,24 @@ func testGoldenFile(t *testing.T, file string) {
 	}
 
 	type runTests struct {
-		noOptions  bool
-		optNoWrapN bool
+		noOptions   bool
+		optNoWrapN  bool
+		optTypes    bool
+		optNilCheck bool
 	}
-	run := runTests{true, true} // by default, run all tests.
+	run := runTests{noOptions: true, optNoWrapN: true} // by default, run all tests without -types.
 	if strings.Contains(string(giveSrc), "@runIf options=<empty>") {
 		run = runTests{noOptions: true}
 	}
 	if strings.Contains(string(giveSrc), "@runIf options=no-wrapn") {
 		run = runTests{optNoWrapN: true}
 	}
+	if strings.Contains(string(giveSrc), "@runIf options=types") {
+		run = runTests{optTypes: true}
+	}
+	if strings.Contains(string(giveSrc), "@runIf options=nil-check") {
+		run = runTests{optNilCheck: true}
+	}
 
 	if run.noOptions {
 		t.Run("no options", func(t *testing.T) {
@@ -93,6 +152,18 @@ func testGoldenFile(t *testing.T, file string) {
 			testGoldenContents(t, []string{"-no-wrapn"}, file, giveSrc, wantSrc)
 		})
 	}
+
+	if run.optTypes {
+		t.Run("option types", func(t *testing.T) {
+			testGoldenContents(t, []string{"-types"}, file, giveSrc, wantSrc)
+		})
+	}
+
+	if run.optNilCheck {
+		t.Run("option nil-check", func(t *testing.T) {
+			testGoldenContents(t, []string{"-types", "-nil-check"}, file, giveSrc, wantSrc)
+		})
+	}
 }
 
 func testGoldenContents(t *testing.T, additionalFlags []string, file string, giveSrc, wantSrc []byte) {
@@ -131,6 +202,53 @@ func testGoldenContents(t *testing.T, additionalFlags []string, file string, giv
 		}
 	})
 
+	// Verify that -check reports the same log messages,
+	// and unwrapped errors if the source is expected to change.
+	t.Run("check", func(t *testing.T) {
+		var out bytes.Buffer
+		exitCode := (&mainCmd{
+			Stdout: &out,
+			Stderr: testWriter{t},
+		}).Run(append(additionalFlags, "-check", "-json", srcPath))
+
+		var (
+			gotLogs   []logLine
+			unwrapped int
+		)
+		decoder := json.NewDecoder(&out)
+		for decoder.More() {
+			var d diagnosticJSON
+			if err := decoder.Decode(&d); err != nil {
+				t.Fatal(err)
+			}
+			if d.File != srcPath || d.Line == 0 || d.Column == 0 {
+				t.Errorf("bad position in %+v", d)
+			}
+
+			if d.Category == rewrite.CategoryUnwrapped {
+				unwrapped++
+				continue
+			}
+			gotLogs = append(gotLogs, logLine{Line: d.Line, Msg: d.Message})
+		}
+
+		if diff := diff.Diff(wantLogs, gotLogs); diff != "" {
+			t.Errorf("diagnostics differ:\n%s", indent(diff))
+		}
+
+		if changed := !bytes.Equal(giveSrc, wantSrc); changed != (unwrapped > 0) {
+			t.Errorf("source changed: %v, but got %d unwrapped diagnostics", changed, unwrapped)
+		}
+
+		wantExitCode := 0
+		if unwrapped > 0 || len(gotLogs) > 0 {
+			wantExitCode = 1
+		}
+		if exitCode != wantExitCode {
+			t.Errorf("exit code = %d, want %d", exitCode, wantExitCode)
+		}
+	})
+
 	var stdout, stderr bytes.Buffer
 	defer func() {
 		if t.Failed() {
@@ -174,7 +292,7 @@ func testGoldenContents(t *testing.T, additionalFlags []string, file string, giv
 		exitCode := (&mainCmd{
 			Stderr: testWriter{t},
 			Stdout: &got,
-		}).Run([]string{srcPath})
+		}).Run(append(additionalFlags, srcPath))
 
 		if want := 0; exitCode != want {
 			t.Errorf("exit code = %d, want %d", exitCode, want)
@@ -186,6 +304,43 @@ func testGoldenContents(t *testing.T, additionalFlags []string, file string, giv
 		}
 	})
 
+	// Remove instrumentation from the output.
+	// This should undo the changes made by errtrace,
+	// so instrumenting the result again must produce the same output.
+	t.Run("remove", func(t *testing.T) {
+		var removed bytes.Buffer
+		exitCode := (&mainCmd{
+			Stdin:  bytes.NewReader(wantSrc),
+			Stderr: testWriter{t},
+			Stdout: &removed,
+		}).Run([]string{"-remove", "-format=never"})
+		if want := 0; exitCode != want {
+			t.Errorf("exit code = %d, want %d", exitCode, want)
+		}
+
+		// If the input didn't use errtrace,
+		// removing it must restore the input exactly.
+		if !bytes.Contains(giveSrc, []byte(errtracePkgImport)) {
+			if want, got := string(giveSrc), removed.String(); got != want {
+				t.Errorf("want removed:\n%s\ngot:\n%s\ndiff:\n%s", indent(want), indent(got), indent(diff.Lines(want, got)))
+			}
+		}
+
+		var got bytes.Buffer
+		exitCode = (&mainCmd{
+			Stdin:  &removed,
+			Stderr: testWriter{t},
+			Stdout: &got,
+		}).Run(append(additionalFlags, "-format=never"))
+		if want := 0; exitCode != want {
+			t.Errorf("exit code = %d, want %d", exitCode, want)
+		}
+
+		if want, got := string(wantSrc), got.String(); got != want {
+			t.Errorf("want output:\n%s\ngot:\n%s\ndiff:\n%s", indent(want), indent(got), indent(diff.Lines(want, got)))
+		}
+	})
+
 	// Create a Go package with the source file,
 	// and run errtrace on the package.
 	t.Run("package", func(t *testing.T) {
@@ -249,6 +404,67 @@ func TestParseMainParams(t *testing.T) {
 				ImplicitStdin: true,
 			},
 		},
+		{
+			name: "nil-check",
+			give: []string{"-types", "-nil-check", "foo.go"},
+			want: mainParams{
+				Types:    true,
+				NilCheck: true,
+				Patterns: []string{"foo.go"},
+			},
+		},
+		{
+			name:    "nil-check without types",
+			give:    []string{"-nil-check", "foo.go"},
+			wantErr: []string{"-nil-check requires -types"},
+		},
+		{
+			name:    "remove with types",
+			give:    []string{"-remove", "-types", "foo.go"},
+			wantErr: []string{"-remove can't be used with -types"},
+		},
+		{
+			name: "check json",
+			give: []string{"-check", "-json", "./..."},
+			want: mainParams{
+				Check:    true,
+				JSON:     true,
+				Patterns: []string{"./..."},
+			},
+		},
+		{
+			name:    "check with write",
+			give:    []string{"-check", "-w", "foo.go"},
+			wantErr: []string{"-check can't be used with -w, -l, -d, or -remove"},
+		},
+		{
+			name:    "json without check",
+			give:    []string{"-json", "foo.go"},
+			wantErr: []string{"-json requires -check"},
+		},
+		{
+			name: "include generated",
+			give: []string{"-include-generated", "-w", "./..."},
+			want: mainParams{
+				Write:            true,
+				IncludeGenerated: true,
+				Patterns:         []string{"./..."},
+			},
+		},
+		{
+			name: "sentinels",
+			give: []string{"-v", "-sentinels", "example.com/foo.ErrDone,example.com/bar/v2.ErrStop", "foo.go"},
+			want: mainParams{
+				Verbose:   true,
+				Sentinels: []string{"example.com/foo.ErrDone", "example.com/bar/v2.ErrStop"},
+				Patterns:  []string{"foo.go"},
+			},
+		},
+		{
+			name:    "invalid sentinel",
+			give:    []string{"-sentinels", "io.EOF,EOF", "foo.go"},
+			wantErr: []string{`invalid sentinel "EOF"`},
+		},
 	}
 
 	for _, tt := range tests {
@@ -370,6 +586,7 @@ func TestShouldFormat(t *testing.T) {
 	}{
 		{"auto/no write", mainParams{Format: formatAuto}, false},
 		{"auto/write", mainParams{Format: formatAuto, Write: true}, true},
+		{"auto/diff", mainParams{Format: formatAuto, Diff: true}, true},
 		{"always", mainParams{Format: formatAlways}, true},
 		{"never", mainParams{Format: formatNever}, false},
 	}
@@ -499,7 +716,8 @@ func TestFormatAuto(t *testing.T) {
 		if want, got := "stdin:can't use -w with stdin\n", err.String(); !strings.Contains(got, want) {
 			t.Errorf("stderr = %q, does not contain %q", got, want)
 		}
-		if want, got := "(*mainCmd).readFile", err.String(); !strings.Contains(got, want) {
+		// The trace is missing with -tags errtrace_off.
+		if want, got := "(*mainCmd).readFile", err.String(); errtrace.Enabled() && !strings.Contains(got, want) {
 			t.Errorf("stderr = %q, does not contain %q", got, want)
 		}
 	})
@@ -549,27 +767,507 @@ func TestListFlag(t *testing.T) {
 	}
 }
 
-func TestOptoutLines(t *testing.T) {
-	fset := token.NewFileSet()
-	f, err := parser.ParseFile(fset, "", `package foo
-func _() {
-	_ = "line 3" //errtrace:skip
-	_ = "this line not counted" // errtrace:skip
-	_ = "line 5" //errtrace:skip // has a reason
-	_ = "line 6" //nolint:somelinter //errtrace:skip // stuff
-}`, parser.ParseComments)
+func TestDiffFlag(t *testing.T) {
+	src := strings.Join([]string{
+		"package foo",
+		"",
+		`import "errors"`,
+		"",
+		"func foo() error {",
+		`	return errors.New("foo")`,
+		"}",
+		"",
+	}, "\n")
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "foo.go")
+	if err := os.WriteFile(file, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer chdir(t, dir)()
+
+	var out bytes.Buffer
+	exitCode := (&mainCmd{
+		Stdout: &out,
+		Stderr: testWriter{t},
+	}).Run([]string{"-d", "foo.go"})
+	if want := 0; exitCode != want {
+		t.Errorf("exit code = %d, want %d", exitCode, want)
+	}
+
+	want := strings.Join([]string{
+		"diff foo.go.orig foo.go",
+		"--- foo.go.orig",
+		"+++ foo.go",
+		"@@ -1,7 +1,8 @@",
+		" package foo",
+		" ",
+		` import "errors"`,
+		`+import "braces.dev/errtrace"`,
+		" ",
+		" func foo() error {",
+		`-	return errors.New("foo")`,
+		`+	return errtrace.Wrap(errors.New("foo"))`,
+		" }",
+		"",
+	}, "\n")
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s\ndiff:\n%s", indent(got), indent(want), indent(diff.Lines(want, got)))
+	}
+
+	// The file must not be modified.
+	if got, err := os.ReadFile(file); err != nil {
+		t.Fatal(err)
+	} else if string(got) != src {
+		t.Errorf("file was modified:\n%s", indent(string(got)))
+	}
+
+	// Files that don't change produce no diff.
+	out.Reset()
+	exitCode = (&mainCmd{
+		Stdout: &out,
+		Stderr: testWriter{t},
+	}).Run([]string{"-d", "-w", "foo.go"})
+	if want := 0; exitCode != want {
+		t.Errorf("exit code = %d, want %d", exitCode, want)
+	}
+
+	out.Reset()
+	exitCode = (&mainCmd{
+		Stdout: &out,
+		Stderr: testWriter{t},
+	}).Run([]string{"-d", "foo.go"})
+	if want := 0; exitCode != want {
+		t.Errorf("exit code = %d, want %d", exitCode, want)
+	}
+	if got := out.String(); got != "" {
+		t.Errorf("expected no output, got:\n%s", indent(got))
+	}
+}
+
+func TestCheckFlag(t *testing.T) {
+	src := strings.Join([]string{
+		"package foo",
+		"",
+		`import "errors"`,
+		"",
+		"func foo() error {",
+		`	return errors.New("foo")`,
+		"}",
+		"",
+		"func bar() (error, error) {",
+		"	return baz()",
+		"}",
+		"",
+		"func qux() error {",
+		"	return nil //errtrace:skip",
+		"}",
+		"",
+	}, "\n")
+
+	dir := t.TempDir()
+	file := filepath.Join(dir, "foo.go")
+	if err := os.WriteFile(file, []byte(src), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	defer chdir(t, dir)()
+
+	t.Run("text", func(t *testing.T) {
+		var out bytes.Buffer
+		exitCode := (&mainCmd{
+			Stdout: &out,
+			Stderr: testWriter{t},
+		}).Run([]string{"-check", "foo.go"})
+		if want := 1; exitCode != want {
+			t.Errorf("exit code = %d, want %d", exitCode, want)
+		}
+
+		want := strings.Join([]string{
+			"foo.go:6:9:error is not wrapped with errtrace",
+			"foo.go:10:2:skipping function with multiple error returns",
+			"foo.go:14:13:unused errtrace:skip",
+			"",
+		}, "\n")
+		if got := out.String(); got != want {
+			t.Errorf("got:\n%s\nwant:\n%s\ndiff:\n%s", indent(got), indent(want), indent(diff.Lines(want, got)))
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var out bytes.Buffer
+		exitCode := (&mainCmd{
+			Stdout: &out,
+			Stderr: testWriter{t},
+		}).Run([]string{"-check", "-json", "foo.go"})
+		if want := 1; exitCode != want {
+			t.Errorf("exit code = %d, want %d", exitCode, want)
+		}
+
+		want := strings.Join([]string{
+			`{"file":"foo.go","line":6,"column":9,"category":"unwrapped","message":"error is not wrapped with errtrace"}`,
+			`{"file":"foo.go","line":10,"column":2,"category":"skipped","message":"skipping function with multiple error returns"}`,
+			`{"file":"foo.go","line":14,"column":13,"category":"unused-skip","message":"unused errtrace:skip"}`,
+			"",
+		}, "\n")
+		if got := out.String(); got != want {
+			t.Errorf("got:\n%s\nwant:\n%s\ndiff:\n%s", indent(got), indent(want), indent(diff.Lines(want, got)))
+		}
+	})
+
+	// The file must not be modified.
+	if got, err := os.ReadFile(file); err != nil {
+		t.Fatal(err)
+	} else if string(got) != src {
+		t.Errorf("file was modified:\n%s", indent(string(got)))
+	}
+
+	// No diagnostics once instrumented.
+	exitCode := (&mainCmd{
+		Stdout: testWriter{t},
+		Stderr: testWriter{t},
+	}).Run([]string{"-w", "foo.go"})
+	if want := 0; exitCode != want {
+		t.Errorf("exit code = %d, want %d", exitCode, want)
+	}
+
+	var out bytes.Buffer
+	exitCode = (&mainCmd{
+		Stdout: &out,
+		Stderr: testWriter{t},
+	}).Run([]string{"-check", "foo.go"})
+	if want := 1; exitCode != want {
+		t.Errorf("exit code = %d, want %d", exitCode, want)
+	}
+	want := strings.Join([]string{
+		// One line down for the errtrace import.
+		"foo.go:11:2:skipping function with multiple error returns",
+		"foo.go:15:13:unused errtrace:skip",
+		"",
+	}, "\n")
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s\ndiff:\n%s", indent(got), indent(want), indent(diff.Lines(want, got)))
+	}
+}
+
+func TestGeneratedFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/foo\ngo 1.21\n",
+		"foo.go": strings.Join([]string{
+			"package foo",
+			"func foo() error {",
+			"	return bar()",
+			"}",
+		}, "\n"),
+		"foo.pb.go": strings.Join([]string{
+			"// Code generated by protoc-gen-go. DO NOT EDIT.",
+			"",
+			"package foo",
+			"func bar() error {",
+			"	return foo()",
+			"}",
+		}, "\n"),
+	})
+
+	defer chdir(t, dir)()
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			name: "default",
+			args: []string{"-l", "./..."},
+			want: "foo.go\n",
+		},
+		{
+			name: "include generated",
+			args: []string{"-l", "-include-generated", "./..."},
+			want: "foo.go\nfoo.pb.go\n",
+		},
+		{
+			name: "check",
+			args: []string{"-check", "foo.pb.go"},
+			want: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			exitCode := (&mainCmd{
+				Stdout: &out,
+				Stderr: testWriter{t},
+			}).Run(tt.args)
+			if want := 0; exitCode != want {
+				t.Errorf("exit code = %d, want %d", exitCode, want)
+			}
+
+			if got := out.String(); got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", indent(got), indent(tt.want))
+			}
+		})
+	}
+}
+
+func TestSentinelFlags(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod": "module example.com/foo\ngo 1.21\n",
+		"foo.go": strings.Join([]string{
+			"package foo",
+			`import "io"`,
+			"var ErrDone = io.ErrClosedPipe",
+			"func foo(n int) error {",
+			"	if n == 0 {",
+			"		return io.EOF",
+			"	}",
+			"	return ErrDone",
+			"}",
+		}, "\n"),
+	})
+
+	defer chdir(t, dir)()
+
+	tests := []struct {
+		name       string
+		args       []string
+		wantStdout string
+		wantStderr []string
+	}{
+		{
+			name:       "default",
+			args:       []string{"-check", "foo.go"},
+			wantStdout: "foo.go:8:9:error is not wrapped with errtrace\n",
+		},
+		{
+			name: "flag",
+			args: []string{"-check", "-sentinels", "example.com/foo.ErrDone", "foo.go"},
+		},
+		{
+			name: "verbose",
+			args: []string{"-check", "-v", "-sentinels", "example.com/foo.ErrDone", "foo.go"},
+			wantStderr: []string{
+				"foo.go:6:10:leaving sentinel error io.EOF unwrapped",
+				"foo.go:8:9:leaving sentinel error example.com/foo.ErrDone unwrapped",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout, stderr bytes.Buffer
+			(&mainCmd{
+				Stdout: &stdout,
+				Stderr: &stderr,
+			}).Run(tt.args)
+
+			if got := stdout.String(); got != tt.wantStdout {
+				t.Errorf("stdout:\n%s\nwant:\n%s", indent(got), indent(tt.wantStdout))
+			}
+
+			var gotStderr []string
+			for _, line := range strings.Split(strings.TrimSpace(stderr.String()), "\n") {
+				if line != "" {
+					gotStderr = append(gotStderr, line)
+				}
+			}
+			if !reflect.DeepEqual(gotStderr, tt.wantStderr) {
+				t.Errorf("stderr = %q, want %q", gotStderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestTypesPackage(t *testing.T) {
+	// The alias is declared in a different file of the package
+	// than the functions that return it,
+	// and the test file is type-checked with the package.
+	// Files excluded by build constraints can't be type-checked,
+	// and fall back to finding errors syntactically.
+	files := map[string]string{
+		"go.mod": "module example.com/foo\ngo 1.21\n",
+		"alias.go": strings.Join([]string{
+			"package foo",
+			"type Error = error",
+		}, "\n"),
+		"foo.go": strings.Join([]string{
+			"package foo",
+			`import "errors"`,
+			"func foo() Error {",
+			`	return errors.New("foo")`,
+			"}",
+		}, "\n"),
+		"foo_test.go": strings.Join([]string{
+			"package foo",
+			"func fooHelper() Error {",
+			"	return foo()",
+			"}",
+		}, "\n"),
+		"never.go": strings.Join([]string{
+			"//go:build never",
+			"package foo",
+			"func bar() error {",
+			"	return notDefined()",
+			"}",
+		}, "\n"),
+	}
+
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer chdir(t, dir)()
+
+	var out, stderr bytes.Buffer
+	exitCode := (&mainCmd{
+		Stdout: &out,
+		Stderr: &stderr,
+	}).Run([]string{"-types", "-l", "."})
+	if want := 0; exitCode != want {
+		t.Errorf("exit code = %d, want %d", exitCode, want)
+	}
+
+	want := "foo.go\nfoo_test.go\nnever.go\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s\ndiff:\n%s", indent(got), indent(want), indent(diff.Lines(want, got)))
+	}
+
+	if want, got := "never.go:no type information: never.go:4:9: undefined: notDefined", stderr.String(); !strings.Contains(got, want) {
+		t.Errorf("stderr = %q, want %q", got, want)
+	}
+}
+
+func TestTypesError(t *testing.T) {
+	// A package that doesn't type-check falls back to
+	// finding errors syntactically, with a warning.
+	// Other packages are still type-checked.
+	dir := t.TempDir()
+	files := map[string]string{
+		"go.mod": "module example.com/foo\ngo 1.21\n",
+		"bad/bad.go": strings.Join([]string{
+			"package bad",
+			"func foo() error {",
+			"	return undefined",
+			"}",
+		}, "\n"),
+		"good/good.go": strings.Join([]string{
+			"package good",
+			`import "errors"`,
+			"type Error = error",
+			"func foo() Error {",
+			`	return errors.New("foo")`,
+			"}",
+		}, "\n"),
+	}
+	for name, src := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	defer chdir(t, dir)()
+
+	var out, stderr bytes.Buffer
+	exitCode := (&mainCmd{
+		Stdout: &out,
+		Stderr: &stderr,
+	}).Run([]string{"-types", "-l", "./..."})
+	if want := 0; exitCode != want {
+		t.Errorf("exit code = %d, want %d", exitCode, want)
+	}
+
+	want := filepath.Join("bad", "bad.go") + "\n" + filepath.Join("good", "good.go") + "\n"
+	if got := out.String(); got != want {
+		t.Errorf("got:\n%s\nwant:\n%s\ndiff:\n%s", indent(got), indent(want), indent(diff.Lines(want, got)))
+	}
+
+	for _, want := range []string{"no type information", "undefined: undefined"} {
+		if got := stderr.String(); !strings.Contains(got, want) {
+			t.Errorf("stderr = %q, want %q", got, want)
+		}
+	}
+}
+
+func TestNilCheckTypedNil(t *testing.T) {
+	// -nil-check must not change what the function returns:
+	// a nil *MyError is still returned as a non-nil error,
+	// and non-nil values are traced.
+	root, err := filepath.Abs("../..")
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	var got []int
-	for line := range optoutLines(fset, f.Comments) {
-		got = append(got, line)
+	files := map[string]string{
+		"go.mod": strings.Join([]string{
+			"module example.com/foo",
+			"go 1.21",
+			"require braces.dev/errtrace v0.0.0",
+			"replace braces.dev/errtrace => " + root,
+		}, "\n"),
+		"foo.go": strings.Join([]string{
+			"package foo",
+			"type MyError struct{}",
+			`func (*MyError) Error() string { return "my error" }`,
+			"func newMyError(fail bool) *MyError {",
+			"	if !fail {",
+			"		return nil",
+			"	}",
+			"	return &MyError{}",
+			"}",
+			"func Foo(fail bool) error {",
+			"	return newMyError(fail)",
+			"}",
+		}, "\n"),
+		"foo_test.go": strings.Join([]string{
+			"package foo",
+			`import "testing"`,
+			`import "braces.dev/errtrace"`,
+			"func TestFoo(t *testing.T) {",
+			"	err := Foo(false)",
+			"	if err == nil {",
+			`		t.Fatal("typed nil became a nil error")`,
+			"	}",
+			"	if e, ok := err.(*MyError); !ok || e != nil {",
+			`		t.Fatalf("got %#v, want (*MyError)(nil)", err)`,
+			"	}",
+			"	if _, _, ok := errtrace.UnwrapFrame(Foo(true)); !ok {",
+			`		t.Fatal("non-nil error was not traced")`,
+			"	}",
+			"}",
+		}, "\n"),
+	}
+
+	dir := t.TempDir()
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	restore := chdir(t, dir)
+	var stderr bytes.Buffer
+	exitCode := (&mainCmd{
+		Stdout: testWriter{t},
+		Stderr: &stderr,
+	}).Run([]string{"-types", "-nil-check", "-w", "foo.go"})
+	restore()
+	if want := 0; exitCode != want {
+		t.Fatalf("exit code = %d, want %d\nstderr:\n%s", exitCode, want, stderr.String())
 	}
-	sort.Ints(got)
 
-	if want := []int{3, 5, 6}; !reflect.DeepEqual(want, got) {
-		t.Errorf("got: %v\nwant: %v\ndiff:\n%s", got, want, diff.Diff(want, got))
+	if _, stderr, err := runGo(t, dir, "test", "-mod=mod", "."); err != nil {
+		t.Fatalf("go test failed: %v\nstderr:\n%s", err, stderr)
 	}
 }
 
@@ -0,0 +1,82 @@
+//go:build ignore
+
+// @runIf options=<empty>
+package foo
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+
+	"example.com/bar"
+)
+
+type reader struct {
+	r   io.Reader
+	buf []byte
+}
+
+// Read may return io.EOF from the underlying reader,
+// so only errors created here are wrapped.
+func (r *reader) Read(p []byte) (int, error) {
+	if len(p) == 0 {
+		return 0, errors.New("empty buffer")
+	}
+	if len(r.buf) > 0 {
+		n, err := r.r.Read(r.buf)
+		if err != nil {
+			return n, err
+		}
+		return 0, fmt.Errorf("bad header: %q", r.buf[:n])
+	}
+	return r.r.Read(p)
+}
+
+type writer struct {
+	w io.Writer
+}
+
+func (w *writer) Write(p []byte) (n int, err error) {
+	if len(p) > 1024 {
+		return 0, &bar.TooLongError{N: len(p)}
+	}
+	n, err = w.w.Write(p)
+	return
+}
+
+// Not an io.Reader: wrong signature.
+func (r *reader) ReadString(p []byte) (string, error) {
+	n, err := r.Read(p)
+	return string(p[:n]), err
+}
+
+// Not an io.Reader: not a method.
+func Read(p []byte) (int, error) {
+	return bar.Read(p)
+}
+
+func walk(root string) error {
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		return bar.Visit(path)
+	})
+	if err != nil {
+		return err
+	}
+
+	return filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if info.Size() > 1<<20 {
+			return fmt.Errorf("file too large: %v", path)
+		}
+		return bar.Visit(path)
+	})
+}
+
+func visitDir(path string, d os.DirEntry, err error) error {
+	return bar.Visit(path)
+}
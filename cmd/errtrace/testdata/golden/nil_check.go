@@ -0,0 +1,49 @@
+//go:build ignore
+
+// @runIf options=nil-check
+package foo
+
+import "errors"
+
+type MyError struct{ msg string }
+
+func (e *MyError) Error() string { return e.msg }
+
+func NewMyError(msg string) *MyError { // want:"skipping *MyError result: concrete error types can't hold wrapped errors"
+	if msg == "" {
+		return nil
+	}
+	return &MyError{msg: msg}
+}
+
+func Concrete(msg string) error {
+	return NewMyError(msg)
+}
+
+func ConcreteTuple(msg string) (int, error) {
+	return 42, NewMyError(msg)
+}
+
+func ConcreteCall(msg string) (int, error) {
+	return concreteTuple(msg)
+}
+
+func concreteTuple(msg string) (int, *MyError) { // want:"skipping *MyError result: concrete error types can't hold wrapped errors"
+	return 42, NewMyError(msg)
+}
+
+func MultipleErrors(msg string) (error, error) {
+	return errors.New("great sadness"), NewMyError(msg)
+}
+
+func MultipleConcrete(msg string) (error, error) {
+	return NewMyError(msg), NewMyError(msg) // want:"skipping nil check: multiple concrete error values"
+}
+
+func Optout(msg string) error {
+	return NewMyError(msg) //errtrace:skip
+}
+
+func Interface(msg string) error {
+	return errors.New(msg)
+}
@@ -38,6 +38,10 @@ func multipleReturnsSkipped() (a, b error) {
 		errors.New("b") //errtrace:skip
 }
 
+func multipleReturnsCall() (a, b error) {
+	return bar.Pair() //errtrace:skip // silences "multiple error returns"
+}
+
 // Explanation of why this function
 // is not using //errtrace:skip should not
 // trip up the warning.
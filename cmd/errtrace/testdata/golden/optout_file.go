@@ -0,0 +1,15 @@
+//go:build ignore
+
+//errtrace:skipfile // callers compare these errors with ==
+
+package foo
+
+import "example.com/bar"
+
+func foo() error {
+	return bar.Baz()
+}
+
+func multipleErrors() (a, b error) {
+	return bar.Pair()
+}
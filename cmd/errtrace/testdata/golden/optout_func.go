@@ -0,0 +1,68 @@
+//go:build ignore
+
+package foo
+
+import (
+	"errors"
+	"io"
+
+	"example.com/bar"
+)
+
+type reader struct {
+	r    io.Reader
+	done bool
+}
+
+// Read implements io.Reader.
+//
+//errtrace:skip // io.Reader expects io.EOF
+func (r *reader) Read(p []byte) (int, error) {
+	if r.done {
+		return 0, io.EOF
+	}
+	return r.r.Read(p)
+}
+
+func callbacks() error {
+	//errtrace:skip // bar.Do expects bar.ErrStop
+	err := bar.Do(func() error {
+		return bar.ErrStop
+	})
+	if err != nil {
+		return err
+	}
+
+	return bar.Do(func() error {
+		return errors.New("wrapped")
+	})
+}
+
+//errtrace:skip
+func nested() error {
+	fn := func() error {
+		return bar.Baz()
+	}
+	return fn()
+}
+
+func trailing() error {
+	x := 1 //errtrace:skip // want:"unused errtrace:skip"
+	fn := func() error {
+		return bar.Baz()
+	}
+	_ = x
+	return fn()
+}
+
+// Problems in skipped functions aren't reported either.
+//
+//errtrace:skip
+func multipleErrors() (a, b error) {
+	return bar.Pair()
+}
+
+//errtrace:skip // want:"unused errtrace:skip"
+func unused() error {
+	return nil
+}
@@ -0,0 +1,59 @@
+//go:build ignore
+
+package foo
+
+import (
+	"database/sql"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+)
+
+func readByte(buf []byte) (byte, error) {
+	if len(buf) == 0 {
+		return 0, io.EOF
+	}
+	if len(buf) < 2 {
+		return 0, (io.ErrUnexpectedEOF)
+	}
+	return buf[0], nil
+}
+
+func findUser(db *sql.DB, name string) (int, error) {
+	if name == "" {
+		return 0, sql.ErrNoRows
+	}
+	var id int
+	if err := db.QueryRow("SELECT id FROM users WHERE name = ?", name).Scan(&id); err != nil {
+		return 0, err
+	}
+	return id, nil
+}
+
+func walk(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() && d.Name() == "testdata" {
+			return filepath.SkipDir
+		}
+		if d.Name() == "STOP" {
+			return fs.SkipAll
+		}
+		return nil
+	})
+}
+
+func newClient() *http.Client {
+	return &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) > 10 {
+				return errors.New("too many redirects")
+			}
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,44 @@
+//go:build ignore
+
+// @runIf options=types
+package foo
+
+import (
+	"errors"
+	"fmt"
+)
+
+type Error = error
+
+type AliasOfAlias = Error
+
+func Alias() Error {
+	return errors.New("great sadness")
+}
+
+func AliasOfAliasResult() AliasOfAlias {
+	return fmt.Errorf("great sadness")
+}
+
+func AliasTuple() (int, Error) {
+	return 0, errors.New("great sadness")
+}
+
+func AliasCall() (int, Error) {
+	return AliasTuple()
+}
+
+func NamedAlias() (err Error) {
+	err = errors.New("great sadness")
+	return
+}
+
+func Nil() Error {
+	return nil
+}
+
+func Closure() {
+	_ = func() Error {
+		return errors.New("great sadness")
+	}
+}
@@ -0,0 +1,48 @@
+//go:build ignore
+
+// @runIf options=types
+package foo
+
+type MyError struct{ msg string }
+
+func (e *MyError) Error() string { return e.msg }
+
+type ValueError struct{ msg string }
+
+func (e ValueError) Error() string { return e.msg }
+
+func NewMyError(msg string) *MyError { // want:"skipping *MyError result: concrete error types can't hold wrapped errors"
+	if msg == "" {
+		return nil
+	}
+	return &MyError{msg: msg}
+}
+
+func NewValueError(msg string) ValueError { // want:"skipping ValueError result: concrete error types can't hold wrapped errors"
+	return ValueError{msg: msg}
+}
+
+func Concrete(msg string) error {
+	return NewMyError(msg) // want:"returning *MyError as error: a nil *MyError is not a nil error (use -nil-check)"
+}
+
+func ConcreteTuple(msg string) (int, error) {
+	return 42, NewMyError(msg) // want:"returning *MyError as error: a nil *MyError is not a nil error (use -nil-check)"
+}
+
+func ConcreteCall(msg string) (int, error) {
+	return concreteTuple(msg) // want:"returning *MyError as error: a nil *MyError is not a nil error (use -nil-check)"
+}
+
+func concreteTuple(msg string) (int, *MyError) { // want:"skipping *MyError result: concrete error types can't hold wrapped errors"
+	return 42, NewMyError(msg)
+}
+
+// Values that can't be nil are wrapped as usual.
+func Value(msg string) error {
+	return NewValueError(msg)
+}
+
+func Nil() error {
+	return nil
+}
@@ -0,0 +1,39 @@
+//go:build ignore
+
+// @runIf options=types
+package foo
+
+import (
+	"errors"
+	iofs "io/fs"
+)
+
+type Error = error
+
+type Bytes = []byte
+
+type reader struct {
+	next func() ([]byte, error)
+}
+
+// Aliases of []byte and error are matched with type information.
+func (r *reader) Read(p Bytes) (int, Error) {
+	buf, err := r.next()
+	if err != nil {
+		return 0, err
+	}
+	if len(buf) > len(p) {
+		return 0, errors.New("buffer too small")
+	}
+	return copy(p, buf), nil
+}
+
+func walk(fsys iofs.FS) error {
+	return iofs.WalkDir(fsys, ".", func(path string, d iofs.DirEntry, err error) error {
+		return visit(path)
+	})
+}
+
+func visit(path string) error {
+	return errors.New("not implemented")
+}
@@ -0,0 +1,42 @@
+//go:build ignore
+
+// @runIf options=types
+package foo
+
+import "errors"
+
+// Equivalent to error.
+type Error interface {
+	error
+}
+
+// Can't hold errors returned by errtrace.Wrap.
+type TemporaryError interface {
+	error
+	Temporary() bool
+}
+
+func Embedded() Error {
+	return errors.New("great sadness")
+}
+
+func EmbeddedTuple() (string, Error) {
+	return "", errors.New("great sadness")
+}
+
+func EmbeddedCall() (string, Error) {
+	return EmbeddedTuple()
+}
+
+func Extended(err TemporaryError) TemporaryError { // want:"skipping TemporaryError result: errtrace.Wrap returns error"
+	return err
+}
+
+func ExtendedTuple(err TemporaryError) (int, TemporaryError) { // want:"skipping TemporaryError result: errtrace.Wrap returns error"
+	return 0, err
+}
+
+// Unnamed interfaces are interchangeable with error.
+func Literal() interface{ Error() string } {
+	return errors.New("great sadness")
+}
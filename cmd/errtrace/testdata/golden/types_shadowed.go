@@ -0,0 +1,34 @@
+//go:build ignore
+
+// @runIf options=types
+package foo
+
+import "strconv"
+
+// Not the predeclared error type.
+type error struct {
+	msg string
+}
+
+func Shadowed() error {
+	return error{msg: "great sadness"}
+}
+
+func ShadowedTuple() (int, error) {
+	return 0, error{msg: "great sadness"}
+}
+
+func ShadowedCall() (int, error) {
+	return ShadowedTuple()
+}
+
+func ShadowedNamed() (err error) {
+	err = error{msg: "great sadness"}
+	return
+}
+
+// Results are still found by type.
+func Builtin() interface{ Error() string } {
+	_, err := strconv.Atoi("great sadness")
+	return err
+}
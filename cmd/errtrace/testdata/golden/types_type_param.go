@@ -0,0 +1,28 @@
+//go:build ignore
+
+// @runIf options=types
+package foo
+
+func TypeParam[E error](err E) E { // want:"skipping E result: type parameters can't hold wrapped errors"
+	return err
+}
+
+func TypeParamTuple[E error](err E) (int, E) { // want:"skipping E result: type parameters can't hold wrapped errors"
+	return 0, err
+}
+
+func TypeParamArgument[E error](err E) error {
+	return err
+}
+
+type Wrapper[E error] struct {
+	err E
+}
+
+func (w *Wrapper[E]) Get() E { // want:"skipping E result: type parameters can't hold wrapped errors"
+	return w.err
+}
+
+func (w *Wrapper[E]) Err() error {
+	return w.err
+}
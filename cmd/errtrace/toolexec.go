@@ -6,6 +6,7 @@ import (
 	"encoding/hex"
 	"flag"
 	"fmt"
+	"go/ast"
 	"io"
 	"os"
 	"os/exec"
@@ -16,6 +17,7 @@ import (
 	"strings"
 
 	"braces.dev/errtrace"
+	"braces.dev/errtrace/internal/rewrite"
 )
 
 // Note: Choose a prefix that is not likely to clash with user symbols.
@@ -47,6 +49,16 @@ func (cmd *mainCmd) handleToolExec(args []string) (exitCode int, handled bool) {
 	}
 
 	if version {
+		// Configuration files change the compiled code,
+		// so the version covers all of them.
+		if isCompile(p.Tool) {
+			hash, err := cmd.configHash()
+			if err != nil {
+				cmd.log.Print(err)
+				return 1, true
+			}
+			p.configHash = hash
+		}
 		return cmd.toolExecVersion(p), true
 	}
 	return cmd.toolExecRewrite(pkg, p), true
@@ -55,10 +67,19 @@ func (cmd *mainCmd) handleToolExec(args []string) (exitCode int, handled bool) {
 type toolExecParams struct {
 	RequiredPkgSelectors []string
 	UnsafePkgSelectors   []string
+	IncludeGenerated     bool
 
 	Tool     string
 	ToolArgs []string
 
+	// config is the errtrace.toml for the package.
+	// Its toolexec selectors are used in addition to the flags.
+	config *config
+
+	// configHash identifies the errtrace.toml files used by the build.
+	// It's only set when reporting the version.
+	configHash string
+
 	flags *flag.FlagSet
 }
 
@@ -73,6 +94,8 @@ func (p *toolExecParams) Parse(w io.Writer, args []string) error {
 		"that are expected to be import errtrace if they return errors.")
 	p.flags.StringVar(&unsafePkgs, "unsafe-packages", "", "comma-separated list of package selectors "+
 		"to rewrite using unsafe go:link, regardless of whether they import errtrace.")
+	p.flags.BoolVar(&p.IncludeGenerated, "include-generated", false,
+		"instrument generated files, which are skipped by default.")
 
 	// Flag parsing stops at the first non-flag argument (no "-").
 	if err := p.flags.Parse(args); err != nil {
@@ -98,14 +121,34 @@ func (p *toolExecParams) versionCacheKey() string {
 	withoutTool.flags = nil
 	withoutTool.Tool = ""
 	withoutTool.ToolArgs = nil
+	withoutTool.config = nil
+	withoutTool.configHash = ""
 
-	optStr := fmt.Sprintf("%v", withoutTool)
+	optStr := fmt.Sprintf("%v", withoutTool) + p.configHash
 	optHash := md5.Sum([]byte(optStr))
 	return hex.EncodeToString(optHash[:])
 }
 
+// requiredPkgSelectors returns the selectors for -required-packages
+// and the required-packages setting in errtrace.toml.
+func (p *toolExecParams) requiredPkgSelectors() []string {
+	if p.config == nil {
+		return p.RequiredPkgSelectors
+	}
+	return append(slices.Clip(p.RequiredPkgSelectors), p.config.RequiredPackages...)
+}
+
+// unsafePkgSelectors returns the selectors for -unsafe-packages
+// and the unsafe-packages setting in errtrace.toml.
+func (p *toolExecParams) unsafePkgSelectors() []string {
+	if p.config == nil {
+		return p.UnsafePkgSelectors
+	}
+	return append(slices.Clip(p.UnsafePkgSelectors), p.config.UnsafePackages...)
+}
+
 func (p *toolExecParams) requiredPackage(pkg string) bool {
-	for _, selector := range p.RequiredPkgSelectors {
+	for _, selector := range p.requiredPkgSelectors() {
 		if packageSelectorMatch(selector, pkg) {
 			return true
 		}
@@ -126,7 +169,7 @@ func (p *toolExecParams) unsafeRewrite(pkg string) bool {
 		return false
 	}
 
-	for _, selector := range p.UnsafePkgSelectors {
+	for _, selector := range p.unsafePkgSelectors() {
 		if packageSelectorMatch(selector, pkg) {
 			return true
 		}
@@ -167,6 +210,89 @@ func (cmd *mainCmd) toolExecVersion(p toolExecParams) int {
 	return 0
 }
 
+// configHash returns a hash of the errtrace.toml files
+// that may apply to packages built from the current directory.
+//
+// Files in the main modules and in modules replaced by local directories
+// are hashed directly.
+// Other modules are in the module cache, where they can't change,
+// so their versions identify their errtrace.toml files.
+func (cmd *mainCmd) configHash() (string, error) {
+	mods, err := goListModules()
+	if err != nil {
+		return "", errtrace.Wrap(err)
+	}
+	if len(mods) == 0 {
+		// Not in a module.
+		dc, err := cmd.configs.Load(".")
+		if err != nil {
+			return "", errtrace.Wrap(err)
+		}
+		return dc.Config.Hash, nil
+	}
+
+	h := md5.New()
+	for _, mod := range mods {
+		if mod.Dir == "" {
+			fmt.Fprintf(h, "%s@%s\n", mod.Path, mod.Version)
+			continue
+		}
+
+		hash, err := cmd.configs.HashTree(mod.Dir)
+		if err != nil {
+			return "", errtrace.Wrap(err)
+		}
+		fmt.Fprintf(h, "%s %s\n", mod.Path, hash)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// listedModule is a module reported by goListModules.
+type listedModule struct {
+	Path    string
+	Version string // for modules in the module cache
+	Dir     string // for main modules and local replacements
+}
+
+// goListModules returns the modules in the build list
+// of the current directory's module, if any.
+func goListModules() ([]listedModule, error) {
+	const format = `{{.Path}} ` +
+		`{{if .Main}}dir {{.Dir}}` +
+		`{{else if .Replace}}{{if .Replace.Version}}mod {{.Replace.Version}}{{else}}dir {{.Replace.Dir}}{{end}}` +
+		`{{else}}mod {{.Version}}{{end}}`
+
+	// The build list isn't available with -mod=vendor,
+	// but then all packages are in the main module.
+	out, err := _execCommand("go", "list", "-m", "-f", format, "all").Output()
+	if err != nil {
+		var stderr bytes.Buffer
+		cmd := _execCommand("go", "list", "-m", "-f", format)
+		cmd.Stderr = &stderr
+		out, err = cmd.Output()
+		if err != nil {
+			return nil, errtrace.Wrap(fmt.Errorf("go list: %w\n%s", err, stderr.String()))
+		}
+	}
+
+	var mods []listedModule
+	for _, line := range strings.Split(string(out), "\n") {
+		modPath, rest, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		kind, value, _ := strings.Cut(rest, " ")
+		switch {
+		case kind == "dir" && value != "":
+			mods = append(mods, listedModule{Path: modPath, Dir: value})
+		case kind == "mod":
+			mods = append(mods, listedModule{Path: modPath, Version: value})
+		}
+		// Outside a module, the main module has no directory.
+	}
+	return mods, nil
+}
+
 func (cmd *mainCmd) toolExecRewrite(pkg string, p toolExecParams) (exitCode int) {
 	// We only need to modify the arguments for "compile" calls which work with .go files.
 	if !isCompile(p.Tool) {
@@ -178,6 +304,25 @@ func (cmd *mainCmd) toolExecRewrite(pkg string, p toolExecParams) (exitCode int)
 		return cmd.runOriginal(p)
 	}
 
+	// Use the errtrace.toml closest to the package, if any,
+	// like the other modes do.
+	for _, arg := range p.ToolArgs {
+		if !isGoFile(arg) {
+			continue
+		}
+
+		dc, err := cmd.configs.Load(filepath.Dir(arg))
+		if err != nil {
+			cmd.log.Print(err)
+			return 1
+		}
+		p.config = dc.Config
+		break
+	}
+	if p.config == nil || !p.config.IncludesPackage(pkg) {
+		return cmd.runOriginal(p)
+	}
+
 	exitCode, err := cmd.rewriteCompile(pkg, p)
 	if err != nil {
 		cmd.log.Print(err)
@@ -188,7 +333,7 @@ func (cmd *mainCmd) toolExecRewrite(pkg string, p toolExecParams) (exitCode int)
 }
 
 func (cmd *mainCmd) rewriteCompile(pkg string, p toolExecParams) (exitCode int, _ error) {
-	parsed, err := cmd.parsePkg(pkg, p.ToolArgs)
+	parsed, err := cmd.parsePkg(pkg, p)
 	if err != nil {
 		return -1, errtrace.Wrap(err)
 	}
@@ -223,22 +368,20 @@ func (cmd *mainCmd) rewriteCompile(pkg string, p toolExecParams) (exitCode int,
 	newArgs := make([]string, 0, len(p.ToolArgs))
 	for _, arg := range p.ToolArgs {
 		f, ok := parsed.files[arg]
-		if !ok || len(f.inserts) == 0 {
+		if !ok || !f.Changed() {
 			newArgs = append(newArgs, arg)
 			continue
 		}
 
 		if unsafeForceImport {
-			f.errtraceUnsafePrefix = errtraceUnsafePrefix
+			f.UnsafePrefix = errtraceUnsafePrefix
 		}
 
 		// Add a //line directive so the original filepath is used in errors and panics.
 		out := &bytes.Buffer{}
 		_, _ = fmt.Fprintf(out, "//line %v:1\n", arg)
 
-		if err := cmd.rewriteFile(f, out); err != nil {
-			return -1, errtrace.Wrap(err)
-		}
+		_, _ = out.Write(f.Rewrite())
 
 		if addLinkName {
 			_, _ = fmt.Fprintf(out, "\n\n//go:linkname %vWrap %v.Wrap\n", errtraceUnsafePrefix, errtracePkgImport)
@@ -261,43 +404,59 @@ func (cmd *mainCmd) rewriteCompile(pkg string, p toolExecParams) (exitCode int,
 
 type parsePkgState struct {
 	pkg             string
-	files           map[string]parsedFile
+	files           map[string]*rewrite.Result
 	importsErrtrace bool
 	needsRewrite    bool
 }
 
-func (cmd *mainCmd) parsePkg(pkg string, toolArgs []string) (*parsePkgState, error) {
+func (cmd *mainCmd) parsePkg(pkg string, p toolExecParams) (*parsePkgState, error) {
+	cfg := p.config
 	s := &parsePkgState{
 		pkg:   pkg,
-		files: make(map[string]parsedFile),
+		files: make(map[string]*rewrite.Result),
 	}
 
-	for _, arg := range toolArgs {
+	for _, arg := range p.ToolArgs {
 		if !isGoFile(arg) {
 			continue
 		}
 
+		if abs, err := filepath.Abs(arg); err == nil && !cfg.IncludesFile(abs) {
+			continue
+		}
+
 		contents, err := os.ReadFile(arg)
 		if err != nil {
 			return nil, errtrace.Wrap(err)
 		}
 
-		f, err := cmd.parseFile(arg, contents, rewriteOpts{
+		sf, err := rewrite.ParseFile(arg, contents)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		if cfg.Generated == generatedSkip && !p.IncludeGenerated && ast.IsGenerated(sf.Syntax) {
+			continue
+		}
+
+		f := rewrite.Inspect(sf, rewrite.Options{
 			// WrapN is not compatible with unsafe rewrites, as `go:linkname`
 			// can't be used for generic functions like WrapN.
 			// We don't need WrapN, as it's is meant for direct source file changes,
 			// while toolexec writes ephemeral temp files.
-			NoWrapN: true,
+			NoWrapN:            true,
+			Sentinels:          cfg.Sentinels,
+			NoDefaultSentinels: cfg.NoDefaultSentinels,
+			PkgPath:            pkg,
 		})
-		if err != nil {
-			return nil, errtrace.Wrap(err)
+		for _, d := range f.Diagnostics {
+			cmd.log.Print(d)
 		}
 		s.files[arg] = f
 
-		if f.importsErrtrace {
+		if f.ImportsErrtrace() {
 			s.importsErrtrace = true
 		}
-		if len(f.inserts) > 0 {
+		if f.Changed() {
 			s.needsRewrite = true
 		}
 	}
@@ -23,15 +23,7 @@ func TestToolExec(t *testing.T) {
 	const testProgDir = "./testdata/toolexec-test"
 	const testProgPkg = "braces.dev/errtrace/cmd/errtrace/testdata/toolexec-test/"
 
-	errTraceCmd := filepath.Join(t.TempDir(), "errtrace")
-	if runtime.GOOS == "windows" {
-		errTraceCmd += ".exe" // can't run binaries on Windows otherwise.
-	}
-	_, stderr, err := runGo(t, ".", "build", "-o", errTraceCmd, ".")
-	if err != nil {
-		t.Fatalf("compile errtrace failed: %v\nstderr: %s", err, stderr)
-	}
-
+	errTraceCmd := buildErrTrace(t)
 	wantTraces := tracePaths(t, testProgDir, "@trace")
 
 	tests := []struct {
@@ -190,6 +182,137 @@ func TestToolExec(t *testing.T) {
 	})
 }
 
+// TestToolExecNestedConfig verifies that -toolexec uses the same
+// errtrace.toml files as the CLI, and that changes to them invalidate
+// the build cache.
+func TestToolExecNestedConfig(t *testing.T) {
+	root, err := filepath.Abs("../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	errTraceCmd := buildErrTrace(t)
+
+	files := map[string]string{
+		"go.mod": strings.Join([]string{
+			"module example.com/m",
+			"go 1.22",
+			"require braces.dev/errtrace v0.0.0",
+			"replace braces.dev/errtrace => " + root,
+		}, "\n"),
+		"errtrace.toml": `sentinels = ["example.com/m/b.ErrB"]`,
+		"main.go": strings.Join([]string{
+			"package main",
+			`import "fmt"`,
+			`import "braces.dev/errtrace"`,
+			`import "example.com/m/a"`,
+			`import "example.com/m/b"`,
+			"func main() {",
+			"	for _, err := range []error{a.Get(), b.Get()} {",
+			"		var funcs []string",
+			"		for {",
+			"			frame, inner, ok := errtrace.UnwrapFrame(err)",
+			"			if !ok {",
+			"				break",
+			"			}",
+			"			funcs = append(funcs, frame.Function)",
+			"			err = inner",
+			"		}",
+			"		fmt.Println(err, funcs)",
+			"	}",
+			"}",
+		}, "\n"),
+		"a/a.go": strings.Join([]string{
+			"package a",
+			`import "errors"`,
+			`import "braces.dev/errtrace"`,
+			`var ErrA = errors.New("a")`,
+			"func Get() error { return ErrA }",
+			`func New() error { return errtrace.New("new") }`,
+		}, "\n"),
+		"b/b.go": strings.Join([]string{
+			"package b",
+			`import "errors"`,
+			`import "braces.dev/errtrace"`,
+			`var ErrB = errors.New("b")`,
+			"func Get() error { return ErrB }",
+			`func New() error { return errtrace.New("new") }`,
+		}, "\n"),
+	}
+
+	toolDir := t.TempDir()
+	writeFiles(t, toolDir, files)
+	if _, stderr, err := runGo(t, toolDir, "mod", "tidy"); err != nil {
+		t.Fatalf("go mod tidy: %v\n%s", err, stderr)
+	}
+
+	// The nested file replaces the one at the module root for package a.
+	tests := []struct {
+		name   string
+		config string // a/errtrace.toml
+		want   string
+	}{
+		{
+			name:   "sentinel",
+			config: `sentinels = ["example.com/m/a.ErrA"]`,
+			want:   "a []\nb []\n",
+		},
+		{
+			// Edits to the file must invalidate cached builds.
+			name:   "no sentinels",
+			config: "sentinels = []",
+			want:   "a [example.com/m/a.Get]\nb []\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			writeFiles(t, toolDir, map[string]string{"a/errtrace.toml": tt.config})
+			toolOut, stderr, err := runGo(t, toolDir, "run", "-toolexec", errTraceCmd, ".")
+			if err != nil {
+				t.Fatalf("go run -toolexec: %v\n%s", err, stderr)
+			}
+
+			cliDir := t.TempDir()
+			writeFiles(t, cliDir, files)
+			writeFiles(t, cliDir, map[string]string{"a/errtrace.toml": tt.config})
+			if _, stderr, err := runGo(t, cliDir, "mod", "tidy"); err != nil {
+				t.Fatalf("go mod tidy: %v\n%s", err, stderr)
+			}
+			rewrite := exec.Command(errTraceCmd, "-w", "./...")
+			rewrite.Dir = cliDir
+			if out, err := rewrite.CombinedOutput(); err != nil {
+				t.Fatalf("errtrace -w: %v\n%s", err, out)
+			}
+			cliOut, stderr, err := runGo(t, cliDir, "run", ".")
+			if err != nil {
+				t.Fatalf("go run: %v\n%s", err, stderr)
+			}
+
+			if toolOut != cliOut {
+				t.Errorf("toolexec output:\n%s\ndoesn't match CLI output:\n%s", indent(toolOut), indent(cliOut))
+			}
+			if toolOut != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s", indent(toolOut), indent(tt.want))
+			}
+		})
+	}
+}
+
+// buildErrTrace builds errtrace and returns the path to the binary.
+func buildErrTrace(t testing.TB) string {
+	t.Helper()
+
+	errTraceCmd := filepath.Join(t.TempDir(), "errtrace")
+	if runtime.GOOS == "windows" {
+		errTraceCmd += ".exe" // can't run binaries on Windows otherwise.
+	}
+	_, stderr, err := runGo(t, ".", "build", "-o", errTraceCmd, ".")
+	if err != nil {
+		t.Fatalf("compile errtrace failed: %v\nstderr: %s", err, stderr)
+	}
+	return errTraceCmd
+}
+
 func tracePaths(t testing.TB, path string, traceMarker string) []string {
 	var wantTraces []string
 	err := filepath.Walk(path, func(path string, info fs.FileInfo, err error) error {
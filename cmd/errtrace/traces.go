@@ -0,0 +1,419 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+// traceOccurrence is a return trace found in log output.
+type traceOccurrence struct {
+	Tree errtrace.TraceTree
+
+	// Text is the trace as printed by errtrace.Format,
+	// without any log prefixes.
+	Text string
+
+	Source string    // name of the log file
+	Line   int       // line number where the trace starts
+	Time   time.Time // time of the log entry, if known
+}
+
+// scanTraces finds return traces printed by errtrace.Format
+// inside log output, and calls found for each one, in order.
+//
+// The following forms of traces are recognized:
+//
+//   - plain traces and trees of multi-errors,
+//     optionally with a log prefix (e.g. a timestamp) on the first line
+//   - traces inside string values of JSON log entries
+//     (e.g. from log/slog's JSON handler)
+//
+// The textual format is detected heuristically.
+// For plain traces, a multi-line message only includes its preceding lines
+// if the first of them starts with a timestamp,
+// and there are at most _maxEntryLines of them.
+// Otherwise, only the last line is considered part of the trace
+// because preceding lines can't be told apart from other log entries.
+func scanTraces(r io.Reader, source string, found func(traceOccurrence)) error {
+	s := traceScanner{
+		scan:   bufio.NewScanner(r),
+		source: source,
+		lineNo: 1,
+	}
+	s.scan.Buffer(nil, 16<<20) // JSON log entries may be large
+
+	for {
+		if _, ok := s.line(0); !ok {
+			break
+		}
+
+		n := s.matchJSON(found)
+		if n == 0 {
+			n = s.matchTree(found)
+		}
+		if n == 0 {
+			n = s.matchTrace(found)
+		}
+		s.advance(max(n, 1))
+		if n > 0 {
+			// Lines of a matched entry can't start the next one.
+			s.prev = s.prev[:0]
+		}
+	}
+
+	return errtrace.Wrap(s.scan.Err())
+}
+
+// traceScanner reads lines from a log
+// with arbitrary lookahead.
+type traceScanner struct {
+	scan   *bufio.Scanner
+	source string
+
+	buf    []string // lines read but not yet consumed
+	lineNo int      // line number of buf[0]
+
+	// prev holds up to _maxEntryLines lines consumed before buf[0]
+	// since the last match, most recent last.
+	prev []string
+}
+
+// _maxEntryLines is the maximum number of lines
+// before the last line of a multi-line message
+// that are searched for the start of its log entry.
+const _maxEntryLines = 20
+
+// line returns the i-th line from the current position,
+// reporting false if the input ends before that.
+func (s *traceScanner) line(i int) (string, bool) {
+	for len(s.buf) <= i {
+		if !s.scan.Scan() {
+			return "", false
+		}
+		s.buf = append(s.buf, strings.TrimSuffix(s.scan.Text(), "\r"))
+	}
+	return s.buf[i], true
+}
+
+// advance consumes n lines.
+func (s *traceScanner) advance(n int) {
+	n = min(n, len(s.buf))
+	s.prev = append(s.prev, s.buf[:n]...)
+	if extra := len(s.prev) - _maxEntryLines; extra > 0 {
+		s.prev = append(s.prev[:0], s.prev[extra:]...)
+	}
+	s.buf = s.buf[n:]
+	s.lineNo += n
+}
+
+// entryStart returns the consumed lines of a log entry
+// that continues on the current line:
+// all lines since the last one that starts with a timestamp,
+// or nil if there's a blank line or no timestamp before that.
+func (s *traceScanner) entryStart() []string {
+	for i := len(s.prev) - 1; i >= 0; i-- {
+		line := s.prev[i]
+		if strings.TrimSpace(line) == "" {
+			return nil
+		}
+		if t, _ := cutLogTime(line); !t.IsZero() {
+			return slices.Clone(s.prev[i:])
+		}
+	}
+	return nil
+}
+
+// matchJSON matches a JSON log entry,
+// reporting traces found inside its string values.
+func (s *traceScanner) matchJSON(found func(traceOccurrence)) int {
+	line, _ := s.line(0)
+	if !strings.HasPrefix(strings.TrimSpace(line), "{") {
+		return 0
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal([]byte(line), &entry); err != nil {
+		return 0
+	}
+
+	entryTime := jsonLogTime(entry)
+	var walk func(v any)
+	walk = func(v any) {
+		switch v := v.(type) {
+		case string:
+			if !strings.Contains(v, "\n\t") {
+				return
+			}
+
+			// Traces inside JSON are free of log prefixes,
+			// so we can use the same logic as for plain text.
+			_ = scanTraces(strings.NewReader(v), s.source, func(occ traceOccurrence) {
+				occ.Line = s.lineNo
+				if occ.Time.IsZero() {
+					occ.Time = entryTime
+				}
+				found(occ)
+			})
+
+		case map[string]any:
+			for _, k := range sortedKeys(v) {
+				walk(v[k])
+			}
+
+		case []any:
+			for _, x := range v {
+				walk(x)
+			}
+		}
+	}
+	walk(entry)
+
+	return 1
+}
+
+// matchTree matches a tree of traces for a multi-error.
+//
+//	[prefix]+- err a
+//	|
+//	|  foo
+//	|  	foo.go:42
+//	|
+//	+- err b
+//	|
+//	err a
+//	err b
+//
+//	bar
+//		bar.go:24
+func (s *traceScanner) matchTree(found func(traceOccurrence)) int {
+	first, _ := s.line(0)
+	next, ok := s.line(1)
+	if !ok {
+		return 0
+	}
+
+	// The first line of a tree is the first line of its leftmost leaf,
+	// so the next line has a single pipe right below the "+- ".
+	// This gives us the column where the tree starts.
+	col := strings.IndexByte(next, '|')
+	if col < 0 || strings.TrimSpace(next[:col]) != "" {
+		return 0
+	}
+	idx := strings.Index(first, "+- ")
+	start := idx - col
+	if idx < 0 || start < 0 || strings.TrimSpace(first[start:idx]) != "" {
+		return 0
+	}
+	prefix := first[:start]
+
+	lines := []string{first[start:]}
+	for i := 1; ; i++ {
+		line, ok := s.line(i)
+		if !ok || !isTreeDrawing(line) {
+			break
+		}
+		lines = append(lines, line)
+	}
+
+	// Use a placeholder root to find the children.
+	// Errors inside a multi-error usually make up its message,
+	// so we use them to guess how long the root message is.
+	tree, err := errtrace.ParseTrace(strings.NewReader(strings.Join(lines, "\n") + "\n-"))
+	if err != nil || len(tree.Children) == 0 {
+		return 0
+	}
+	var rootLines int
+	for _, child := range tree.Children {
+		rootLines += strings.Count(child.Message, "\n") + 1
+	}
+
+	for i := 0; i < rootLines; i++ {
+		line, ok := s.line(len(lines))
+		if !ok || line == "" || isTreeDrawing(line) {
+			break
+		}
+		lines = append(lines, line)
+	}
+	lines = append(lines, s.frames(len(lines))...)
+
+	return s.found(found, prefix, lines, 0)
+}
+
+// matchTrace matches a trace for a single error.
+//
+//	[prefix]error message
+//
+//	foo
+//		foo.go:42
+func (s *traceScanner) matchTrace(found func(traceOccurrence)) int {
+	msg, _ := s.line(0)
+	if strings.TrimSpace(msg) == "" {
+		return 0
+	}
+
+	frames := s.frames(1)
+	if len(frames) == 0 {
+		return 0
+	}
+
+	lines := append([]string{msg}, frames...)
+
+	// In multi-line messages, only the first line has a log prefix.
+	// Include the lines before this one if they start with it.
+	var before []string
+	if t, _ := cutLogTime(msg); t.IsZero() {
+		before = s.entryStart()
+	}
+	return s.found(found, "", append(before, lines...), len(before))
+}
+
+// frames returns the empty line and frames that make up a trace
+// starting at the i-th line, or nil if there's no trace there.
+func (s *traceScanner) frames(i int) []string {
+	if line, ok := s.line(i); !ok || strings.TrimSpace(line) != "" {
+		return nil
+	}
+
+	lines := []string{""}
+	for j := i + 1; ; j += 2 {
+		fn, ok := s.line(j)
+		if !ok {
+			break
+		}
+		loc, ok := s.line(j + 1)
+		if !ok || !isFrame(fn, loc) {
+			break
+		}
+		lines = append(lines, fn, loc)
+	}
+
+	if len(lines) == 1 {
+		return nil
+	}
+	return lines
+}
+
+// found parses the given trace and reports it,
+// returning the number of lines consumed.
+// The first consumed lines of the trace are already consumed.
+func (s *traceScanner) found(found func(traceOccurrence), prefix string, lines []string, consumed int) int {
+	occ := traceOccurrence{
+		Source: s.source,
+		Line:   s.lineNo - consumed,
+	}
+
+	// Timestamps are usually at the start of the log entry.
+	if prefix == "" {
+		occ.Time, lines[0] = cutLogPrefix(lines[0])
+	} else {
+		occ.Time, _ = cutLogTime(prefix)
+	}
+
+	occ.Text = strings.Join(lines, "\n") + "\n"
+	tree, err := errtrace.ParseTrace(strings.NewReader(occ.Text))
+	if err != nil {
+		return 0
+	}
+	occ.Tree = tree
+
+	found(occ)
+	return len(lines) - consumed
+}
+
+var _frameFile = regexp.MustCompile(`^\t.+:\d+$`)
+
+// isFrame reports whether the given lines are a frame of a trace
+// without any tree drawing.
+func isFrame(fn, loc string) bool {
+	return fn != "" &&
+		!strings.HasPrefix(fn, " ") &&
+		!strings.HasPrefix(fn, "\t") &&
+		_frameFile.MatchString(loc)
+}
+
+// isTreeDrawing reports whether the line belongs to a node
+// below the root of a tree: it starts with pipes or "+- ".
+func isTreeDrawing(line string) bool {
+	line = strings.TrimLeft(line, " ")
+	return strings.HasPrefix(line, "|") || strings.HasPrefix(line, "+- ")
+}
+
+var _logTime = regexp.MustCompile(`^\s*\[?(\d{4}[-/]\d{2}[-/]\d{2})[T ](\d{2}:\d{2}:\d{2}(?:[.,]\d+)?)(Z|[+-]\d{2}:?\d{2})?\]?\s*`)
+
+// cutLogTime extracts a timestamp from the start of a log line,
+// returning the rest of the line.
+// The zero time is returned if there's no timestamp.
+func cutLogTime(line string) (time.Time, string) {
+	m := _logTime.FindStringSubmatch(line)
+	if m == nil {
+		return time.Time{}, line
+	}
+
+	date := strings.ReplaceAll(m[1], "/", "-")
+	clock := strings.ReplaceAll(m[2], ",", ".")
+	zone := m[3]
+	if zone == "" {
+		zone = "Z"
+	} else if len(zone) == 5 { // +0700
+		zone = zone[:3] + ":" + zone[3:]
+	}
+
+	t, err := time.Parse(time.RFC3339Nano, date+"T"+clock+zone)
+	if err != nil {
+		return time.Time{}, line
+	}
+	return t, line[len(m[0]):]
+}
+
+var _logLevel = regexp.MustCompile(`^(?:\[[A-Za-z]+\]|(?:TRACE|DEBUG|INFO|WARN|WARNING|ERROR|FATAL|PANIC|CRITICAL):?)\s+`)
+
+// cutLogPrefix extracts a timestamp from the start of a log line
+// like cutLogTime, and also removes a log level after it,
+// e.g. "ERROR" or "[error]".
+// Levels are only removed after a timestamp
+// because otherwise they can't be told apart from the message.
+func cutLogPrefix(line string) (time.Time, string) {
+	t, rest := cutLogTime(line)
+	if t.IsZero() {
+		return t, line
+	}
+	if m := _logLevel.FindString(rest); m != "" {
+		rest = rest[len(m):]
+	}
+	return t, rest
+}
+
+// jsonLogTime returns the time of a JSON log entry
+// using field names from popular logging libraries.
+func jsonLogTime(entry map[string]any) time.Time {
+	for _, key := range []string{"time", "ts", "timestamp", "@timestamp"} {
+		switch v := entry[key].(type) {
+		case string:
+			if t, rest := cutLogTime(v); rest == "" {
+				return t
+			}
+		case float64:
+			// Seconds since the Unix epoch, e.g. from zap.
+			sec := int64(v)
+			return time.Unix(sec, int64((v-float64(sec))*1e9)).UTC()
+		}
+	}
+	return time.Time{}
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
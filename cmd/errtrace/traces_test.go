@@ -0,0 +1,258 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"braces.dev/errtrace"
+)
+
+func TestScanTraces(t *testing.T) {
+	singleTrace := errtrace.TraceTree{
+		Message: "open config: no such file",
+		Trace: []runtime.Frame{
+			{Function: "example.com/app.loadConfig", File: "/src/app/config.go", Line: 12},
+			{Function: "example.com/app.main", File: "/src/app/main.go", Line: 30},
+		},
+	}
+	singleLines := []string{
+		"open config: no such file",
+		"",
+		"example.com/app.loadConfig",
+		"	/src/app/config.go:12",
+		"example.com/app.main",
+		"	/src/app/main.go:30",
+	}
+
+	multiTrace := errtrace.TraceTree{
+		Message: "err a\nerr b",
+		Trace: []runtime.Frame{
+			{Function: "bar", File: "bar.go", Line: 24},
+		},
+		Children: []errtrace.TraceTree{
+			{
+				Message: "err a",
+				Trace: []runtime.Frame{
+					{Function: "foo", File: "foo.go", Line: 42},
+				},
+			},
+			{Message: "err b"},
+		},
+	}
+	multiLines := []string{
+		"+- err a",
+		"|  ",
+		"|  foo",
+		"|  	foo.go:42",
+		"|  ",
+		"+- err b",
+		"|  ",
+		"err a",
+		"err b",
+		"",
+		"bar",
+		"	bar.go:24",
+	}
+
+	jsonEntry := func(fields map[string]any) string {
+		bs, err := json.Marshal(fields)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(bs)
+	}
+
+	ts := time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC)
+
+	tests := []struct {
+		name string
+		give []string // lines
+		want []traceOccurrence
+	}{
+		{name: "empty"},
+		{
+			name: "no traces",
+			give: []string{
+				"starting server",
+				"",
+				"listening on :8080",
+				"	indented: 42",
+			},
+		},
+		{
+			name: "single",
+			give: singleLines,
+			want: []traceOccurrence{
+				{Tree: singleTrace, Line: 1},
+			},
+		},
+		{
+			name: "log prefix with time",
+			give: append([]string{
+				"starting server",
+				"2024/01/02 15:04:05 " + singleLines[0],
+			}, singleLines[1:]...),
+			want: []traceOccurrence{
+				{Tree: singleTrace, Line: 2, Time: ts},
+			},
+		},
+		{
+			name: "log level",
+			give: append([]string{
+				"2024/01/02 15:04:05 ERROR " + singleLines[0],
+			}, singleLines[1:]...),
+			want: []traceOccurrence{
+				{Tree: singleTrace, Line: 1, Time: ts},
+			},
+		},
+		{
+			name: "multi-line message with log prefix",
+			give: append([]string{
+				"starting server",
+				"2024/01/02 15:04:05 [error] loading failed:",
+				"  " + singleLines[0],
+			}, singleLines[1:]...),
+			want: []traceOccurrence{
+				{
+					Tree: errtrace.TraceTree{
+						Message: "loading failed:\n  " + singleTrace.Message,
+						Trace:   singleTrace.Trace,
+					},
+					Line: 2,
+					Time: ts,
+				},
+			},
+		},
+		{
+			name: "multi-line message after blank line",
+			give: append([]string{
+				"2024/01/02 15:04:05 starting server",
+				"",
+			}, singleLines...),
+			want: []traceOccurrence{
+				{Tree: singleTrace, Line: 3},
+			},
+		},
+		{
+			name: "RFC 3339 time",
+			give: append([]string{
+				"2024-01-02T17:04:05.5+02:00 " + singleLines[0],
+			}, singleLines[1:]...),
+			want: []traceOccurrence{
+				{Tree: singleTrace, Line: 1, Time: ts.Add(500 * time.Millisecond)},
+			},
+		},
+		{
+			name: "multi error",
+			give: multiLines,
+			want: []traceOccurrence{
+				{Tree: multiTrace, Line: 1},
+			},
+		},
+		{
+			name: "multi error with prefix",
+			give: append([]string{
+				"[2024-01-02 15:04:05] ERROR " + multiLines[0],
+			}, multiLines[1:]...),
+			want: []traceOccurrence{
+				{Tree: multiTrace, Line: 1, Time: ts},
+			},
+		},
+		{
+			name: "multi error without root trace",
+			give: append(append([]string{}, multiLines[:9]...), "next entry"),
+			want: []traceOccurrence{
+				{
+					Tree: errtrace.TraceTree{
+						Message:  multiTrace.Message,
+						Children: multiTrace.Children,
+					},
+					Line: 1,
+				},
+			},
+		},
+		{
+			name: "json",
+			give: []string{
+				jsonEntry(map[string]any{
+					"time":  "2024-01-02T15:04:05Z",
+					"level": "ERROR",
+					"msg":   "request failed",
+					"error": strings.Join(singleLines, "\n") + "\n",
+				}),
+				jsonEntry(map[string]any{
+					"ts":    float64(ts.Unix()),
+					"error": strings.Join(multiLines, "\n") + "\n",
+				}),
+			},
+			want: []traceOccurrence{
+				{Tree: singleTrace, Line: 1, Time: ts},
+				{Tree: multiTrace, Line: 2, Time: ts},
+			},
+		},
+		{
+			name: "several",
+			give: append(append(append(
+				[]string{"starting"},
+				singleLines...),
+				"retrying"),
+				multiLines...),
+			want: []traceOccurrence{
+				{Tree: singleTrace, Line: 2},
+				{Tree: multiTrace, Line: 9},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []traceOccurrence
+			err := scanTraces(strings.NewReader(strings.Join(tt.give, "\n")), "test.log", func(occ traceOccurrence) {
+				occ.Text = "" // verified separately
+				if !occ.Time.IsZero() {
+					occ.Time = occ.Time.UTC()
+				}
+				got = append(got, occ)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := range tt.want {
+				tt.want[i].Source = "test.log"
+			}
+			if !reflect.DeepEqual(tt.want, got) {
+				t.Errorf("occurrences mismatch\nwant: %+v\ngot:  %+v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestScanTraces_formatted(t *testing.T) {
+	err := errtrace.Wrap(errors.Join(
+		errtrace.New("err a"),
+		errtrace.Wrap(errors.New("err b")),
+	))
+	want := errtrace.FormatString(err)
+
+	log := "2024/01/02 15:04:05 request failed: " + want + "2024/01/02 15:04:06 done\n"
+
+	var got []string
+	if err := scanTraces(strings.NewReader(log), "test.log", func(occ traceOccurrence) {
+		got = append(got, occ.Text)
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got) != 1 {
+		t.Fatalf("want 1 trace, got %d: %q", len(got), got)
+	}
+	if got[0] != want {
+		t.Errorf("trace mismatch\nwant:\n%s\ngot:\n%s", want, got[0])
+	}
+}
@@ -0,0 +1,322 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+
+	"braces.dev/errtrace"
+	"braces.dev/errtrace/internal/rewrite"
+)
+
+// goListPackage is the subset of 'go list -json' output
+// needed to type-check packages.
+type goListPackage struct {
+	ImportPath string
+	Dir        string
+	ForTest    string // package under test, if this is a test variant
+	Export     string // path to export data
+	DepOnly    bool   // only listed as a dependency
+	GoFiles    []string
+	CgoFiles   []string
+	ImportMap  map[string]string
+	Error      *struct{ Err string }
+}
+
+// loadTypes type-checks the packages containing the given files.
+//
+// Packages are type-checked from source,
+// with the export data produced by 'go list -export' for their imports.
+// In-package test files are type-checked with the package's test variant.
+//
+// The returned maps are keyed by absolute file path.
+// typed holds files that were type-checked,
+// and typeErrs holds the error for files whose package failed to type-check.
+// Files that aren't part of a package that 'go list' can load
+// (e.g. files excluded by build constraints) are in neither.
+func loadTypes(files []string) (typed map[string]*rewrite.File, typeErrs map[string]error, _ error) {
+	var dirs []string
+	want := make(map[string]struct{}) // absolute paths of requested files
+	for _, file := range files {
+		if file == "-" {
+			continue
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, nil, errtrace.Wrap(err)
+		}
+		want[abs] = struct{}{}
+
+		if dir := filepath.Dir(abs); !slices.Contains(dirs, dir) {
+			dirs = append(dirs, dir)
+		}
+	}
+	if len(dirs) == 0 {
+		return nil, nil, nil
+	}
+
+	pkgs, err := _goListExport(true /* test */, dirs)
+	if err != nil {
+		return nil, nil, errtrace.Wrap(err)
+	}
+
+	// Pick the package to type-check each requested file with.
+	// Test variants include all files of the package under test,
+	// so prefer them over the package itself.
+	owners := make(map[string]*goListPackage)
+	for _, pkg := range pkgs {
+		if pkg.DepOnly {
+			continue
+		}
+
+		for _, file := range pkg.files() {
+			if _, ok := want[file]; !ok {
+				continue
+			}
+			if owners[file] == nil || pkg.ForTest != "" {
+				owners[file] = pkg
+			}
+		}
+	}
+
+	owned := make(map[*goListPackage]struct{}, len(owners))
+	for _, pkg := range owners {
+		owned[pkg] = struct{}{}
+	}
+
+	fset := token.NewFileSet()
+	imp := newExportImporter(fset, pkgs)
+	typed = make(map[string]*rewrite.File)
+	typeErrs = make(map[string]error)
+	for _, pkg := range pkgs {
+		if _, ok := owned[pkg]; !ok {
+			continue
+		}
+
+		// A package that doesn't type-check doesn't stop the run.
+		// Its files fall back to finding errors syntactically.
+		srcFiles, err := typeCheckPackage(fset, pkg, imp)
+		if err != nil {
+			err = fmt.Errorf("type-check %v: %w", pkg.ImportPath, err)
+			for file, owner := range owners {
+				if owner == pkg {
+					typeErrs[file] = err
+				}
+			}
+			continue
+		}
+
+		for _, f := range srcFiles {
+			file := fset.File(f.Syntax.Pos()).Name()
+			if owners[file] == pkg {
+				typed[file] = f
+			}
+		}
+	}
+
+	return typed, typeErrs, nil
+}
+
+// typeCheckPackage parses and type-checks the files of the given package.
+func typeCheckPackage(fset *token.FileSet, pkg *goListPackage, imp *exportImporter) ([]*rewrite.File, error) {
+	srcFiles := make([]*rewrite.File, 0, len(pkg.GoFiles)+len(pkg.CgoFiles))
+	for _, file := range pkg.files() {
+		src, err := os.ReadFile(file)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+
+		f, err := parser.ParseFile(fset, file, src, parser.ParseComments)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+		srcFiles = append(srcFiles, &rewrite.File{Src: src, Fset: fset, Syntax: f})
+	}
+
+	if err := typeCheck(pkg.ImportPath, srcFiles, imp.forPackage(pkg)); err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	return srcFiles, nil
+}
+
+// typeCheckFile type-checks a single file on its own.
+// This is used for files that 'go list' doesn't report as part of a package,
+// e.g. stdin or files excluded by build constraints.
+func typeCheckFile(filename string, src []byte) (*rewrite.File, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	var imports []string
+	for _, imp := range f.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil || path == "C" || path == "unsafe" || slices.Contains(imports, path) {
+			continue
+		}
+		imports = append(imports, path)
+	}
+
+	var pkgs []*goListPackage
+	if len(imports) > 0 {
+		pkgs, err = _goListExport(false /* test */, imports)
+		if err != nil {
+			return nil, errtrace.Wrap(err)
+		}
+	}
+
+	sf := &rewrite.File{Src: src, Fset: fset, Syntax: f}
+	imp := newExportImporter(fset, pkgs)
+	if err := typeCheck(f.Name.Name, []*rewrite.File{sf}, imp); err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	return sf, nil
+}
+
+// typeCheck type-checks the given files as a single package,
+// and fills in their type information.
+func typeCheck(path string, files []*rewrite.File, imp types.Importer) error {
+	astFiles := make([]*ast.File, len(files))
+	for i, f := range files {
+		astFiles[i] = f.Syntax
+	}
+
+	var errs []error
+	cfg := types.Config{
+		Importer:    imp,
+		FakeImportC: true,
+		Error: func(err error) {
+			errs = append(errs, err)
+		},
+	}
+	info := &types.Info{
+		Types: make(map[ast.Expr]types.TypeAndValue),
+		Defs:  make(map[*ast.Ident]types.Object),
+		Uses:  make(map[*ast.Ident]types.Object),
+	}
+	pkg, _ := cfg.Check(path, files[0].Fset, astFiles, info)
+	switch len(errs) {
+	case 0:
+		// ok
+	case 1:
+		return errtrace.Wrap(errs[0])
+	default:
+		return errtrace.Wrap(fmt.Errorf("%w (and %d more errors)", errs[0], len(errs)-1))
+	}
+
+	for _, f := range files {
+		f.Pkg = pkg
+		f.Info = info
+	}
+	return nil
+}
+
+// _goListExport is goListExport, replaced in tests
+// to share 'go list' results between test cases.
+var _goListExport = goListExport
+
+// goListExport runs 'go list -export' for the given patterns
+// and all their dependencies.
+// If test is set, test variants of the matching packages are included.
+func goListExport(test bool, patterns []string) ([]*goListPackage, error) {
+	args := []string{"list", "-e", "-json", "-export", "-deps"}
+	if test {
+		args = append(args, "-test")
+	}
+	args = append(args, patterns...)
+
+	var stdout, stderr bytes.Buffer
+	cmd := _execCommand("go", args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, errtrace.Wrap(fmt.Errorf("go list: %w\n%s", err, stderr.String()))
+	}
+
+	var pkgs []*goListPackage
+	decoder := json.NewDecoder(&stdout)
+	for decoder.More() {
+		var pkg goListPackage
+		if err := decoder.Decode(&pkg); err != nil {
+			return nil, errtrace.Wrap(fmt.Errorf("go list: output malformed: %w", err))
+		}
+		pkgs = append(pkgs, &pkg)
+	}
+	return pkgs, nil
+}
+
+// files returns absolute paths to the Go files of the package.
+func (p *goListPackage) files() []string {
+	files := make([]string, 0, len(p.GoFiles)+len(p.CgoFiles))
+	for _, names := range [][]string{p.GoFiles, p.CgoFiles} {
+		for _, name := range names {
+			if !filepath.IsAbs(name) {
+				name = filepath.Join(p.Dir, name)
+			}
+			files = append(files, name)
+		}
+	}
+	return files
+}
+
+// exportImporter imports packages from the export data
+// reported by 'go list -export'.
+type exportImporter struct {
+	pkgs map[string]*goListPackage // by import path
+	imp  types.Importer
+}
+
+func newExportImporter(fset *token.FileSet, pkgs []*goListPackage) *exportImporter {
+	e := &exportImporter{pkgs: make(map[string]*goListPackage, len(pkgs))}
+	for _, pkg := range pkgs {
+		e.pkgs[pkg.ImportPath] = pkg
+	}
+	e.imp = importer.ForCompiler(fset, "gc", e.lookup)
+	return e
+}
+
+func (e *exportImporter) lookup(path string) (io.ReadCloser, error) {
+	pkg, ok := e.pkgs[path]
+	if !ok {
+		return nil, errtrace.Wrap(fmt.Errorf("package %q not found", path))
+	}
+	if pkg.Export == "" {
+		if pkg.Error != nil {
+			return nil, errtrace.Wrap(fmt.Errorf("package %q: %v", path, pkg.Error.Err))
+		}
+		return nil, errtrace.Wrap(fmt.Errorf("package %q: no export data", path))
+	}
+	return errtrace.Wrap2(os.Open(pkg.Export))
+}
+
+func (e *exportImporter) Import(path string) (*types.Package, error) {
+	return errtrace.Wrap2(e.imp.Import(path))
+}
+
+// forPackage returns an importer that resolves imports of the given package,
+// taking vendoring and test variants into account.
+func (e *exportImporter) forPackage(pkg *goListPackage) types.Importer {
+	return importerFunc(func(path string) (*types.Package, error) {
+		if mapped, ok := pkg.ImportMap[path]; ok {
+			path = mapped
+		}
+		return errtrace.Wrap2(e.imp.Import(path))
+	})
+}
+
+type importerFunc func(path string) (*types.Package, error)
+
+func (f importerFunc) Import(path string) (*types.Package, error) {
+	return errtrace.Wrap2(f(path))
+}
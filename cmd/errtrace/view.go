@@ -0,0 +1,405 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+type viewParams struct {
+	Context int       // -C
+	Color   colorMode // -color
+	File    string    // file to read, "-" for stdin
+}
+
+func (p *viewParams) Parse(w io.Writer, args []string) error {
+	flag := flag.NewFlagSet("errtrace view", flag.ContinueOnError)
+	flag.SetOutput(w)
+	flag.Usage = func() {
+		logln(w, "usage: errtrace view [options] [file]")
+		flag.PrintDefaults()
+	}
+
+	flag.IntVar(&p.Context, "C", 2,
+		"number of lines of source to show before and after each return site.")
+	flag.Var(&p.Color, "color", "whether to colorize output; one of: [auto, always, never].\n"+
+		"auto is the default and will colorize if the output is a terminal.")
+
+	if err := flag.Parse(args); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	switch flag.NArg() {
+	case 0:
+		p.File = "-"
+	case 1:
+		p.File = flag.Arg(0)
+	default:
+		return errtrace.Wrap(fmt.Errorf("too many arguments: %q", flag.Args()))
+	}
+	if p.Context < 0 {
+		return errtrace.Wrap(fmt.Errorf("-C must be non-negative: %d", p.Context))
+	}
+
+	return nil
+}
+
+// colorMode specifies whether the output should be colorized.
+type colorMode int
+
+const (
+	colorAuto colorMode = iota
+	colorAlways
+	colorNever
+)
+
+var _ flag.Value = (*colorMode)(nil)
+
+func (c *colorMode) Set(s string) error {
+	switch s {
+	case "auto":
+		*c = colorAuto
+	case "always":
+		*c = colorAlways
+	case "never":
+		*c = colorNever
+	default:
+		return errtrace.Wrap(fmt.Errorf("invalid color mode %q", s))
+	}
+	return nil
+}
+
+func (c *colorMode) String() string {
+	switch *c {
+	case colorAuto:
+		return "auto"
+	case colorAlways:
+		return "always"
+	case colorNever:
+		return "never"
+	default:
+		return fmt.Sprintf("colorMode(%d)", *c)
+	}
+}
+
+// runView runs the 'errtrace view' subcommand.
+//
+// It reads a trace printed by errtrace,
+// and prints it back with the source code around each return site.
+// Lines that are not part of a trace are printed unchanged,
+// so traces may be surrounded by other log output.
+func (cmd *mainCmd) runView(args []string) (exitCode int) {
+	var p viewParams
+	if err := p.Parse(cmd.Stderr, args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0
+		}
+		cmd.log.Printf("errtrace: %+v", err)
+		return 1
+	}
+
+	in := cmd.Stdin
+	if p.File != "-" {
+		f, err := os.Open(p.File)
+		if err != nil {
+			cmd.log.Printf("errtrace: %+v", err)
+			return 1
+		}
+		defer f.Close() //nolint:errcheck // read-only
+		in = f
+	} else if isTerminal(cmd.Stdin) {
+		cmd.log.Println("reading trace from stdin; use '-h' for help")
+	}
+
+	var color bool
+	switch p.Color {
+	case colorAlways:
+		color = true
+	case colorAuto:
+		color = isTerminal(cmd.Stdout) && cmd.Getenv("NO_COLOR") == ""
+	}
+
+	v := traceViewer{
+		Context: p.Context,
+		Color:   color,
+		Sources: newSourceResolver(),
+	}
+	if err := v.View(cmd.Stdout, in); err != nil {
+		cmd.log.Printf("errtrace: %+v", err)
+		return 1
+	}
+	return 0
+}
+
+// ANSI escape sequences used to colorize traces.
+const (
+	_ansiReset  = "\x1b[0m"
+	_ansiBold   = "\x1b[1m"
+	_ansiFaint  = "\x1b[2m"
+	_ansiYellow = "\x1b[33m"
+	_ansiCyan   = "\x1b[36m"
+)
+
+// _traceLocation matches the file:line line of a frame,
+// optionally preceded by tree drawing for multi-errors.
+//
+// Capture groups:
+//
+//  1. tree drawing prefix
+//  2. file path
+//  3. line number
+var _traceLocation = regexp.MustCompile(`^([ |]*)\t(.+):(\d+)$`)
+
+// traceViewer annotates traces with source code.
+type traceViewer struct {
+	Context int  // lines of context around the return site
+	Color   bool // whether to use ANSI colors
+
+	Sources *sourceResolver
+}
+
+// View copies r to w, adding source snippets after each frame.
+func (v *traceViewer) View(w io.Writer, r io.Reader) error {
+	bw := bufio.NewWriter(w)
+	scan := bufio.NewScanner(r)
+	scan.Buffer(nil, 1<<20) // allow long lines
+
+	var prev string // previous line, which holds the function name of a frame
+	for scan.Scan() {
+		line := strings.TrimSuffix(scan.Text(), "\r")
+
+		m := _traceLocation.FindStringSubmatch(line)
+		if m == nil {
+			v.writeLine(bw, "", line)
+			prev = line
+			continue
+		}
+
+		prefix, file := m[1], m[2]
+		lineNo, err := strconv.Atoi(m[3])
+		if err != nil {
+			return errtrace.Wrap(err)
+		}
+
+		function := strings.TrimPrefix(prev, prefix)
+		lines, ok := v.Sources.Lines(function, file)
+		if !ok {
+			v.writeLine(bw, "", line)
+			prev = line
+			continue
+		}
+
+		v.writeLine(bw, _ansiCyan, line)
+		v.writeSnippet(bw, prefix, lines, lineNo)
+		prev = line
+	}
+	if err := scan.Err(); err != nil {
+		return errtrace.Wrap(err)
+	}
+
+	return errtrace.Wrap(bw.Flush())
+}
+
+// writeSnippet writes the lines around lineNo (1-indexed)
+// with the given tree drawing prefix.
+func (v *traceViewer) writeSnippet(w *bufio.Writer, prefix string, lines []string, lineNo int) {
+	start := max(lineNo-v.Context, 1)
+	end := min(lineNo+v.Context, len(lines))
+	width := len(strconv.Itoa(end))
+
+	for n := start; n <= end; n++ {
+		marker, color := " ", _ansiFaint
+		if n == lineNo {
+			marker, color = ">", _ansiYellow+_ansiBold
+		}
+
+		_, _ = w.WriteString(prefix)
+		v.writeLine(w, color, fmt.Sprintf("\t%s %*d | %s", marker, width, n, lines[n-1]))
+	}
+}
+
+func (v *traceViewer) writeLine(w *bufio.Writer, color, line string) {
+	if v.Color && color != "" {
+		line = color + line + _ansiReset
+	}
+	_, _ = w.WriteString(line)
+	_ = w.WriteByte('\n')
+}
+
+// sourceResolver finds source files for frames in a trace.
+//
+// Traces are often produced on a different machine
+// (or cleaned up for tests, e.g. "/path/to/errtrace/foo.go"),
+// so file paths may not exist locally.
+// In that case, the file is looked up inside the current module
+// based on the package of the function,
+// or failing that, the longest suffix of the path that exists.
+type sourceResolver struct {
+	modDir  string // root directory of the current module, if any
+	modPath string // import path of the current module, if any
+
+	cache map[string][]string // file path in trace -> lines
+}
+
+func newSourceResolver() *sourceResolver {
+	r := sourceResolver{cache: make(map[string][]string)}
+	if wd, err := os.Getwd(); err == nil {
+		r.modDir, r.modPath = findModule(wd)
+	}
+	return &r
+}
+
+// Lines returns the lines of the source file for the given frame,
+// reporting false if the file could not be found.
+func (r *sourceResolver) Lines(function, file string) ([]string, bool) {
+	if lines, ok := r.cache[file]; ok {
+		return lines, lines != nil
+	}
+
+	var lines []string
+	if path, ok := r.resolve(function, file); ok {
+		if src, err := os.ReadFile(path); err == nil {
+			lines = strings.Split(strings.TrimSuffix(string(src), "\n"), "\n")
+			for i, line := range lines {
+				lines[i] = strings.TrimSuffix(line, "\r")
+			}
+		}
+	}
+
+	r.cache[file] = lines
+	return lines, lines != nil
+}
+
+func (r *sourceResolver) resolve(function, file string) (string, bool) {
+	if isFile(file) {
+		return file, true
+	}
+	if r.modDir == "" {
+		return "", false
+	}
+
+	// Function names are fully qualified with the import path,
+	// which tells us where the package lives inside the module.
+	if pkg := funcPackage(function); r.modPath != "" && pkg != "" {
+		if rel, ok := strings.CutPrefix(pkg, r.modPath); ok && (rel == "" || rel[0] == '/') {
+			path := filepath.Join(r.modDir, filepath.FromSlash(rel), filepath.Base(file))
+			if isFile(path) {
+				return path, true
+			}
+		}
+	}
+
+	// Otherwise, try the suffixes of the path from longest to shortest.
+	parts := strings.Split(filepath.ToSlash(file), "/")
+	for i := range parts {
+		path := filepath.Join(r.modDir, filepath.FromSlash(strings.Join(parts[i:], "/")))
+		if isFile(path) {
+			return path, true
+		}
+	}
+
+	return "", false
+}
+
+// funcPackage returns the import path of the package
+// that the given fully qualified function name belongs to.
+// For example, "example.com/foo.(*T).Bar" returns "example.com/foo".
+//
+// Like runtime.Frame.Function, the package is everything up to
+// the first '.' after the last '/'.
+// The runtime escapes dots in the last element of the import path as "%2e",
+// but names written by hand or by other tools may not,
+// so a dot followed by a major version (e.g. "gopkg.in/yaml.v3")
+// is part of the path too.
+func funcPackage(function string) string {
+	// Type arguments of generic functions may contain other import paths.
+	if i := strings.IndexByte(function, '['); i >= 0 {
+		function = function[:i]
+	}
+
+	lastSlash := strings.LastIndexByte(function, '/')
+	end := lastSlash + 1
+	for {
+		dot := strings.IndexByte(function[end:], '.')
+		if dot < 0 {
+			return ""
+		}
+		end += dot
+		if !isVersionElem(function[end+1:]) {
+			break
+		}
+		end++
+	}
+
+	pkg := strings.ReplaceAll(function[:end], "%2e", ".")
+	// External test packages live alongside the package they test.
+	return strings.TrimSuffix(pkg, "_test")
+}
+
+// isVersionElem reports whether s starts with a major version
+// that's followed by a '.', e.g. "v3.Unmarshal".
+func isVersionElem(s string) bool {
+	if len(s) < 3 || s[0] != 'v' {
+		return false
+	}
+	i := 1
+	for i < len(s) && '0' <= s[i] && s[i] <= '9' {
+		i++
+	}
+	return i > 1 && i < len(s) && s[i] == '.'
+}
+
+// findModule finds the Go module that contains dir,
+// returning its root directory and import path.
+// Empty strings are returned if dir is not inside a module.
+func findModule(dir string) (modDir, modPath string) {
+	for {
+		if src, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, modulePath(src)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", ""
+		}
+		dir = parent
+	}
+}
+
+var _moduleDirective = regexp.MustCompile(`(?m)^module\s+"?([^\s"]+)"?\s*$`)
+
+// modulePath returns the module path declared in a go.mod file.
+func modulePath(gomod []byte) string {
+	if m := _moduleDirective.FindSubmatch(gomod); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+func isFile(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.Mode().IsRegular()
+}
+
+// isTerminal reports whether v is a file attached to a terminal.
+// This is a best-effort check that looks for a character device.
+func isTerminal(v any) bool {
+	type statter interface {
+		Stat() (os.FileInfo, error)
+	}
+	st, ok := v.(statter)
+	if !ok {
+		return false
+	}
+	fi, err := st.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice == os.ModeCharDevice
+}
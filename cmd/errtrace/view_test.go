@@ -0,0 +1,248 @@
+//go:build !errtrace_off
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace"
+	"braces.dev/errtrace/internal/diff"
+)
+
+func TestTraceViewer(t *testing.T) {
+	modDir := t.TempDir()
+	writeFile := func(path, src string) {
+		path = filepath.Join(modDir, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(src), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeFile("go.mod", "module example.com/app\n")
+	writeFile("store/store.go", strings.Join([]string{
+		"package store",
+		"",
+		"func Get() error {",
+		"	return errtrace.Wrap(err)",
+		"}",
+		"",
+	}, "\n"))
+	writeFile("cmd/app/main.go", strings.Join([]string{
+		"package main",
+		"",
+		"func run() error {",
+		"	return store.Get()",
+		"}",
+		"",
+	}, "\n"))
+
+	tests := []struct {
+		name string
+		give []string
+		want []string
+	}{
+		{
+			name: "other machine",
+			give: []string{
+				"not found",
+				"",
+				"example.com/app/store.Get",
+				"	/home/someone/app/store/store.go:4",
+				"main.run",
+				"	/build/src/cmd/app/main.go:4",
+				"example.com/lib.Do",
+				"	/home/someone/lib/lib.go:12",
+			},
+			want: []string{
+				"not found",
+				"",
+				"example.com/app/store.Get",
+				"	/home/someone/app/store/store.go:4",
+				"	  3 | func Get() error {",
+				"	> 4 | 	return errtrace.Wrap(err)",
+				"	  5 | }",
+				"main.run",
+				"	/build/src/cmd/app/main.go:4",
+				"	  3 | func run() error {",
+				"	> 4 | 	return store.Get()",
+				"	  5 | }",
+				"example.com/lib.Do",
+				"	/home/someone/lib/lib.go:12",
+			},
+		},
+		{
+			name: "cleaned paths",
+			give: []string{
+				"not found",
+				"",
+				"example.com/app/store.Get",
+				"	/path/to/app/store/store.go:1",
+			},
+			want: []string{
+				"not found",
+				"",
+				"example.com/app/store.Get",
+				"	/path/to/app/store/store.go:1",
+				"	> 1 | package store",
+				"	  2 | ",
+			},
+		},
+		{
+			name: "tree",
+			give: []string{
+				"+- err a",
+				"|  ",
+				"|  example.com/app/store.Get",
+				"|  	/path/to/app/store/store.go:4",
+				"|  ",
+				"+- err b",
+				"|  ",
+				"err a",
+				"err b",
+			},
+			want: []string{
+				"+- err a",
+				"|  ",
+				"|  example.com/app/store.Get",
+				"|  	/path/to/app/store/store.go:4",
+				"|  	  3 | func Get() error {",
+				"|  	> 4 | 	return errtrace.Wrap(err)",
+				"|  	  5 | }",
+				"|  ",
+				"+- err b",
+				"|  ",
+				"err a",
+				"err b",
+			},
+		},
+		{
+			name: "line out of range",
+			give: []string{
+				"example.com/app/store.Get",
+				"	/path/to/app/store/store.go:100",
+			},
+			want: []string{
+				"example.com/app/store.Get",
+				"	/path/to/app/store/store.go:100",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := traceViewer{
+				Context: 1,
+				Sources: &sourceResolver{
+					modDir:  modDir,
+					modPath: "example.com/app",
+					cache:   make(map[string][]string),
+				},
+			}
+
+			var got bytes.Buffer
+			if err := v.View(&got, strings.NewReader(strings.Join(tt.give, "\n"))); err != nil {
+				t.Fatal(err)
+			}
+
+			want := strings.Join(tt.want, "\n") + "\n"
+			if got := got.String(); got != want {
+				t.Errorf("output mismatch:\n%s", diff.Lines(want, got))
+			}
+		})
+	}
+}
+
+func TestView(t *testing.T) {
+	err := errtrace.New("great sadness") // return site
+	trace := errtrace.FormatString(err)
+
+	tests := []struct {
+		name      string
+		args      []string
+		wantColor bool
+	}{
+		{name: "default"},
+		{name: "never", args: []string{"-color=never"}},
+		{name: "always", args: []string{"-color=always"}, wantColor: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			exitCode := (&mainCmd{
+				Stdin:  strings.NewReader(trace),
+				Stdout: &stdout,
+				Stderr: testWriter{t},
+			}).Run(append([]string{"view"}, tt.args...))
+			if want := 0; exitCode != want {
+				t.Errorf("exit code = %d, want %d", exitCode, want)
+			}
+
+			got := stdout.String()
+			if want := "errtrace.New(\"great sadness\") // return site"; !strings.Contains(got, want) {
+				t.Errorf("output does not contain %q:\n%s", want, got)
+			}
+			if gotColor := strings.Contains(got, "\x1b["); gotColor != tt.wantColor {
+				t.Errorf("colorized = %v, want %v:\n%s", gotColor, tt.wantColor, got)
+			}
+		})
+	}
+}
+
+func TestViewParams_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		give    []string
+		wantErr string
+	}{
+		{name: "bad color", give: []string{"-color=rainbow"}, wantErr: `invalid color mode "rainbow"`},
+		{name: "negative context", give: []string{"-C=-1"}, wantErr: "-C must be non-negative"},
+		{name: "too many files", give: []string{"a.log", "b.log"}, wantErr: "too many arguments"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var p viewParams
+			err := p.Parse(testWriter{t}, tt.give)
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			if got := err.Error(); !strings.Contains(got, tt.wantErr) {
+				t.Errorf("error %q does not contain %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFuncPackage(t *testing.T) {
+	tests := []struct {
+		give string
+		want string
+	}{
+		{"main.main", "main"},
+		{"example.com/foo.Bar", "example.com/foo"},
+		{"example.com/foo.(*T).Bar.func1", "example.com/foo"},
+		{"example.com/foo_test.TestBar", "example.com/foo"},
+		{"example.com/foo.Map[...]", "example.com/foo"},
+		{"example.com/foo.Map[example.com/bar.T]", "example.com/foo"},
+		{"gopkg.in/yaml%2ev3.(*Decoder).Decode", "gopkg.in/yaml.v3"},
+		{"gopkg.in/yaml.v3.(*Decoder).Decode", "gopkg.in/yaml.v3"},
+		{"gopkg.in/yaml.v3.Unmarshal.func1", "gopkg.in/yaml.v3"},
+		{"example.com/foo.v2", "example.com/foo"},
+		{"example.com/foo.vet.Bar", "example.com/foo"},
+		{"noPackage", ""},
+	}
+
+	for _, tt := range tests {
+		if got := funcPackage(tt.give); got != tt.want {
+			t.Errorf("funcPackage(%q) = %q, want %q", tt.give, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,31 @@
+package errtrace
+
+import "sync/atomic"
+
+// _disabled is set when tracing is turned off with SetEnabled(false).
+// It's inverted so that the zero value means tracing is enabled.
+var _disabled atomic.Bool
+
+// SetEnabled turns tracing on or off for the whole program.
+// Tracing is enabled by default.
+//
+// While tracing is disabled, [Wrap], [New], [Errorf], and friends
+// do not add return trace information to errors:
+// Wrap returns its input unchanged,
+// and New and Errorf behave like [errors.New] and [fmt.Errorf].
+// Errors that were wrapped before tracing was disabled keep their traces.
+//
+// This is intended for gradual rollout of errtrace.
+// To remove errtrace's overhead entirely,
+// build with the errtrace_off build tag instead.
+// SetEnabled(true) has no effect in such builds.
+func SetEnabled(enabled bool) {
+	_disabled.Store(!enabled)
+}
+
+// Enabled reports whether errors are currently being traced.
+// It reports false if tracing was disabled with [SetEnabled],
+// or if the program was built with the errtrace_off build tag.
+func Enabled() bool {
+	return _tracingBuilt && !_disabled.Load()
+}
@@ -0,0 +1,55 @@
+package errtrace_test
+
+import (
+	"errors"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+func TestSetEnabled(t *testing.T) {
+	builtEnabled := errtrace.Enabled()
+	traced := errtrace.New("traced before disabling")
+
+	errtrace.SetEnabled(false)
+	t.Cleanup(func() { errtrace.SetEnabled(true) })
+
+	if errtrace.Enabled() {
+		t.Errorf("Enabled() = true after SetEnabled(false)")
+	}
+
+	orig := errors.New("great sadness")
+	if got := errtrace.Wrap(orig); got != orig {
+		t.Errorf("Wrap() = %#v, want unchanged %#v", got, orig)
+	}
+	if _, got := errtrace.Wrap2(42, orig); got != orig {
+		t.Errorf("Wrap2() = %#v, want unchanged %#v", got, orig)
+	}
+	if got := errtrace.GetCaller().Wrap(orig); got != orig {
+		t.Errorf("Caller.Wrap() = %#v, want unchanged %#v", got, orig)
+	}
+
+	newErr := errtrace.New("great sadness")
+	errorfErr := errtrace.Errorf("wrapped: %w", orig)
+	for _, err := range []error{newErr, errorfErr} {
+		if _, _, ok := errtrace.UnwrapFrame(err); ok {
+			t.Errorf("error %q has a trace, want none", err)
+		}
+	}
+	if !errors.Is(errorfErr, orig) {
+		t.Errorf("Errorf() lost the wrapped error")
+	}
+
+	// Errors traced before tracing was disabled keep their traces.
+	if _, _, ok := errtrace.UnwrapFrame(traced); ok != builtEnabled {
+		t.Errorf("UnwrapFrame(traced) = %v, want %v", ok, builtEnabled)
+	}
+
+	errtrace.SetEnabled(true)
+	if got := errtrace.Enabled(); got != builtEnabled {
+		t.Errorf("Enabled() = %v after SetEnabled(true), want %v", got, builtEnabled)
+	}
+	if _, _, ok := errtrace.UnwrapFrame(errtrace.Wrap(orig)); ok != builtEnabled {
+		t.Errorf("Wrap() traced = %v after SetEnabled(true), want %v", ok, builtEnabled)
+	}
+}
@@ -48,6 +48,14 @@
 //
 // See the [UnwrapFrame] example test for a more complete example.
 //
+// # Parsing return traces
+//
+// Use the [ParseTrace] function to turn a trace printed by [Format]
+// back into a structured [TraceTree].
+// This is useful for tools that analyze traces found in logs.
+//
+//	tree, err := errtrace.ParseTrace(strings.NewReader(logged))
+//
 // # See also
 //
 // https://github.com/bracesdev/errtrace.
@@ -57,15 +65,83 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+	"math"
 	"strings"
 )
 
-var _arena = newArena[errTrace](1024)
+// _maxTraceDepth is the maximum number of errTrace layers
+// that wrap nests directly inside each other.
+// Once an error has this many, further wraps return it unchanged
+// so that traces built in long-running loops stop growing.
+// Format marks the last frame of such traces as truncated.
+const _maxTraceDepth = 1000
 
+// _arena allocates errTraces in small batches
+// so that most calls to wrap don't allocate.
+var _arena arena[errTrace]
+
+// wrap attaches callerPC to err,
+// or returns err unchanged if tracing was disabled with [SetEnabled].
+// If callerPC is inside a function marked with [Helper],
+// the error is attributed to the helper's caller instead.
+//
+// If err was last wrapped at the same callerPC
+// (e.g. in a retry loop, or by a recursive function),
+// the two are collapsed into a single layer with a higher count
+// so that traces don't grow with each iteration.
+// The collapsed layer keeps the first of these layers
+// so that errors.Is matches all of them (see errTrace.Is).
+//
+// errTraces are allocated from _arena in small batches.
+// A retained error keeps its batch alive, about 500 bytes.
+//
+// wrap is not inlined so that error helpers built on [GetCaller]
+// aren't inlined into their callers either,
+// which would make the asm GetCaller skip the wrong frame.
+//
+//go:noinline
 func wrap(err error, callerPC uintptr) error {
-	et := _arena.Take()
-	et.err = err
-	et.pc = callerPC
+	if _disabled.Load() {
+		return err
+	}
+
+	if helpers := _helpers.Load(); helpers != nil {
+		helper, ok := helpers.cachedHelper(callerPC)
+		if !ok {
+			helper = helpers.lookupHelper(callerPC)
+		}
+		if helper != "" {
+			if frame, ok := helpers.helperCaller(helper); ok {
+				return &frameTrace{err: err, frame: frame}
+			}
+		}
+	}
+
+	depth := int32(1)
+	if inner, ok := err.(*errTrace); ok {
+		if inner.pc == callerPC {
+			first := inner
+			if inner.count > 1 {
+				first = inner.err.(*errTrace)
+			}
+			et := _arena.Take(callerPC)
+			*et = errTrace{
+				err:   first,
+				pc:    callerPC,
+				count: min(inner.count, math.MaxInt32-1) + 1,
+				depth: first.depth,
+			}
+			return et
+		}
+
+		if inner.depth >= _maxTraceDepth {
+			return err
+		}
+		depth = inner.depth + 1
+	}
+
+	et := _arena.Take(callerPC)
+	*et = errTrace{err: err, pc: callerPC, count: 1, depth: depth}
 	return et
 }
 
@@ -80,21 +156,43 @@ func wrap(err error, callerPC uintptr) error {
 //		<file>:<line>
 //	[...]
 //
-// Any error that has a method `TracePC() uintptr` will
-// contribute to the trace.
+// Any error that has a method `TracePC() uintptr`
+// or `TraceFrame() runtime.Frame` will contribute to the trace.
 // If the error doesn't have a return trace attached to it,
 // only the error message is reported.
 // If the error is comprised of multiple errors (e.g. with [errors.Join]),
 // the return trace of each error is reported as a tree.
 //
+// If the error was returned through the same location
+// multiple times in a row (e.g. in a retry loop or a recursive function),
+// that frame is reported once, followed by the number of times:
+//
+//	<function> (returned N times)
+//		<file>:<line>
+//
+// If the compiler inlined the function that returned the error
+// into its caller, the function is marked as inlined,
+// and followed by the function it was inlined into:
+//
+//	<function> (inlined)
+//		<file>:<line>
+//	<function it was inlined into>
+//		<file>:<line>
+//
+// If [Wrap] stopped tracing the error because its trace was too long,
+// the last frame it traced is marked as truncated:
+//
+//	<function> (trace truncated)
+//		<file>:<line>
+//
 // Returns an error if the writer fails.
 func Format(w io.Writer, target error) (err error) {
 	return writeTree(w, buildTraceTree(target))
 }
 
 // FormatString writes the return trace for err to a string.
-// Any error that has a method `TracePC() uintptr` will
-// contribute to the trace.
+// Any error that has a method `TracePC() uintptr`
+// or `TraceFrame() runtime.Frame` will contribute to the trace.
 // See [Format] for details of the output format.
 func FormatString(target error) string {
 	var s strings.Builder
@@ -103,8 +201,19 @@ func FormatString(target error) string {
 }
 
 type errTrace struct {
+	// err is the wrapped error.
+	// If count > 1, it's instead the first errTrace for pc
+	// that this one was collapsed from, and Unwrap skips it.
 	err error
 	pc  uintptr
+
+	// count is the number of consecutive times
+	// the error was returned through pc.
+	count int32
+
+	// depth is the number of errTrace layers
+	// nested directly inside each other, including this one.
+	depth int32
 }
 
 func (e *errTrace) Error() string {
@@ -112,9 +221,29 @@ func (e *errTrace) Error() string {
 }
 
 func (e *errTrace) Unwrap() error {
+	if e.count > 1 {
+		return e.err.(*errTrace).err
+	}
 	return e.err
 }
 
+// Is reports whether target is one of the errors
+// that were collapsed into e by wrapping them at the same location again:
+// the first of them, or a collapsed error built on the same one
+// that was returned through the location fewer times.
+func (e *errTrace) Is(target error) bool {
+	t, ok := target.(*errTrace)
+	if !ok || e.count <= 1 {
+		return false
+	}
+
+	first := e.err.(*errTrace)
+	if t == first {
+		return true
+	}
+	return t.count > 1 && t.count < e.count && t.err == error(first)
+}
+
 func (e *errTrace) Format(s fmt.State, verb rune) {
 	if verb == 'v' && s.Flag('+') {
 		_ = Format(s, e)
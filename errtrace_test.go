@@ -1,3 +1,5 @@
+//go:build !errtrace_off
+
 package errtrace_test
 
 import (
@@ -53,6 +55,110 @@ func TestWrappedErrorAs(t *testing.T) {
 	}
 }
 
+// wrapRetry wraps err at the same site every time it's called.
+//
+//go:noinline
+func wrapRetry(err error) error {
+	return errtrace.Wrap(err)
+}
+
+func TestWrap_retryLoop(t *testing.T) {
+	orig := &myError{x: 42}
+	var err error = orig
+	var intermediate []error
+	for i := 0; i < 100; i++ {
+		err = wrapRetry(err)
+		intermediate = append(intermediate, err)
+	}
+
+	// Repeated wraps at the same site don't add layers.
+	_, inner, ok := errtrace.UnwrapFrame(err)
+	if !ok {
+		t.Fatalf("UnwrapFrame(): want ok, got false")
+	}
+	if inner != error(orig) {
+		t.Errorf("UnwrapFrame(): want inner %v, got %#v", orig, inner)
+	}
+
+	if !errors.Is(err, orig) {
+		t.Errorf("Is(): want true, got false")
+	}
+	var m *myError
+	if !errors.As(err, &m) || m != orig {
+		t.Errorf("As(): want %v, got %v", orig, m)
+	}
+
+	// Errors from earlier iterations are still found,
+	// but not the other way around.
+	for i, e := range intermediate {
+		if !errors.Is(err, e) {
+			t.Errorf("Is(err, intermediate[%d]): want true, got false", i)
+		}
+		if i < len(intermediate)-1 && errors.Is(e, err) {
+			t.Errorf("Is(intermediate[%d], err): want false, got true", i)
+		}
+	}
+
+	// Errors collapsed at the same site from a different error don't match.
+	other := errors.New("other")
+	for i := 0; i < 3; i++ {
+		other = wrapRetry(other)
+		if errors.Is(err, other) || errors.Is(other, err) {
+			t.Errorf("Is(): unrelated errors match after %d wraps", i+1)
+		}
+	}
+
+	trace := errtrace.FormatString(err)
+	if want := ".wrapRetry (returned 100 times)\n"; !strings.Contains(trace, want) {
+		t.Errorf("FormatString(): want trace to contain %q, got:\n%s", want, trace)
+	}
+}
+
+func TestWrap_maxDepth(t *testing.T) {
+	const maxDepth = 1000 // must match _maxTraceDepth
+
+	orig := errors.New("great sadness")
+	err := orig
+	for i := 0; i < 2*maxDepth; i++ {
+		// Alternate between two sites so that frames aren't collapsed.
+		if i%2 == 0 {
+			err = errtrace.Wrap(err)
+		} else {
+			err = errtrace.Wrap(err)
+		}
+	}
+
+	var frames int
+	for inner := err; ; frames++ {
+		_, next, ok := errtrace.UnwrapFrame(inner)
+		if !ok {
+			break
+		}
+		inner = next
+	}
+	if frames != maxDepth {
+		t.Errorf("got %d frames, want %d", frames, maxDepth)
+	}
+
+	if !errors.Is(err, orig) {
+		t.Errorf("Is(): want true, got false")
+	}
+
+	// The last traced frame is marked as truncated.
+	tree, parseErr := errtrace.ParseTrace(strings.NewReader(errtrace.FormatString(err)))
+	if parseErr != nil {
+		t.Fatalf("ParseTrace(): %v", parseErr)
+	}
+	if len(tree.Trace) != maxDepth || len(tree.Truncated) != maxDepth {
+		t.Fatalf("got %d frames and %d truncated flags, want %d", len(tree.Trace), len(tree.Truncated), maxDepth)
+	}
+	for i, truncated := range tree.Truncated {
+		if want := i == maxDepth-1; truncated != want {
+			t.Errorf("Truncated[%d] = %v, want %v", i, truncated, want)
+		}
+	}
+}
+
 func TestFormatTrace(t *testing.T) {
 	orig := errors.New("foo")
 
@@ -93,10 +199,15 @@ func TestFormatTrace(t *testing.T) {
 		t.Errorf("FormatString(): want trace to contain %q, got:\n%s", gName, trace)
 	}
 
-	hName := funcName(h) + "\n"
-	if want, got := 4, strings.Count(trace, hName); want != got {
+	// The recursive calls return through the same line,
+	// so they're collapsed into a single frame.
+	hName := funcName(h)
+	if want, got := 2, strings.Count(trace, hName); want != got {
 		t.Errorf("FormatString(): want trace to contain %d instances of %q, got %d\n%s", want, hName, got, trace)
 	}
+	if want := hName + " (returned 3 times)\n"; !strings.Contains(trace, want) {
+		t.Errorf("FormatString(): want trace to contain %q, got:\n%s", want, trace)
+	}
 }
 
 func TestFormatVerbs(t *testing.T) {
@@ -191,6 +302,76 @@ func TestFormat(t *testing.T) {
 	}
 }
 
+// TestWrap_retainedMemory verifies that holding onto an error
+// keeps far less memory alive than the 1024-element slabs
+// that errors used to be allocated from.
+func TestWrap_retainedMemory(t *testing.T) {
+	orig := errors.New("great sadness")
+	got := retainedPerValue(func() any {
+		return errtrace.Wrap(orig)
+	})
+
+	// Old design: errors taken in order from 1024-element slabs
+	// of values the size of an errTrace.
+	type errTrace struct {
+		err          error
+		pc           uintptr
+		count, depth int32
+	}
+	var (
+		slab []errTrace
+		idx  int
+	)
+	old := retainedPerValue(func() any {
+		if idx == len(slab) {
+			slab, idx = make([]errTrace, 1024), 0
+		}
+		idx++
+		return &slab[idx-1]
+	})
+
+	t.Logf("retained %d bytes per error, %d bytes with 1024-element slabs", got, old)
+	if got*16 > old {
+		t.Errorf("each retained error keeps %d bytes alive, want at most 1/16 of %d", got, old)
+	}
+}
+
+// retainedPerValue reports the number of bytes of heap memory
+// that remain alive for each value returned by newValue that's retained,
+// when many other values are created and dropped in between.
+func retainedPerValue(newValue func() any) uint64 {
+	const (
+		numKept  = 100  // values retained until the end
+		numTemps = 2000 // short-lived values between each retained value
+	)
+
+	measure := func() uint64 {
+		// Two cycles to also clear any sync.Pool victim caches.
+		runtime.GC()
+		runtime.GC()
+
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		return ms.HeapAlloc
+	}
+
+	kept := make([]any, 0, numKept)
+	before := measure()
+	for i := 0; i < numKept; i++ {
+		for j := 0; j < numTemps; j++ {
+			_ = newValue()
+		}
+		kept = append(kept, newValue())
+	}
+	after := measure()
+	runtime.KeepAlive(kept)
+
+	if after <= before {
+		return 0
+	}
+	return (after - before) / numKept
+}
+
 func BenchmarkWrap(b *testing.B) {
 	err := errors.New("foo")
 	b.RunParallel(func(pb *testing.PB) {
@@ -200,6 +381,54 @@ func BenchmarkWrap(b *testing.B) {
 	})
 }
 
+// BenchmarkWrap_helper wraps errors inside a registered helper,
+// which attributes them to the helper's caller.
+// BenchmarkWrap also runs with helpers registered (see helper_test.go),
+// so it measures the check for wraps outside helpers.
+func BenchmarkWrap_helper(b *testing.B) {
+	err := errors.New("foo")
+	h := new(helperType)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			_ = h.wrap(err)
+		}
+	})
+}
+
+func BenchmarkFormat(b *testing.B) {
+	for _, n := range []int{10, 50, 200} {
+		b.Run(fmt.Sprintf("frames=%d", n), func(b *testing.B) {
+			err := wrapTimes(errors.New("foo"), n)
+			b.ReportAllocs()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					_ = errtrace.FormatString(err)
+				}
+			})
+		})
+	}
+}
+
+// wrapTimes wraps err n times, cycling through a few call sites
+// so that the trace has more than one distinct frame.
+func wrapTimes(err error, n int) error {
+	for i := 0; i < n; i++ {
+		switch i % 5 {
+		case 0:
+			err = errtrace.Wrap(err)
+		case 1:
+			err = errtrace.Wrap(err)
+		case 2:
+			err = errtrace.Wrap(err)
+		case 3:
+			err = errtrace.Wrap(err)
+		default:
+			err = errtrace.Wrap(err)
+		}
+	}
+	return err
+}
+
 func BenchmarkFmtErrorf(b *testing.B) {
 	err := errors.New("foo")
 	b.RunParallel(func(pb *testing.PB) {
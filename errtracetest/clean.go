@@ -0,0 +1,152 @@
+package errtracetest
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Clean makes a trace deterministic for tests.
+// It normalizes paths and line numbers of files
+// inside the module that contains the current working directory
+// (the package being tested when run with 'go test').
+//
+// The module's root directory is replaced with /path/to/<name>,
+// where <name> is the last element of the module path
+// (ignoring major version suffixes).
+// For example, in module example.com/foo/v2,
+// /home/user/src/foo/bar/bar.go becomes /path/to/foo/bar/bar.go.
+// Line numbers are rewritten and inlining markers are removed
+// as described in [CleanDir].
+//
+// If the working directory is not inside a module,
+// the working directory itself is used as the root.
+func Clean(trace string) string {
+	dir, name := currentModule()
+	if dir == "" {
+		return trace
+	}
+	return CleanDir(trace, dir, "/path/to/"+name)
+}
+
+// CleanDir makes a trace deterministic for tests by:
+//
+//   - replacing the directory dir with placeholder in file paths
+//   - replacing line numbers of files inside dir
+//     with the lowest values that maintain relative ordering within the file
+//   - removing "(inlined)" markers from functions
+//
+// Line numbers are replaced with increasing values starting at 1,
+// with earlier positions in the file getting lower numbers.
+// This keeps traces stable when unrelated lines are added or removed.
+//
+// Whether a function is inlined depends on the compiler and its flags
+// (e.g. -gcflags=-l), so the markers are removed to keep traces stable.
+// Frames of the functions that inlined calls were made from are kept,
+// so mark functions with '//go:noinline' if a trace must not include them.
+func CleanDir(trace, dir, placeholder string) string {
+	trace = strings.ReplaceAll(trace, " (inlined)", "")
+
+	// Frames always use forward slashes, even on Windows.
+	dir = strings.TrimSuffix(filepath.ToSlash(dir), "/")
+	placeholder = strings.TrimSuffix(placeholder, "/")
+	trace = strings.ReplaceAll(trace, dir+"/", placeholder+"/")
+
+	// Captures the file path and line number.
+	fileLine := regexp.MustCompile("(" + regexp.QuoteMeta(placeholder+"/") + `[^:\n]+):(\d+)`)
+
+	replacer := make(fileLineReplacer)
+	for _, m := range fileLine.FindAllStringSubmatch(trace, -1) {
+		file := m[1]
+		line, err := strconv.Atoi(m[2])
+		if err != nil {
+			// Only possible if the number overflows an int.
+			continue
+		}
+		replacer.Add(file, line)
+	}
+
+	return fileLine.ReplaceAllStringFunc(trace, func(s string) string {
+		m := fileLine.FindStringSubmatch(s)
+		line, err := strconv.Atoi(m[2])
+		if err != nil {
+			return s
+		}
+		return fmt.Sprintf("%v:%v", m[1], replacer.Replace(m[1], line))
+	})
+}
+
+// fileLineReplacer maintains a mapping from
+// file name to line numbers in that file that are referenced.
+type fileLineReplacer map[string][]int
+
+// Add adds a file:line pair to the replacer.
+func (r fileLineReplacer) Add(file string, line int) {
+	lines := r[file]
+	idx, found := slices.BinarySearch(lines, line)
+	if !found {
+		r[file] = slices.Insert(lines, idx, line)
+	}
+}
+
+// Replace returns the replacement line number for file:line,
+// which must have been added to the replacer.
+// The replacement is the position of the line
+// among all lines referenced in the file, starting at 1.
+func (r fileLineReplacer) Replace(file string, line int) int {
+	idx, _ := slices.BinarySearch(r[file], line)
+	return idx + 1
+}
+
+// currentModule finds the module containing the working directory.
+// It returns the module's root directory,
+// and the name to use for it in cleaned traces.
+func currentModule() (dir, name string) {
+	wd, err := os.Getwd()
+	if err != nil {
+		return "", ""
+	}
+
+	for dir := wd; ; {
+		if src, err := os.ReadFile(filepath.Join(dir, "go.mod")); err == nil {
+			if modPath := modulePath(src); modPath != "" {
+				return dir, moduleName(modPath)
+			}
+			return dir, filepath.Base(dir)
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return wd, filepath.Base(wd)
+		}
+		dir = parent
+	}
+}
+
+var (
+	_moduleDirective = regexp.MustCompile(`(?m)^module\s+"?([^\s"]+)"?\s*$`)
+	_majorVersion    = regexp.MustCompile(`^v[0-9]+$`)
+)
+
+// modulePath returns the module path declared in a go.mod file.
+func modulePath(gomod []byte) string {
+	if m := _moduleDirective.FindSubmatch(gomod); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// moduleName returns the last element of a module path,
+// skipping major version suffixes like "/v2".
+func moduleName(modPath string) string {
+	name := path.Base(modPath)
+	if dir := path.Dir(modPath); dir != "." && _majorVersion.MatchString(name) {
+		name = path.Base(dir)
+	}
+	return name
+}
@@ -0,0 +1,125 @@
+package errtracetest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace"
+	"braces.dev/errtrace/internal/diff"
+)
+
+func TestCleanDir(t *testing.T) {
+	give := strings.Join([]string{
+		"great sadness",
+		"",
+		"example.com/foo.a",
+		"	/home/user/foo/a.go:120",
+		"example.com/foo.b",
+		"	/home/user/foo/b.go:5",
+		"example.com/foo.a",
+		"	/home/user/foo/a.go:12",
+		"example.com/foo.a",
+		"	/home/user/foo/a.go:120",
+		"example.com/bar.c",
+		"	/home/user/bar/c.go:42",
+	}, "\n")
+
+	want := strings.Join([]string{
+		"great sadness",
+		"",
+		"example.com/foo.a",
+		"	/path/to/foo/a.go:2",
+		"example.com/foo.b",
+		"	/path/to/foo/b.go:1",
+		"example.com/foo.a",
+		"	/path/to/foo/a.go:1",
+		"example.com/foo.a",
+		"	/path/to/foo/a.go:2",
+		"example.com/bar.c",
+		"	/home/user/bar/c.go:42",
+	}, "\n")
+
+	if got := CleanDir(give, "/home/user/foo/", "/path/to/foo"); got != want {
+		t.Errorf("CleanDir():\n%s", diff.Lines(want, got))
+	}
+}
+
+func TestCleanDir_inlined(t *testing.T) {
+	give := strings.Join([]string{
+		"great sadness",
+		"",
+		"example.com/foo.a (inlined) (returned 2 times)",
+		"	/home/user/foo/a.go:12",
+		"example.com/foo.b",
+		"	/home/user/foo/a.go:5",
+	}, "\n")
+
+	want := strings.Join([]string{
+		"great sadness",
+		"",
+		"example.com/foo.a (returned 2 times)",
+		"	/path/to/foo/a.go:2",
+		"example.com/foo.b",
+		"	/path/to/foo/a.go:1",
+	}, "\n")
+
+	if got := CleanDir(give, "/home/user/foo", "/path/to/foo"); got != want {
+		t.Errorf("CleanDir() mismatch:\n%s", diff.Lines(want, got))
+	}
+}
+
+func TestClean(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tests run inside errtracetest/ in the errtrace module.
+	modDir := filepath.ToSlash(filepath.Dir(wd))
+
+	traced := errors.New("great sadness")
+	for _, line := range []int{30, 10, 20} {
+		traced = errtrace.WrapFrame(traced, runtime.Frame{
+			Function: "braces.dev/errtrace/errtracetest.f",
+			File:     modDir + "/errtracetest/f.go",
+			Line:     line,
+		})
+	}
+
+	want := strings.Join([]string{
+		"great sadness",
+		"",
+		"braces.dev/errtrace/errtracetest.f",
+		"	/path/to/errtrace/errtracetest/f.go:3",
+		"braces.dev/errtrace/errtracetest.f",
+		"	/path/to/errtrace/errtracetest/f.go:1",
+		"braces.dev/errtrace/errtracetest.f",
+		"	/path/to/errtrace/errtracetest/f.go:2",
+		"",
+	}, "\n")
+	if got := Clean(errtrace.FormatString(traced)); got != want {
+		t.Errorf("Clean():\n%s", diff.Lines(want, got))
+	}
+}
+
+func TestModuleName(t *testing.T) {
+	tests := []struct {
+		give string
+		want string
+	}{
+		{"braces.dev/errtrace", "errtrace"},
+		{"example.com/foo/v2", "foo"},
+		{"example.com/foo/bar", "bar"},
+		{"v2", "v2"},
+		{"foo", "foo"},
+	}
+
+	for _, tt := range tests {
+		if got := moduleName(tt.give); got != tt.want {
+			t.Errorf("moduleName(%q) = %q, want %q", tt.give, got, tt.want)
+		}
+	}
+}
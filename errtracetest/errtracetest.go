@@ -0,0 +1,81 @@
+// Package errtracetest provides helpers to test errors traced by errtrace.
+//
+// Use [Clean] to make traces deterministic
+// so they can be compared against expected output,
+// [RequireFrames] to check the functions an error was returned through,
+// and [Golden] to compare traces against golden files.
+// Set ERRTRACETEST_UPDATE=1 to update the golden files.
+package errtracetest
+
+import (
+	"errors"
+	"runtime"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+// RequireFrames verifies that err was returned through the given functions,
+// and fails the test immediately if it wasn't.
+//
+// Functions are listed in the order they're printed by [errtrace.Format]:
+// the deepest call first.
+// A function matches a frame if it is the frame's fully qualified name
+// (e.g. "example.com/foo/bar.(*Client).Get"),
+// or a suffix of it starting after a "/" (e.g. "bar.(*Client).Get").
+//
+// Only the trace of err itself is checked.
+// If err is a multi-error (e.g. from [errors.Join]),
+// the traces of its errors are not considered.
+// Consecutive returns through the same location are a single frame.
+// Frames for the functions that inlined calls were made from
+// are not considered, so results don't depend on inlining decisions.
+func RequireFrames(t testing.TB, err error, funcs ...string) {
+	t.Helper()
+
+	if err == nil {
+		t.Fatalf("expected an error with frames %q, got nil", funcs)
+	}
+
+	var frames []runtime.Frame // shallowest call first
+	for cur := err; cur != nil; {
+		frame, inner, ok := errtrace.UnwrapFrame(cur)
+		if !ok {
+			if _, ok := cur.(interface{ Unwrap() []error }); ok {
+				break // multi-error
+			}
+			cur = errors.Unwrap(cur)
+			continue
+		}
+
+		if n := len(frames); n == 0 || !sameLocation(frames[n-1], frame) {
+			frames = append(frames, frame)
+		}
+		cur = inner
+	}
+
+	// Frames are listed in the order printed by Format.
+	got := make([]string, len(frames))
+	for i, f := range frames {
+		got[len(frames)-1-i] = f.Function
+	}
+
+	ok := len(got) == len(funcs)
+	for i := 0; ok && i < len(got); i++ {
+		ok = matchFunction(got[i], funcs[i])
+	}
+	if !ok {
+		t.Fatalf("frames mismatch:\nwant: %q\ngot:  %q\ntrace:\n%s", funcs, got, errtrace.FormatString(err))
+	}
+}
+
+func sameLocation(a, b runtime.Frame) bool {
+	return a.Function == b.Function && a.File == b.File && a.Line == b.Line
+}
+
+// matchFunction reports whether the fully qualified function name fn
+// matches the name want given to RequireFrames.
+func matchFunction(fn, want string) bool {
+	return fn == want || strings.HasSuffix(fn, "/"+want)
+}
@@ -0,0 +1,144 @@
+package errtracetest
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+// fakeT records failures instead of failing the test.
+type fakeT struct {
+	testing.TB
+
+	failed bool
+	msg    string
+}
+
+func (t *fakeT) Helper() {}
+
+func (t *fakeT) Errorf(format string, args ...any) {
+	t.failed = true
+	t.msg += fmt.Sprintf(format, args...)
+}
+
+func (t *fakeT) Fatalf(format string, args ...any) {
+	t.Errorf(format, args...)
+	runtime.Goexit()
+}
+
+// runFake runs fn with a fakeT,
+// allowing it to stop early with Fatalf.
+func runFake(fn func(testing.TB)) *fakeT {
+	t := new(fakeT)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		fn(t)
+	}()
+	<-done
+	return t
+}
+
+func frameErr() error {
+	err := errors.New("great sadness")
+	for _, fn := range []string{"example.com/foo/bar.get", "example.com/foo/bar.(*Client).Get", "main.main"} {
+		err = errtrace.WrapFrame(err, runtime.Frame{Function: fn, File: "foo.go", Line: 1})
+	}
+	return err
+}
+
+func TestRequireFrames(t *testing.T) {
+	tests := []struct {
+		name    string
+		give    error
+		funcs   []string
+		wantErr string // empty if the assertion should pass
+	}{
+		{
+			name:  "full names",
+			give:  frameErr(),
+			funcs: []string{"example.com/foo/bar.get", "example.com/foo/bar.(*Client).Get", "main.main"},
+		},
+		{
+			name:  "package suffix",
+			give:  frameErr(),
+			funcs: []string{"bar.get", "foo/bar.(*Client).Get", "main.main"},
+		},
+		{
+			name:    "partial package name",
+			give:    frameErr(),
+			funcs:   []string{"ar.get", "bar.(*Client).Get", "main.main"},
+			wantErr: "frames mismatch",
+		},
+		{
+			name:    "wrong order",
+			give:    frameErr(),
+			funcs:   []string{"main.main", "bar.(*Client).Get", "bar.get"},
+			wantErr: "frames mismatch",
+		},
+		{
+			name:    "missing frame",
+			give:    frameErr(),
+			funcs:   []string{"bar.get", "main.main"},
+			wantErr: "frames mismatch",
+		},
+		{
+			name:    "no trace",
+			give:    errors.New("great sadness"),
+			funcs:   []string{"main.main"},
+			wantErr: "frames mismatch",
+		},
+		{
+			name:    "nil error",
+			funcs:   []string{"main.main"},
+			wantErr: "got nil",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ft := runFake(func(t testing.TB) {
+				RequireFrames(t, tt.give, tt.funcs...)
+			})
+
+			if tt.wantErr == "" {
+				if ft.failed {
+					t.Errorf("unexpected failure: %v", ft.msg)
+				}
+				return
+			}
+
+			if !ft.failed {
+				t.Fatalf("expected failure")
+			}
+			if !strings.Contains(ft.msg, tt.wantErr) {
+				t.Errorf("failure %q does not contain %q", ft.msg, tt.wantErr)
+			}
+		})
+	}
+}
+
+// inlinedNew is small enough to be inlined into its callers
+// unless inlining is disabled with -gcflags=-l.
+func inlinedNew() error {
+	return errtrace.New("great sadness")
+}
+
+//go:noinline
+func callInlinedNew() error {
+	return inlinedNew()
+}
+
+func TestRequireFrames_inlined(t *testing.T) {
+	if !errtrace.Enabled() {
+		t.Skip("tracing is disabled")
+	}
+
+	// callInlinedNew doesn't return the error with errtrace,
+	// so it's not part of the frames even if inlinedNew was inlined into it.
+	RequireFrames(t, callInlinedNew(), "errtracetest.inlinedNew")
+}
@@ -0,0 +1,78 @@
+package errtracetest
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"braces.dev/errtrace"
+	"braces.dev/errtrace/internal/diff"
+)
+
+// Golden compares the trace of err, cleaned with [Clean],
+// against the contents of the golden file at path,
+// failing the test if they don't match.
+//
+// Golden files are updated with the ERRTRACETEST_UPDATE environment variable.
+// Set it to 1 to write the current traces to their golden files instead:
+//
+//	ERRTRACETEST_UPDATE=1 go test -run TestFoo
+//
+// errtracetest doesn't define an -update flag
+// because flags are global to the test binary,
+// and many test packages already define their own -update flag
+// for other golden files; defining it twice panics.
+// As a convenience, if the environment variable isn't set
+// and the test package defines a boolean -update flag,
+// Golden also updates golden files when that flag is set.
+func Golden(t testing.TB, path string, err error) {
+	t.Helper()
+
+	got := Clean(errtrace.FormatString(err))
+	if updateGolden() {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("create golden file directory: %v", err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			t.Fatalf("write golden file: %v", err)
+		}
+		return
+	}
+
+	want, readErr := os.ReadFile(path)
+	if readErr != nil {
+		t.Fatalf("read golden file (set ERRTRACETEST_UPDATE=1 to create it): %v", readErr)
+	}
+
+	if string(want) != got {
+		t.Errorf("trace does not match %v (set ERRTRACETEST_UPDATE=1 to update it):\n%s",
+			path, diff.Lines(string(want), got))
+	}
+}
+
+// updateEnv is the environment variable that makes Golden
+// update golden files instead of comparing against them.
+const updateEnv = "ERRTRACETEST_UPDATE"
+
+// updateGolden reports whether golden files should be updated:
+// from ERRTRACETEST_UPDATE if it's set,
+// or from the test package's -update flag if it defines one.
+func updateGolden() bool {
+	if v, ok := os.LookupEnv(updateEnv); ok {
+		update, _ := strconv.ParseBool(v)
+		return update
+	}
+
+	f := flag.Lookup("update")
+	if f == nil {
+		return false
+	}
+	getter, ok := f.Value.(flag.Getter)
+	if !ok {
+		return false
+	}
+	update, _ := getter.Get().(bool)
+	return update
+}
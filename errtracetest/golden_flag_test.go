@@ -0,0 +1,24 @@
+package errtracetest_test
+
+import (
+	"errors"
+	"flag"
+	"runtime"
+	"testing"
+
+	"braces.dev/errtrace"
+	"braces.dev/errtrace/errtracetest"
+)
+
+// Test packages commonly define their own -update flag.
+// errtracetest must not define it too, or this would panic.
+var _ = flag.Bool("update", false, "update golden files")
+
+func TestGolden_ownUpdateFlag(t *testing.T) {
+	errtracetest.Golden(t, "testdata/own_update_flag.golden", ownUpdateFlagErr())
+}
+
+func ownUpdateFlagErr() error {
+	return errtrace.WrapFrame(errors.New("great sadness"),
+		runtime.Frame{Function: "example.com/foo.Load", File: "foo.go", Line: 1})
+}
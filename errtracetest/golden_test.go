@@ -0,0 +1,63 @@
+package errtracetest
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestGolden(t *testing.T) {
+	Golden(t, "testdata/frames.golden", frameErr())
+}
+
+func TestGolden_mismatch(t *testing.T) {
+	setUpdate(t, false) // don't overwrite the golden file
+
+	ft := runFake(func(t testing.TB) {
+		Golden(t, "testdata/frames.golden", errors.New("different message"))
+	})
+	if !ft.failed {
+		t.Fatalf("expected failure")
+	}
+	if want := "does not match testdata/frames.golden"; !strings.Contains(ft.msg, want) {
+		t.Errorf("failure %q does not contain %q", ft.msg, want)
+	}
+}
+
+func TestGolden_missing(t *testing.T) {
+	setUpdate(t, false)
+
+	path := filepath.Join(t.TempDir(), "missing.golden")
+	ft := runFake(func(t testing.TB) {
+		Golden(t, path, frameErr())
+	})
+	if want := "set ERRTRACETEST_UPDATE=1 to create it"; !ft.failed || !strings.Contains(ft.msg, want) {
+		t.Errorf("failure %q does not contain %q", ft.msg, want)
+	}
+}
+
+func TestGolden_update(t *testing.T) {
+	setUpdate(t, true)
+
+	path := filepath.Join(t.TempDir(), "nested", "frames.golden")
+	Golden(t, path, frameErr())
+
+	got, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want, err := os.ReadFile("testdata/frames.golden")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Errorf("updated golden file:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func setUpdate(t *testing.T, update bool) {
+	t.Setenv(updateEnv, strconv.FormatBool(update))
+}
@@ -1,3 +1,5 @@
+//go:build !errtrace_off
+
 package errtrace_test
 
 import (
@@ -1,3 +1,5 @@
+//go:build !errtrace_off
+
 package errtrace_test
 
 import (
@@ -82,6 +84,26 @@ func ExampleUnwrapFrame() {
 	//	/path/to/errtrace/example_trace_test.go:3
 }
 
+func ExampleParseTrace() {
+	trace := errtrace.FormatString(f1())
+
+	tree, err := errtrace.ParseTrace(strings.NewReader(trace))
+	if err != nil {
+		panic(err)
+	}
+
+	fmt.Println(tree.Message)
+	for _, frame := range tree.Trace {
+		fmt.Println(frame.Function)
+	}
+
+	// Output:
+	// failed
+	// braces.dev/errtrace_test.f3
+	// braces.dev/errtrace_test.f2
+	// braces.dev/errtrace_test.f1
+}
+
 func Example_logWithSlog() {
 	// This example demonstrates how to log an errtrace-wrapped error
 	// with the slog package.
@@ -0,0 +1,73 @@
+package errtrace
+
+import (
+	"fmt"
+	"log/slog"
+	"runtime"
+)
+
+// WrapFrame adds the given frame to the error's trace,
+// as if the error had been returned through that location.
+// If err is nil, WrapFrame returns nil.
+//
+// Unlike [Wrap], WrapFrame doesn't look at the caller.
+// Use it to build errors with a specific trace,
+// e.g. to test code that formats traces,
+// or to attach frames reported by a remote source.
+// Only the Function, File, and Line fields of the frame are used.
+//
+// [Format], [UnwrapFrame], and other functions in this package
+// treat these frames the same as frames recorded by [Wrap].
+// Frames are added even if tracing is disabled
+// with [SetEnabled] or the errtrace_off build tag.
+//
+// Any error that has a method `TraceFrame() runtime.Frame`
+// contributes that frame to the trace.
+func WrapFrame(err error, frame runtime.Frame) error {
+	if err == nil {
+		return nil
+	}
+
+	return &frameTrace{
+		err: err,
+		frame: runtime.Frame{
+			Function: frame.Function,
+			File:     frame.File,
+			Line:     frame.Line,
+		},
+	}
+}
+
+// frameTrace is an error with an explicit frame,
+// as opposed to errTrace, which has a program counter.
+type frameTrace struct {
+	err   error
+	frame runtime.Frame
+}
+
+func (e *frameTrace) Error() string {
+	return e.err.Error()
+}
+
+func (e *frameTrace) Unwrap() error {
+	return e.err
+}
+
+func (e *frameTrace) Format(s fmt.State, verb rune) {
+	if verb == 'v' && s.Flag('+') {
+		_ = Format(s, e)
+		return
+	}
+
+	fmt.Fprintf(s, fmt.FormatString(s, verb), e.err)
+}
+
+// LogValue implements the [slog.LogValuer] interface.
+func (e *frameTrace) LogValue() slog.Value {
+	return slog.StringValue(FormatString(e))
+}
+
+// TraceFrame returns the frame attached to the error.
+func (e *frameTrace) TraceFrame() runtime.Frame {
+	return e.frame
+}
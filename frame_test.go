@@ -0,0 +1,171 @@
+package errtrace_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"runtime"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace"
+	"braces.dev/errtrace/internal/diff"
+)
+
+func TestWrapFrame_nil(t *testing.T) {
+	if err := errtrace.WrapFrame(nil, runtime.Frame{Function: "foo"}); err != nil {
+		t.Errorf("WrapFrame(nil) = %v, want nil", err)
+	}
+}
+
+func TestWrapFrame_format(t *testing.T) {
+	frame := func(fn string, line int) runtime.Frame {
+		return runtime.Frame{Function: "example.com/app." + fn, File: "/src/app/app.go", Line: line}
+	}
+
+	errA := errtrace.WrapFrame(errors.New("err a"), frame("readA", 10))
+	errB := errtrace.WrapFrame(errors.New("err b"), frame("readB", 20))
+	err := errtrace.WrapFrame(errors.Join(errA, errB), frame("readAll", 30))
+	err = errtrace.WrapFrame(err, frame("main", 40))
+
+	want := strings.Join([]string{
+		"+- err a",
+		"|  ",
+		"|  example.com/app.readA",
+		"|  	/src/app/app.go:10",
+		"|  ",
+		"+- err b",
+		"|  ",
+		"|  example.com/app.readB",
+		"|  	/src/app/app.go:20",
+		"|  ",
+		"err a",
+		"err b",
+		"",
+		"example.com/app.readAll",
+		"	/src/app/app.go:30",
+		"example.com/app.main",
+		"	/src/app/app.go:40",
+		"",
+	}, "\n")
+
+	if got := errtrace.FormatString(err); got != want {
+		t.Errorf("FormatString():\n%s", diff.Lines(want, got))
+	}
+	if got := fmt.Sprintf("%+v", err); got != want {
+		t.Errorf("%%+v:\n%s", diff.Lines(want, got))
+	}
+	if got, want := fmt.Sprintf("%v", err), "err a\nerr b"; got != want {
+		t.Errorf("%%v = %q, want %q", got, want)
+	}
+
+	tree, parseErr := errtrace.ParseTrace(strings.NewReader(want))
+	if parseErr != nil {
+		t.Fatal(parseErr)
+	}
+	if d := diff.Diff([]runtime.Frame{frame("readAll", 30), frame("main", 40)}, tree.Trace); d != "" {
+		t.Errorf("ParseTrace() frames mismatch:\n%s", d)
+	}
+}
+
+func TestWrapFrame_unwrap(t *testing.T) {
+	orig := errors.New("great sadness")
+	want := runtime.Frame{Function: "example.com/app.main", File: "/src/app/main.go", Line: 12}
+	err := errtrace.WrapFrame(orig, runtime.Frame{
+		PC:       42, // ignored
+		Function: want.Function,
+		File:     want.File,
+		Line:     want.Line,
+	})
+
+	got, inner, ok := errtrace.UnwrapFrame(err)
+	if !ok {
+		t.Fatalf("UnwrapFrame(): want ok")
+	}
+	if got != want {
+		t.Errorf("UnwrapFrame() frame = %+v, want %+v", got, want)
+	}
+	if inner != orig {
+		t.Errorf("UnwrapFrame() inner = %v, want %v", inner, orig)
+	}
+
+	if !errors.Is(err, orig) {
+		t.Errorf("Is(): want true")
+	}
+	if got := errtrace.Strip(err); got != orig {
+		t.Errorf("Strip() = %v, want %v", got, orig)
+	}
+}
+
+func TestWrapFrame_slog(t *testing.T) {
+	if !errtrace.Enabled() {
+		t.Skip("tracing is disabled")
+	}
+
+	// Rebuild an error recorded by Wrap from its frames,
+	// and check that both log the same way.
+	orig := errors.New("great sadness")
+	pcErr := errtrace.Wrap(errtrace.Wrap(orig))
+
+	var frames []runtime.Frame
+	for err := pcErr; ; {
+		f, inner, ok := errtrace.UnwrapFrame(err)
+		if !ok {
+			break
+		}
+		frames = append(frames, f)
+		err = inner
+	}
+	frameErr := orig
+	for i := len(frames) - 1; i >= 0; i-- {
+		frameErr = errtrace.WrapFrame(frameErr, frames[i])
+	}
+
+	log := func(newHandler func(*bytes.Buffer) slog.Handler, err error) string {
+		var buf bytes.Buffer
+		logger := slog.New(newHandler(&buf))
+		logger.Error("failed", "error", err)
+		return buf.String()
+	}
+	noTime := &slog.HandlerOptions{
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if len(groups) == 0 && a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}
+
+	t.Run("JSON", func(t *testing.T) {
+		newHandler := func(buf *bytes.Buffer) slog.Handler {
+			return slog.NewJSONHandler(buf, noTime)
+		}
+		want := log(newHandler, pcErr)
+		got := log(newHandler, frameErr)
+		if got != want {
+			t.Errorf("JSON output:\n%s", diff.Lines(want, got))
+		}
+
+		var entry struct {
+			Error string `json:"error"`
+		}
+		if err := json.Unmarshal([]byte(got), &entry); err != nil {
+			t.Fatal(err)
+		}
+		if want := errtrace.FormatString(frameErr); entry.Error != want {
+			t.Errorf("logged error:\n%s", diff.Lines(want, entry.Error))
+		}
+	})
+
+	t.Run("text", func(t *testing.T) {
+		newHandler := func(buf *bytes.Buffer) slog.Handler {
+			return slog.NewTextHandler(buf, noTime)
+		}
+		want := log(newHandler, pcErr)
+		if got := log(newHandler, frameErr); got != want {
+			t.Errorf("text output:\n%s", diff.Lines(want, got))
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package errtrace
+
+import (
+	"fmt"
+	"reflect"
+	"runtime"
+	"sync"
+	"sync/atomic"
+)
+
+// _helpers holds the functions registered with Helper or RegisterHelper.
+// It's nil until the first helper is registered,
+// so wrap only pays for a nil check if helpers aren't used.
+var _helpers atomic.Pointer[helperRegistry]
+
+// _helpersMu serializes updates to _helpers.
+var _helpersMu sync.Mutex
+
+// helperRegistry is an immutable set of helper functions.
+// It's replaced in its entirety when a new helper is registered.
+type helperRegistry struct {
+	funcs map[string]struct{} // fully qualified function names
+
+	// inHelper caches the helper that PCs passed to wrap are inside,
+	// so that wrap doesn't symbolize the same PC again.
+	// Most PCs aren't inside a helper,
+	// so this has to be cheap to check on every wrap:
+	// each PC maps to a single slot that's read with one atomic load.
+	// PCs that share a slot replace each other.
+	inHelper [_helperCacheSize]atomic.Pointer[helperCacheEntry]
+}
+
+// _helperCacheSize is the number of slots in helperRegistry.inHelper.
+// It must be a power of two.
+const _helperCacheSize = 1024
+
+// helperCacheEntry records the helper that pc is inside,
+// or "" if it's not inside a helper.
+type helperCacheEntry struct {
+	pc     uintptr
+	helper string
+}
+
+// Helper marks the calling function as an error helper,
+// similar to [testing.T.Helper].
+// Errors wrapped inside a helper with [Wrap], [New], [Errorf], and friends
+// are attributed to the helper's caller instead of the helper.
+// If a helper is called by another helper,
+// the error is attributed to the first caller that isn't a helper.
+//
+//	func wrapNotFound(err error) error {
+//		errtrace.Helper()
+//		return errtrace.Wrap(fmt.Errorf("not found: %w", err))
+//	}
+//
+// Helper has to look up the calling function on each call.
+// Use [RegisterHelper] to mark helpers once, e.g. in an init function.
+//
+// Attributing errors to a helper's caller is slower than a plain Wrap,
+// and traces don't collapse repeated returns through helpers.
+// Use [GetCaller] in helpers that are called in hot paths.
+func Helper() {
+	if !_tracingBuilt {
+		return
+	}
+
+	var pcs [1]uintptr
+	if runtime.Callers(2, pcs[:]) == 0 { // runtime.Callers, Helper
+		return
+	}
+	registerHelper(symbolize(pcs[:])[0].Function)
+}
+
+// RegisterHelper marks fn as an error helper.
+// See [Helper] for details.
+//
+// fn must be a function or a method expression (e.g. (*T).Method).
+// Closures and method values (e.g. t.Method) are not supported
+// because they don't refer to the function that returns the error.
+// RegisterHelper panics if fn is not a function.
+func RegisterHelper(fn any) {
+	v := reflect.ValueOf(fn)
+	if v.Kind() != reflect.Func || v.IsNil() {
+		panic(fmt.Sprintf("errtrace.RegisterHelper: expected a function, got %T", fn))
+	}
+
+	if f := runtime.FuncForPC(v.Pointer()); f != nil {
+		registerHelper(f.Name())
+	}
+}
+
+func registerHelper(name string) {
+	if name == "" || _helpers.Load().has(name) {
+		return
+	}
+
+	_helpersMu.Lock()
+	defer _helpersMu.Unlock()
+
+	old := _helpers.Load()
+	if old.has(name) {
+		return
+	}
+
+	funcs := make(map[string]struct{})
+	if old != nil {
+		for f := range old.funcs {
+			funcs[f] = struct{}{}
+		}
+	}
+	funcs[name] = struct{}{}
+	_helpers.Store(&helperRegistry{funcs: funcs})
+}
+
+func (r *helperRegistry) has(name string) bool {
+	if r == nil {
+		return false
+	}
+	_, ok := r.funcs[name]
+	return ok
+}
+
+// cachedHelper returns the helper function that pc is inside,
+// or "" if it's not inside a helper.
+// ok is false if that isn't cached yet; use lookupHelper then.
+//
+// It's called on every wrap once a helper is registered,
+// so it's small enough to be inlined.
+func (r *helperRegistry) cachedHelper(pc uintptr) (helper string, ok bool) {
+	if entry := r.inHelper[pc%_helperCacheSize].Load(); entry != nil && entry.pc == pc {
+		return entry.helper, true
+	}
+	return "", false
+}
+
+// lookupHelper symbolizes pc to find the helper that it's inside,
+// and caches the result for cachedHelper.
+func (r *helperRegistry) lookupHelper(pc uintptr) string {
+	entry := &helperCacheEntry{pc: pc}
+	if fn := symbolize([]uintptr{pc})[0].Function; r.has(fn) {
+		entry.helper = fn
+	}
+	r.inHelper[pc%_helperCacheSize].Store(entry)
+	return entry.helper
+}
+
+// helperCaller reports the frame that called the given helper function,
+// skipping over other helpers.
+// ok is false if the helper is not on the current stack,
+// e.g. because a [Caller] was captured elsewhere.
+func (r *helperRegistry) helperCaller(helper string) (frame runtime.Frame, ok bool) {
+	var pcs [32]uintptr
+	n := runtime.Callers(3, pcs[:]) // runtime.Callers, helperCaller, wrap
+	frames := runtime.CallersFrames(pcs[:n])
+	var inHelper bool
+	for {
+		f, more := frames.Next()
+		switch {
+		case f.Function == helper:
+			inHelper = true
+		case inHelper && !r.has(f.Function):
+			return runtime.Frame{
+				Function: f.Function,
+				File:     f.File,
+				Line:     f.Line,
+			}, true
+		}
+
+		if !more {
+			return runtime.Frame{}, false
+		}
+	}
+}
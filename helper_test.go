@@ -0,0 +1,137 @@
+//go:build !errtrace_off
+
+package errtrace_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+func init() {
+	errtrace.RegisterHelper(registeredHelper)
+	errtrace.RegisterHelper((*helperType).wrap)
+}
+
+func TestHelper(t *testing.T) {
+	err := callMarkedHelper()
+	wantErr(t, err, "callMarkedHelper")
+	if !errors.Is(err, errFoo) {
+		t.Errorf("Is(): want true")
+	}
+
+	// The frame for errtrace.Wrap in callMarkedHelper follows.
+	_, inner, _ := errtrace.UnwrapFrame(err)
+	wantErr(t, inner, "callMarkedHelper")
+}
+
+func callMarkedHelper() error {
+	return errtrace.Wrap(markedHelper(errFoo))
+}
+
+func markedHelper(err error) error {
+	errtrace.Helper()
+	return errtrace.Wrap(fmt.Errorf("helper: %w", err))
+}
+
+func TestHelper_nested(t *testing.T) {
+	err := callNestedHelper()
+	wantErr(t, err, "callNestedHelper")
+	if got, want := err.Error(), "great sadness"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	if _, inner, _ := errtrace.UnwrapFrame(err); hasFrame(inner) {
+		t.Errorf("error should have a single frame:\n%+v", err)
+	}
+}
+
+func callNestedHelper() error {
+	return nestedHelperOuter("great sadness")
+}
+
+func nestedHelperOuter(msg string) error {
+	errtrace.Helper()
+	return nestedHelperInner(msg)
+}
+
+func nestedHelperInner(msg string) error {
+	errtrace.Helper()
+	return errtrace.New(msg)
+}
+
+func TestRegisterHelper(t *testing.T) {
+	err := callRegisteredHelper()
+	wantErr(t, err, "callRegisteredHelper")
+
+	err = callRegisteredMethod()
+	wantErr(t, err, "callRegisteredMethod")
+}
+
+func callRegisteredHelper() error {
+	return registeredHelper("great sadness")
+}
+
+func registeredHelper(msg string) error {
+	return errtrace.Errorf("%v", msg)
+}
+
+type helperType struct{}
+
+func callRegisteredMethod() error {
+	var h helperType
+	return h.wrap(errFoo)
+}
+
+func (*helperType) wrap(err error) error {
+	return errtrace.Wrap(err)
+}
+
+func TestRegisterHelper_notHelper(t *testing.T) {
+	// Functions that aren't helpers are not affected.
+	err := callNotHelper()
+	wantErr(t, err, "notHelper")
+}
+
+func callNotHelper() error {
+	return notHelper()
+}
+
+func notHelper() error {
+	return errtrace.Wrap(errFoo)
+}
+
+func TestRegisterHelper_notFunc(t *testing.T) {
+	tests := []struct {
+		name string
+		give any
+	}{
+		{"nil", nil},
+		{"string", "foo"},
+		{"nil func", (func())(nil)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				r := recover()
+				if r == nil {
+					t.Fatalf("expected panic")
+				}
+				if msg := fmt.Sprint(r); !strings.Contains(msg, "expected a function") {
+					t.Errorf("unexpected panic: %v", msg)
+				}
+			}()
+
+			errtrace.RegisterHelper(tt.give)
+		})
+	}
+}
+
+func hasFrame(err error) bool {
+	_, _, ok := errtrace.UnwrapFrame(err)
+	return ok
+}
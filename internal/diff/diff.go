@@ -1,5 +1,6 @@
 // Package diff provides utilities for comparing strings and slices
-// to produce a readable diff output for tests.
+// to produce a readable diff output for tests,
+// and unified diffs for users.
 package diff
 
 import (
@@ -0,0 +1,191 @@
+package diff
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// _context is the number of unchanged lines
+// shown around each change in a unified diff.
+const _context = 3
+
+// Unified returns a unified diff of two texts,
+// in the same format as 'gofmt -d'.
+// oldName and newName are used in the header of the diff.
+//
+// It returns an empty string if the texts are equal.
+func Unified(oldName, newName, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+
+	edits := editScript(splitLines(oldText), splitLines(newText))
+
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "diff %s %s\n", oldName, newName)
+	fmt.Fprintf(&buf, "--- %s\n", oldName)
+	fmt.Fprintf(&buf, "+++ %s\n", newName)
+
+	// oldLine[i] and newLine[i] are the 0-indexed lines
+	// that edits[i] is at in the old and new texts.
+	oldLine := make([]int, len(edits)+1)
+	newLine := make([]int, len(edits)+1)
+	for i, e := range edits {
+		oldLine[i+1], newLine[i+1] = oldLine[i], newLine[i]
+		if e.kind != editInsert {
+			oldLine[i+1]++
+		}
+		if e.kind != editDelete {
+			newLine[i+1]++
+		}
+	}
+
+	for i := 0; i < len(edits); {
+		if edits[i].kind == editEqual {
+			i++
+			continue
+		}
+
+		// Extend the hunk until there are more than
+		// 2*_context unchanged lines after the last change.
+		start := max(i-_context, 0)
+		end := i
+		for j := i; j < len(edits) && j-end <= 2*_context; j++ {
+			if edits[j].kind != editEqual {
+				end = j + 1
+			}
+		}
+		end = min(end+_context, len(edits))
+
+		fmt.Fprintf(&buf, "@@ -%s +%s @@\n",
+			hunkRange(oldLine[start], oldLine[end]-oldLine[start]),
+			hunkRange(newLine[start], newLine[end]-newLine[start]))
+		for _, e := range edits[start:end] {
+			buf.WriteString(string(e.kind))
+			buf.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				buf.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+
+		i = end
+	}
+
+	return buf.String()
+}
+
+// hunkRange formats the range of lines in a hunk header.
+// start is 0-indexed.
+func hunkRange(start, n int) string {
+	switch n {
+	case 0:
+		// An empty range refers to the line before it.
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	default:
+		return fmt.Sprintf("%d,%d", start+1, n)
+	}
+}
+
+// splitLines splits s into lines, keeping the trailing newlines.
+// The last line won't have a newline if s doesn't end with one.
+func splitLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+type editKind string
+
+const (
+	editEqual  editKind = " "
+	editDelete editKind = "-"
+	editInsert editKind = "+"
+)
+
+type edit struct {
+	kind editKind
+	line string
+}
+
+// editScript returns the shortest list of edits that turns a into b,
+// using Myers' algorithm.
+func editScript(a, b []string) []edit {
+	n, m := len(a), len(b)
+	if n+m == 0 {
+		return nil
+	}
+
+	// v[offset+k] is the furthest x reached on diagonal k (x - y = k).
+	// trace[d] holds v[offset-d-1 : offset+d+2] from before step d,
+	// which is all that's needed to backtrack from step d.
+	offset := n + m + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+
+search:
+	for d := 0; d <= n+m; d++ {
+		trace = append(trace, slices.Clone(v[offset-d-1:offset+d+2]))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // down: insert from b
+			} else {
+				x = v[offset+k-1] + 1 // right: delete from a
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	// Walk back from the end, following the path found above.
+	edits := make([]edit, 0, n+m)
+	x, y := n, m
+	for d := len(trace) - 1; d > 0; d-- {
+		prev := trace[d]
+		get := func(k int) int { return prev[k+d+1] }
+
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && get(k-1) < get(k+1)) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := get(prevK)
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			edits = append(edits, edit{editEqual, a[x]})
+		}
+		if x == prevX {
+			y--
+			edits = append(edits, edit{editInsert, b[y]})
+		} else {
+			x--
+			edits = append(edits, edit{editDelete, a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		edits = append(edits, edit{editEqual, a[x]})
+	}
+
+	slices.Reverse(edits)
+	return edits
+}
@@ -0,0 +1,223 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name     string
+		old, new string
+		want     string
+	}{
+		{
+			name: "equal",
+			old:  "a\nb\n",
+			new:  "a\nb\n",
+			want: "",
+		},
+		{
+			name: "change",
+			old:  "a\nb\nc\n",
+			new:  "a\nB\nc\n",
+			want: lines(
+				"diff old new",
+				"--- old",
+				"+++ new",
+				"@@ -1,3 +1,3 @@",
+				" a",
+				"-b",
+				"+B",
+				" c",
+			),
+		},
+		{
+			name: "insert at start",
+			old:  "b\n",
+			new:  "a\nb\n",
+			want: lines(
+				"diff old new",
+				"--- old",
+				"+++ new",
+				"@@ -1 +1,2 @@",
+				"+a",
+				" b",
+			),
+		},
+		{
+			name: "from empty",
+			old:  "",
+			new:  "a\n",
+			want: lines(
+				"diff old new",
+				"--- old",
+				"+++ new",
+				"@@ -0,0 +1 @@",
+				"+a",
+			),
+		},
+		{
+			name: "no newline at end",
+			old:  "a\nb",
+			new:  "a\nb\n",
+			want: lines(
+				"diff old new",
+				"--- old",
+				"+++ new",
+				"@@ -1,2 +1,2 @@",
+				" a",
+				"-b",
+				`\ No newline at end of file`,
+				"+b",
+			),
+		},
+		{
+			name: "separate hunks",
+			old:  numbered(1, 20),
+			new:  strings.Replace(strings.Replace(numbered(1, 20), "2\n", "two\n", 1), "19\n", "nineteen\n", 1),
+			want: lines(
+				"diff old new",
+				"--- old",
+				"+++ new",
+				"@@ -1,5 +1,5 @@",
+				" 1",
+				"-2",
+				"+two",
+				" 3",
+				" 4",
+				" 5",
+				"@@ -16,5 +16,5 @@",
+				" 16",
+				" 17",
+				" 18",
+				"-19",
+				"+nineteen",
+				" 20",
+			),
+		},
+		{
+			name: "merged hunks",
+			old:  numbered(1, 10),
+			new:  strings.Replace(strings.Replace(numbered(1, 10), "1\n", "one\n", 1), "8\n", "eight\n", 1),
+			want: lines(
+				"diff old new",
+				"--- old",
+				"+++ new",
+				"@@ -1,10 +1,10 @@",
+				"-1",
+				"+one",
+				" 2",
+				" 3",
+				" 4",
+				" 5",
+				" 6",
+				" 7",
+				"-8",
+				"+eight",
+				" 9",
+				" 10",
+			),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Unified("old", "new", tt.old, tt.new)
+			if got != tt.want {
+				t.Errorf("got:\n%s\nwant:\n%s\ndiff:\n%s", got, tt.want, Lines(tt.want, got))
+			}
+		})
+	}
+}
+
+func TestUnifiedApply(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	randomText := func() string {
+		var sb strings.Builder
+		for i := rnd.Intn(30); i > 0; i-- {
+			fmt.Fprintf(&sb, "%d\n", rnd.Intn(5))
+		}
+		s := sb.String()
+		if rnd.Intn(4) == 0 {
+			s = strings.TrimSuffix(s, "\n")
+		}
+		return s
+	}
+
+	for i := 0; i < 1000; i++ {
+		oldText, newText := randomText(), randomText()
+		d := Unified("old", "new", oldText, newText)
+		if got := apply(t, oldText, d); got != newText {
+			t.Fatalf("applying diff to:\n%s\ngot:\n%s\nwant:\n%s\ndiff:\n%s", oldText, got, newText, d)
+		}
+	}
+}
+
+// apply applies a unified diff produced by Unified to oldText.
+func apply(t *testing.T, oldText, d string) string {
+	if d == "" {
+		return oldText
+	}
+
+	oldLines := splitLines(oldText)
+	var out []string
+	var next int // next line of oldLines to copy
+
+	// Skip the header.
+	diffLines := strings.Split(strings.TrimSuffix(d, "\n"), "\n")[3:]
+	for i, line := range diffLines {
+		switch {
+		case strings.HasPrefix(line, "@@ "):
+			oldRange := strings.Fields(line)[1][1:] // -start,n
+			start, _, _ := strings.Cut(oldRange, ",")
+			n, err := strconv.Atoi(start)
+			if err != nil {
+				t.Fatalf("bad hunk header %q", line)
+			}
+			if !strings.Contains(oldRange, ",0") {
+				n-- // 1-indexed unless the range is empty
+			}
+			out = append(out, oldLines[next:n]...)
+			next = n
+
+		case line == `\ No newline at end of file`:
+			// Only matters if the line is in the new text.
+			if !strings.HasPrefix(diffLines[i-1], "-") {
+				last := &out[len(out)-1]
+				*last = strings.TrimSuffix(*last, "\n")
+			}
+
+		case strings.HasPrefix(line, " "):
+			out = append(out, line[1:]+"\n")
+			next++
+
+		case strings.HasPrefix(line, "-"):
+			next++
+
+		case strings.HasPrefix(line, "+"):
+			out = append(out, line[1:]+"\n")
+
+		default:
+			t.Fatalf("bad diff line %q", line)
+		}
+	}
+	out = append(out, oldLines[next:]...)
+	return strings.Join(out, "")
+}
+
+func lines(ls ...string) string {
+	return strings.Join(ls, "\n") + "\n"
+}
+
+// numbered returns lines with numbers from start to end, inclusive.
+func numbered(start, end int) string {
+	var sb strings.Builder
+	for i := start; i <= end; i++ {
+		fmt.Fprintf(&sb, "%d\n", i)
+	}
+	return sb.String()
+}
@@ -0,0 +1,26 @@
+// Package pc provides access to the program counter
+// to determine the caller of a function.
+package pc
+
+// Mode specifies how program counters are captured.
+type Mode int
+
+const (
+	// ModeAsm uses assembly to read the return address
+	// from the caller's stack frame.
+	ModeAsm Mode = iota + 1
+
+	// ModeSafe uses runtime.Callers because assembly is not available:
+	// the safe build tag is set, or the architecture is not supported.
+	ModeSafe
+
+	// ModeFallback uses runtime.Callers because assembly is available,
+	// but it failed its self-check at startup.
+	ModeFallback
+)
+
+// CurrentMode reports how GetCaller and GetCallerSkip1
+// capture program counters.
+func CurrentMode() Mode {
+	return _mode
+}
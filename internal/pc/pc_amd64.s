@@ -4,6 +4,11 @@
 
 // func GetCaller() uintptr
 TEXT ·GetCaller(SB),NOSPLIT|NOFRAME,$0-8
+	// If the self-check failed, tail call the safe implementation.
+	// It sees the same frames as if it was called directly.
+	CMPB ·fallback(SB), $0
+	JNE  safe
+
 	// BP is the hardware register frame pointer, as used in:
 	// https://github.com/golang/go/blob/go1.21.4/src/runtime/asm_amd64.s#L2091-L2093
 	// The return address sits one word above, hence we evaluate `*(BP+8)`.
@@ -11,10 +16,50 @@ TEXT ·GetCaller(SB),NOSPLIT|NOFRAME,$0-8
 	MOVQ AX, ret+0(FP)
 	RET
 
+safe:
+	JMP ·getCallerSafe(SB)
+
 // func GetCallerSkip1() uintptr
 TEXT ·GetCallerSkip1(SB),NOSPLIT|NOFRAME,$0-8
+	CMPB ·fallback(SB), $0
+	JNE  safe
+
 	// BP contains the frame pointer, dereference it to skip a frame.
 	MOVQ (BP), AX
 	MOVQ 8(AX), AX
 	MOVQ AX, ret+0(FP)
 	RET
+
+safe:
+	JMP ·getCallerSkip1Safe(SB)
+
+// func GetCallerSkip(n int) uintptr
+TEXT ·GetCallerSkip(SB),NOSPLIT|NOFRAME,$0-16
+	CMPB ·fallback(SB), $0
+	JNE  safe
+
+	// Follow the chain of frame pointers to skip n frames.
+	// The outermost frame has a nil frame pointer.
+	MOVQ n+0(FP), CX
+	MOVQ BP, AX
+
+loop:
+	TESTQ CX, CX
+	JZ    done
+	MOVQ  (AX), AX
+	TESTQ AX, AX
+	JZ    notfound
+	DECQ  CX
+	JMP   loop
+
+done:
+	MOVQ 8(AX), AX
+	MOVQ AX, ret+8(FP)
+	RET
+
+notfound:
+	MOVQ $0, ret+8(FP)
+	RET
+
+safe:
+	JMP ·getCallerSkipSafe(SB)
@@ -4,6 +4,11 @@
 
 // func GetCaller() uintptr
 TEXT ·GetCaller(SB),NOSPLIT|NOFRAME,$0-8
+	// If the self-check failed, tail call the safe implementation.
+	// It sees the same frames as if it was called directly.
+	MOVBU ·fallback(SB), R20
+	CBNZ  R20, safe
+
 	// R29 is the frame pointer, documented in https://pkg.go.dev/cmd/internal/obj/arm64
 	// and used in https://github.com/golang/go/blob/go1.21.4/src/runtime/asm_arm64.s#L1571
 	// The return address sits one word above, hence we evaluate `*(R29+8)`.
@@ -11,11 +16,48 @@ TEXT ·GetCaller(SB),NOSPLIT|NOFRAME,$0-8
 	MOVD R20, ret+0(FP)
 	RET
 
+safe:
+	JMP ·getCallerSafe(SB)
 
 // func GetCallerSkip1() uintptr
 TEXT ·GetCallerSkip1(SB),NOSPLIT|NOFRAME,$0-8
+	MOVBU ·fallback(SB), R20
+	CBNZ  R20, safe
+
 	// R29 contains the frame pointer, dereference it to skip a frame.
 	MOVD (R29), R20
 	MOVD 8(R20), R20
 	MOVD R20, ret+0(FP)
 	RET
+
+safe:
+	JMP ·getCallerSkip1Safe(SB)
+
+// func GetCallerSkip(n int) uintptr
+TEXT ·GetCallerSkip(SB),NOSPLIT|NOFRAME,$0-16
+	MOVBU ·fallback(SB), R20
+	CBNZ  R20, safe
+
+	// Follow the chain of frame pointers to skip n frames.
+	// The outermost frame has a nil frame pointer.
+	MOVD n+0(FP), R21
+	MOVD R29, R20
+
+loop:
+	CBZ  R21, done
+	MOVD (R20), R20
+	CBZ  R20, notfound
+	SUB  $1, R21
+	B    loop
+
+done:
+	MOVD 8(R20), R20
+	MOVD R20, ret+8(FP)
+	RET
+
+notfound:
+	MOVD ZR, ret+8(FP)
+	RET
+
+safe:
+	JMP ·getCallerSkipSafe(SB)
@@ -1,11 +1,60 @@
-//go:build !safe && (arm64 || amd64)
+//go:build !safe && (amd64 || arm64 || riscv64)
 
-// Package pc provides access to the program counter
-// to determine the caller of a function.
 package pc
 
 // GetCaller returns the program counter of the caller's caller.
+//
+// Implemented in assembly.
+// Defers to getCallerSafe if fallback is set.
 func GetCaller() uintptr
 
 // GetCallerSkip1 is similar to GetCaller, but skips an additional caller.
+//
+// Implemented in assembly.
+// Defers to getCallerSkip1Safe if fallback is set,
+// and always on riscv64 (see pc_riscv64.s).
 func GetCallerSkip1() uintptr
+
+// GetCallerSkip is similar to GetCaller, but skips n additional callers.
+// GetCallerSkip(0) is equivalent to GetCaller,
+// and GetCallerSkip(1) to GetCallerSkip1.
+// n must not be negative.
+//
+// Implemented in assembly.
+// Defers to getCallerSkipSafe if fallback is set,
+// and always on riscv64 (see pc_riscv64.s).
+func GetCallerSkip(n int) uintptr
+
+// getCallerSafe, getCallerSkip1Safe, and getCallerSkipSafe
+// are tail called by the assembly in place of
+// GetCaller, GetCallerSkip1, and GetCallerSkip if fallback is set.
+// They take the same position in the call stack.
+
+func getCallerSafe() uintptr {
+	return getCaller(0)
+}
+
+func getCallerSkip1Safe() uintptr {
+	return getCaller(1)
+}
+
+func getCallerSkipSafe(n int) uintptr {
+	return getCaller(n)
+}
+
+// fallback is set if the assembly implementation failed its self-check.
+// Read by the assembly implementation.
+var fallback bool
+
+var _mode = ModeAsm
+
+func init() {
+	// The assembly implementation relies on the frame layout
+	// used by the compiler, which could change in a new Go release
+	// or with unusual compiler flags.
+	// Verify it against runtime.Callers before relying on it.
+	if !selfCheck() {
+		fallback = true
+		_mode = ModeFallback
+	}
+}
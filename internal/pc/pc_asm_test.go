@@ -0,0 +1,21 @@
+//go:build !safe && (amd64 || arm64 || riscv64)
+
+package pc
+
+import "testing"
+
+func TestCurrentMode(t *testing.T) {
+	// The self-check passed at startup.
+	if got, want := CurrentMode(), ModeAsm; got != want {
+		t.Errorf("CurrentMode() = %v, want %v", got, want)
+	}
+}
+
+func TestFallback(t *testing.T) {
+	defer func(old bool) { fallback = old }(fallback)
+	fallback = true
+
+	t.Run("GetCaller", TestGetCaller)
+	t.Run("GetCallerSkip1", TestGetCallerSkip1)
+	t.Run("GetCallerSkip", TestGetCallerSkip)
+}
@@ -0,0 +1,79 @@
+package pc
+
+import "runtime"
+
+// selfCheck reports whether GetCaller, GetCallerSkip1, and GetCallerSkip
+// agree with runtime.Callers.
+// The assembly implementation runs it at startup;
+// tests run it for all implementations.
+func selfCheck() bool {
+	got, want := checkGetCaller()
+	if got == 0 || got != want {
+		return false
+	}
+
+	got, want = checkGetCallerSkip1()
+	if got == 0 || got != want {
+		return false
+	}
+
+	got, want = checkGetCallerSkip()
+	return got != 0 && got == want
+}
+
+// checkGetCaller calls GetCaller the same way as errtrace.Wrap.
+//
+//go:noinline
+func checkGetCaller() (got, want uintptr) {
+	got = GetCaller()
+	want = callerPC(2) // runtime.Callers, checkGetCaller
+	return got, want
+}
+
+// checkGetCallerSkip1 calls GetCallerSkip1 the same way
+// as an error helper that uses errtrace.GetCaller.
+//
+//go:noinline
+func checkGetCallerSkip1() (got, want uintptr) {
+	return checkGetCallerSkip1Inner()
+}
+
+// checkGetCallerSkip1Inner is called in place of errtrace.GetCaller.
+//
+//go:noinline
+func checkGetCallerSkip1Inner() (got, want uintptr) {
+	got = GetCallerSkip1()
+	want = callerPC(3) // runtime.Callers, checkGetCallerSkip1Inner, checkGetCallerSkip1
+	return got, want
+}
+
+// checkGetCallerSkip calls GetCallerSkip the same way
+// as an error helper that uses errtrace.GetCallerSkip(2)
+// from inside another helper.
+//
+//go:noinline
+func checkGetCallerSkip() (got, want uintptr) {
+	return checkGetCallerSkipMiddle()
+}
+
+//go:noinline
+func checkGetCallerSkipMiddle() (got, want uintptr) {
+	return checkGetCallerSkipInner()
+}
+
+// checkGetCallerSkipInner is called in place of errtrace.GetCallerSkip.
+//
+//go:noinline
+func checkGetCallerSkipInner() (got, want uintptr) {
+	got = GetCallerSkip(2)
+	want = callerPC(4) // runtime.Callers, checkGetCallerSkipInner, checkGetCallerSkipMiddle, checkGetCallerSkip
+	return got, want
+}
+
+func callerPC(skip int) uintptr {
+	var callers [1]uintptr
+	if runtime.Callers(skip+1, callers[:]) == 0 { // +1 for callerPC
+		return 0
+	}
+	return callers[0]
+}
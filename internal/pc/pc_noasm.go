@@ -0,0 +1,31 @@
+//go:build safe || !(amd64 || arm64 || riscv64)
+
+package pc
+
+// Architectures without assembly use runtime.Callers.
+//
+// On 386 in particular, assembly can't find the caller's caller cheaply.
+// 386 has no link register, and the Go compiler doesn't maintain
+// frame pointers for it, so the caller's return address is stored
+// at an offset from the stack pointer that depends on the size
+// of the caller's frame, which the assembly doesn't know.
+// riscv64 has the same problem for GetCallerSkip1 and GetCallerSkip,
+// but its link register makes GetCaller possible (see pc_riscv64.s).
+
+// GetCaller returns the program counter of the caller's caller.
+func GetCaller() uintptr {
+	return getCaller(0)
+}
+
+// GetCallerSkip1 is similar to GetCaller, but skips an additional caller.
+func GetCallerSkip1() uintptr {
+	return getCaller(1)
+}
+
+// GetCallerSkip is similar to GetCaller, but skips n additional callers.
+// n must not be negative.
+func GetCallerSkip(n int) uintptr {
+	return getCaller(n)
+}
+
+var _mode = ModeSafe
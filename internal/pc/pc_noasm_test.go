@@ -0,0 +1,11 @@
+//go:build safe || !(amd64 || arm64 || riscv64)
+
+package pc
+
+import "testing"
+
+func TestCurrentMode(t *testing.T) {
+	if got, want := CurrentMode(), ModeSafe; got != want {
+		t.Errorf("CurrentMode() = %v, want %v", got, want)
+	}
+}
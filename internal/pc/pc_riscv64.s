@@ -0,0 +1,36 @@
+//go:build !safe && riscv64
+
+#include "textflag.h"
+
+// func GetCaller() uintptr
+TEXT ·GetCaller(SB),NOSPLIT|NOFRAME,$0-8
+	// If the self-check failed, tail call the safe implementation.
+	// It sees the same frames as if it was called directly.
+	MOVBU ·fallback(SB), T0
+	BNEZ  T0, safe
+
+	// riscv64 doesn't use frame pointers,
+	// but non-leaf functions save the link register at the bottom of their frame.
+	// This function has no frame, so X2 (the hardware stack pointer)
+	// points at the caller's frame, and 0(X2) is the caller's return address.
+	// See https://github.com/golang/go/blob/go1.21.4/src/cmd/internal/obj/riscv/obj.go#L435-L441
+	MOV 0(X2), T0
+	MOV T0, ret+0(FP)
+	RET
+
+safe:
+	JMP ·getCallerSafe(SB)
+
+// func GetCallerSkip1() uintptr
+TEXT ·GetCallerSkip1(SB),NOSPLIT|NOFRAME,$0-8
+	// Without frame pointers, the caller's caller can't be found
+	// without knowing the size of the caller's frame:
+	// 0(X2) only holds the caller's own return address.
+	// Always tail call the safe implementation, regardless of fallback.
+	// CurrentMode still reports ModeAsm because GetCaller uses assembly.
+	JMP ·getCallerSkip1Safe(SB)
+
+// func GetCallerSkip(n int) uintptr
+TEXT ·GetCallerSkip(SB),NOSPLIT|NOFRAME,$0-16
+	// See GetCallerSkip1.
+	JMP ·getCallerSkipSafe(SB)
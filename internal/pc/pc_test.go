@@ -2,6 +2,7 @@ package pc
 
 import (
 	"errors"
+	"runtime"
 	"testing"
 )
 
@@ -10,6 +11,93 @@ func wrap(err error) uintptr {
 	return GetCaller()
 }
 
+func TestSelfCheck(t *testing.T) {
+	if !selfCheck() {
+		t.Fatalf("self-check failed in mode %v", CurrentMode())
+	}
+}
+
+func TestGetCaller(t *testing.T) {
+	got, want := getCallerOuter()
+	if got != want {
+		t.Errorf("GetCaller() = %#x, want %#x", got, want)
+	}
+}
+
+//go:noinline
+func getCallerOuter() (got, want uintptr) {
+	return getCallerInner()
+}
+
+// getCallerInner is called in place of errtrace.Wrap.
+//
+//go:noinline
+func getCallerInner() (got, want uintptr) {
+	got = GetCaller()
+
+	var callers [1]uintptr
+	runtime.Callers(2, callers[:]) // skip runtime.Callers, getCallerInner
+	return got, callers[0]
+}
+
+func TestGetCallerSkip1(t *testing.T) {
+	got, want := getCallerSkip1Outer()
+	if got != want {
+		t.Errorf("GetCallerSkip1() = %#x, want %#x", got, want)
+	}
+}
+
+//go:noinline
+func getCallerSkip1Outer() (got, want uintptr) {
+	return getCallerSkip1Inner()
+}
+
+// getCallerSkip1Inner is called in place of errtrace.GetCaller.
+//
+//go:noinline
+func getCallerSkip1Inner() (got, want uintptr) {
+	got = GetCallerSkip1()
+
+	var callers [1]uintptr
+	runtime.Callers(3, callers[:]) // skip runtime.Callers, getCallerSkip1Inner, getCallerSkip1Outer
+	return got, callers[0]
+}
+
+func TestGetCallerSkip(t *testing.T) {
+	for n := 0; n <= 3; n++ {
+		got, want := getCallerSkipOuter(n)
+		if got == 0 || got != want {
+			t.Errorf("GetCallerSkip(%d) = %#x, want %#x", n, got, want)
+		}
+	}
+
+	// Skipping past the outermost frame.
+	if got, _ := getCallerSkipInner(1 << 20); got != 0 {
+		t.Errorf("GetCallerSkip(1<<20) = %#x, want 0", got)
+	}
+}
+
+//go:noinline
+func getCallerSkipOuter(n int) (got, want uintptr) {
+	return getCallerSkipMiddle(n)
+}
+
+//go:noinline
+func getCallerSkipMiddle(n int) (got, want uintptr) {
+	return getCallerSkipInner(n)
+}
+
+// getCallerSkipInner is called in place of errtrace.GetCallerSkip.
+//
+//go:noinline
+func getCallerSkipInner(n int) (got, want uintptr) {
+	got = GetCallerSkip(n)
+
+	var callers [1]uintptr
+	runtime.Callers(2+n, callers[:]) // skip runtime.Callers, getCallerSkipInner
+	return got, callers[0]
+}
+
 func BenchmarkGetCaller(b *testing.B) {
 	err := errors.New("test")
 
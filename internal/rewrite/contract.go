@@ -0,0 +1,201 @@
+package rewrite
+
+import (
+	"go/ast"
+	"go/token"
+	"go/types"
+	"slices"
+)
+
+// contract describes functions whose callers
+// expect them to return certain errors as-is,
+// e.g. io.EOF from io.Reader.Read.
+//
+// Inside these functions, errtrace only wraps errors
+// that are created in the return statement (see isNewError),
+// since any other error might be one that the caller compares with ==.
+//
+// Is and As methods are part of the errors contract too,
+// but they return bool, so there's nothing to leave unwrapped.
+// Unwrap methods are handled in funcType.
+type contract struct {
+	Name    string   // e.g. "io.Reader"
+	Method  string   // method name, or "" for any function
+	Params  []string // parameter types, see typeName
+	Results []string // result types, see typeName
+}
+
+var _contracts = []contract{
+	{
+		Name:    "io.Reader",
+		Method:  "Read",
+		Params:  []string{"[]byte"},
+		Results: []string{"int", "error"},
+	},
+	{
+		Name:    "io.Writer",
+		Method:  "Write",
+		Params:  []string{"[]byte"},
+		Results: []string{"int", "error"},
+	},
+	{
+		Name:    "fs.WalkDirFunc",
+		Params:  []string{"string", "io/fs.DirEntry", "error"},
+		Results: []string{"error"},
+	},
+	{
+		// os.DirEntry is an alias of fs.DirEntry.
+		Name:    "fs.WalkDirFunc",
+		Params:  []string{"string", "os.DirEntry", "error"},
+		Results: []string{"error"},
+	},
+	{
+		Name:    "filepath.WalkFunc",
+		Params:  []string{"string", "io/fs.FileInfo", "error"},
+		Results: []string{"error"},
+	},
+	{
+		// os.FileInfo is an alias of fs.FileInfo.
+		Name:    "filepath.WalkFunc",
+		Params:  []string{"string", "os.FileInfo", "error"},
+		Results: []string{"error"},
+	},
+}
+
+var _byteSliceType = types.NewSlice(types.Typ[types.Byte])
+
+// contractOf returns the contract that the function implements, if any.
+// parent is the *ast.FuncDecl or *ast.FuncLit for ft.
+func (t *walker) contractOf(parent ast.Node, ft *ast.FuncType) *contract {
+	var method string
+	if decl, ok := parent.(*ast.FuncDecl); ok && decl.Recv != nil {
+		method = decl.Name.Name
+	}
+
+	var params, results []string
+	for i := range _contracts {
+		c := &_contracts[i]
+		if c.Method != "" && c.Method != method {
+			continue
+		}
+
+		if params == nil {
+			params = t.fieldTypes(ft.Params)
+			results = t.fieldTypes(ft.Results)
+		}
+		if slices.Equal(c.Params, params) && slices.Equal(c.Results, results) {
+			return c
+		}
+	}
+	return nil
+}
+
+// fieldTypes returns the names of the types in a parameter or result list,
+// repeating types shared by multiple names.
+func (t *walker) fieldTypes(fields *ast.FieldList) []string {
+	var names []string
+	if fields == nil {
+		return names
+	}
+	for _, field := range fields.List {
+		name := t.typeName(field.Type)
+		for i := 0; i < max(1, len(field.Names)); i++ {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// typeName returns the name of a type for matching against contracts,
+// e.g. "error", "[]byte", or "io/fs.DirEntry".
+// It returns "" for types it doesn't recognize.
+//
+// With type information, aliases of error and []byte are matched too.
+// Without it, types must be spelled as in the contract,
+// e.g. 'fs.DirEntry' with an import of "io/fs".
+func (t *walker) typeName(typ ast.Expr) string {
+	if t.info != nil {
+		if tv, ok := t.info.Types[typ]; ok {
+			switch {
+			case types.Identical(tv.Type, _errorType):
+				return "error"
+			case types.Identical(tv.Type, _byteSliceType):
+				return "[]byte"
+			}
+			return types.TypeString(tv.Type, nil)
+		}
+	}
+
+	switch typ := typ.(type) {
+	case *ast.Ident:
+		return typ.Name
+	case *ast.ArrayType:
+		if typ.Len == nil {
+			if elem := t.typeName(typ.Elt); elem != "" {
+				return "[]" + elem
+			}
+		}
+	case *ast.SelectorExpr:
+		if name, ok := t.importedName(typ); ok {
+			return name
+		}
+	}
+	return ""
+}
+
+// keepContract reports whether the error at pos should be returned as-is
+// because the current function implements a contract,
+// and notes the decision if so.
+// expr is the returned error, or nil for naked returns.
+func (t *walker) keepContract(pos token.Pos, expr ast.Expr) bool {
+	// A directive that skips the whole function takes precedence
+	// so that it's counted as used.
+	if t.contract == nil || t.skip != nil {
+		return false
+	}
+	if expr != nil && t.isNewError(expr) {
+		return false
+	}
+
+	t.note(pos, CategoryContract, "leaving error unwrapped: function implements %v", t.contract.Name)
+	return true
+}
+
+// isNewError reports whether expr creates a new error,
+// e.g. errors.New("foo"), fmt.Errorf("foo: %w", err), or &MyError{}.
+// Callers can't compare these with == to a known error.
+func (t *walker) isNewError(expr ast.Expr) bool {
+	switch expr := unparen(expr).(type) {
+	case *ast.CompositeLit:
+		return true
+
+	case *ast.UnaryExpr:
+		_, ok := unparen(expr.X).(*ast.CompositeLit)
+		return ok && expr.Op == token.AND
+
+	case *ast.CallExpr:
+		name, ok := t.funcName(expr.Fun)
+		return ok && (name == "errors.New" || name == "fmt.Errorf")
+	}
+	return false
+}
+
+// funcName returns the name of the package-level function
+// that expr refers to in the form "import/path.Name",
+// if it's referred to with a package name.
+func (t *walker) funcName(expr ast.Expr) (string, bool) {
+	sel, ok := unparen(expr).(*ast.SelectorExpr)
+	if !ok {
+		return "", false
+	}
+
+	if t.info != nil {
+		fn, ok := t.info.Uses[sel.Sel].(*types.Func)
+		if !ok || fn.Pkg() == nil || fn.Type().(*types.Signature).Recv() != nil {
+			return "", false
+		}
+		return fn.Pkg().Path() + "." + fn.Name(), true
+	}
+
+	return t.importedName(sel)
+}
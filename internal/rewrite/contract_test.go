@@ -0,0 +1,137 @@
+package rewrite
+
+import (
+	"fmt"
+	"slices"
+	"testing"
+)
+
+func TestContracts(t *testing.T) {
+	tests := []struct {
+		name      string
+		src       string
+		wantLines []int    // lines with unwrapped errors
+		wantNotes []string // notes as "line:message"
+	}{
+		{
+			name: "reader",
+			src: `package foo
+import ("errors"; "io")
+type reader struct{ r io.Reader }
+func (r *reader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, errors.New("empty")
+	}
+	if n, err = r.r.Read(p); err != nil {
+		return
+	}
+	return r.r.Read(p)
+}`,
+			wantLines: []int{6},
+			wantNotes: []string{
+				"9:leaving error unwrapped: function implements io.Reader",
+				"11:leaving error unwrapped: function implements io.Reader",
+			},
+		},
+		{
+			name: "not a method",
+			src: `package foo
+func Read(p []byte) (int, error) {
+	return read(p)
+}`,
+			wantLines: []int{3},
+		},
+		{
+			name: "other signature",
+			src: `package foo
+type writer struct{}
+func (writer) Write(s string) (int, error) {
+	return write(s)
+}`,
+			wantLines: []int{4},
+		},
+		{
+			name: "function literal in reader",
+			src: `package foo
+import "io"
+type reader struct{ r io.Reader }
+func (r *reader) Read(p []byte) (int, error) {
+	fn := func() error {
+		return r.close()
+	}
+	return r.r.Read(p)
+}`,
+			wantLines: []int{6},
+			wantNotes: []string{
+				"8:leaving error unwrapped: function implements io.Reader",
+			},
+		},
+		{
+			name: "walk callback",
+			src: `package foo
+import ("io/fs"; "path/filepath")
+func walk(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		return visit(path, err)
+	})
+}`,
+			wantLines: []int{4},
+			wantNotes: []string{
+				"5:leaving error unwrapped: function implements fs.WalkDirFunc",
+			},
+		},
+		{
+			name: "walk callback without fs import",
+			src: `package foo
+func walk(root string) error {
+	return filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		return visit(path, err)
+	})
+}`,
+			wantLines: []int{3, 4},
+		},
+		{
+			name: "skipped function",
+			src: `package foo
+import "io"
+type reader struct{ r io.Reader }
+//errtrace:skip // counted as used
+func (r *reader) Read(p []byte) (int, error) {
+	return r.r.Read(p)
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sf, err := ParseFile("foo.go", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := Inspect(sf, Options{})
+			if len(result.Diagnostics) > 0 {
+				t.Errorf("unexpected diagnostics: %v", result.Diagnostics)
+			}
+
+			var gotLines []int
+			for _, d := range result.Unwrapped() {
+				gotLines = append(gotLines, d.Pos.Line)
+			}
+			if !slices.Equal(tt.wantLines, gotLines) {
+				t.Errorf("unwrapped errors on lines %v, want %v", gotLines, tt.wantLines)
+			}
+
+			var gotNotes []string
+			for _, d := range result.Notes {
+				if d.Category != CategoryContract {
+					t.Errorf("note %v has category %q, want %q", d, d.Category, CategoryContract)
+				}
+				gotNotes = append(gotNotes, fmt.Sprintf("%d:%s", d.Pos.Line, d.Message))
+			}
+			if !slices.Equal(tt.wantNotes, gotNotes) {
+				t.Errorf("notes = %q, want %q", gotNotes, tt.wantNotes)
+			}
+		})
+	}
+}
@@ -0,0 +1,53 @@
+package rewrite
+
+import (
+	"fmt"
+	"go/token"
+)
+
+// Diagnostic is a finding about a source file,
+// e.g. a return site that errtrace can't instrument.
+type Diagnostic struct {
+	Pos      token.Position
+	Category Category
+	Message  string
+}
+
+// String formats the diagnostic as "file:line:column:message",
+// the same format errtrace uses to log it.
+func (d Diagnostic) String() string {
+	return fmt.Sprintf("%v:%s", d.Pos, d.Message)
+}
+
+// Category classifies diagnostics
+// for machine-readable output.
+type Category string
+
+const (
+	// CategoryUnwrapped is an error that is returned
+	// without being wrapped by errtrace.
+	CategoryUnwrapped Category = "unwrapped"
+
+	// CategorySkipped is a return site that errtrace can't instrument,
+	// e.g. a function with multiple error results.
+	CategorySkipped Category = "skipped"
+
+	// CategoryConcreteError is a concrete error type (e.g. *MyError)
+	// used as a result or returned as an error.
+	// These are only reported with type information.
+	CategoryConcreteError Category = "concrete-error"
+
+	// CategoryUnusedSkip is an //errtrace:skip directive
+	// that doesn't apply to anything.
+	CategoryUnusedSkip Category = "unused-skip"
+
+	// CategorySentinel is a sentinel error that is returned as-is.
+	// These are reported in Result.Notes, not as problems.
+	CategorySentinel Category = "sentinel"
+
+	// CategoryContract is an error that is returned as-is
+	// because the function implements an interface like io.Reader
+	// whose callers compare errors with ==.
+	// These are reported in Result.Notes, not as problems.
+	CategoryContract Category = "contract"
+)
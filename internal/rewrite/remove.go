@@ -0,0 +1,306 @@
+package rewrite
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"slices"
+	"strconv"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+// Remove undoes the changes made by errtrace to a file (-remove).
+//
+// It unwraps calls to errtrace.Wrap and WrapN,
+// turns the blocks generated for -no-wrapn and -nil-check
+// back into return statements,
+// and drops assignments like 'err = errtrace.Wrap(err)'
+// that precede naked returns.
+// If the errtrace import isn't used after that, it's removed too.
+//
+// Other uses of errtrace (e.g. errtrace.New) are left as-is.
+func Remove(filename string, src []byte) ([]byte, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+
+	imp := errtraceImport(f)
+	if imp == nil {
+		return src, nil
+	}
+
+	r := remover{
+		errtracePkg: "errtrace",
+		deleted:     make(map[ast.Node]struct{}),
+	}
+	if imp.Name != nil {
+		r.errtracePkg = imp.Name.Name
+	}
+	ast.Inspect(f, r.visit)
+	src = applyEdits(fset.File(f.Pos()), src, r.edits)
+
+	// Parse the result to check if errtrace is still used.
+	fset = token.NewFileSet()
+	f, err = parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	imp = errtraceImport(f)
+
+	var used bool
+	ast.Inspect(f, func(n ast.Node) bool {
+		if sel, ok := n.(*ast.SelectorExpr); ok && isIdent(sel.X, r.errtracePkg) {
+			used = true
+		}
+		return !used
+	})
+	if used {
+		return src, nil
+	}
+
+	return applyEdits(fset.File(f.Pos()), src, []Edit{removeImport(f, imp)}), nil
+}
+
+// errtraceImport returns the non-blank import of errtrace in f, if any.
+func errtraceImport(f *ast.File) *ast.ImportSpec {
+	for _, imp := range f.Imports {
+		if path, err := strconv.Unquote(imp.Path.Value); err != nil || path != errtracePkgImport {
+			continue
+		}
+		if imp.Name != nil && (imp.Name.Name == "_" || imp.Name.Name == ".") {
+			continue
+		}
+		return imp
+	}
+	return nil
+}
+
+// removeImport returns an edit that deletes the given import.
+// It deletes everything from the end of the preceding import,
+// or up to the next one if it's the first in a group,
+// so that separators like "; " and blank lines go with it.
+func removeImport(f *ast.File, imp *ast.ImportSpec) Edit {
+	prevEnd := f.Name.End()
+	for _, decl := range f.Decls {
+		decl, ok := decl.(*ast.GenDecl)
+		if !ok || decl.Tok != token.IMPORT {
+			break
+		}
+
+		for i, spec := range decl.Specs {
+			if spec != imp {
+				continue
+			}
+
+			switch {
+			case len(decl.Specs) == 1:
+				// import "braces.dev/errtrace"
+				// import ("braces.dev/errtrace")
+				return Edit{Pos: prevEnd, End: decl.End()}
+			case i > 0:
+				// import ("foo"; "braces.dev/errtrace")
+				return Edit{Pos: decl.Specs[i-1].End(), End: spec.End()}
+			default:
+				// import ("braces.dev/errtrace"; "foo")
+				return Edit{Pos: spec.Pos(), End: decl.Specs[1].Pos()}
+			}
+		}
+
+		prevEnd = decl.End()
+	}
+
+	// Unreachable for imports found in f.Imports
+	// since imports must precede other declarations.
+	return Edit{Pos: imp.Pos(), End: imp.End()}
+}
+
+// remover finds the edits to remove errtrace from a file.
+// Edits never overlap, but they may be nested
+// inside the source that's kept by another edit.
+type remover struct {
+	errtracePkg string // name of the errtrace package
+	edits       []Edit
+
+	// Nodes deleted by an edit.
+	// They must not be visited to avoid overlapping edits.
+	deleted map[ast.Node]struct{}
+}
+
+func (r *remover) visit(n ast.Node) bool {
+	if _, ok := r.deleted[n]; ok {
+		return false
+	}
+
+	switch n := n.(type) {
+	case *ast.CallExpr:
+		if !r.isWrap(n) {
+			return true
+		}
+
+		// errtrace.Wrap(x) => x
+		arg := n.Args[0]
+		r.edits = append(r.edits,
+			Edit{Pos: n.Pos(), End: arg.Pos()},
+			Edit{Pos: arg.End(), End: n.End()},
+		)
+		ast.Inspect(arg, r.visit)
+		return false
+
+	case *ast.BlockStmt:
+		r.stmtList(n.List)
+
+	case *ast.CaseClause:
+		r.stmtList(n.Body)
+
+	case *ast.CommClause:
+		r.stmtList(n.Body)
+	}
+
+	return true
+}
+
+// stmtList looks for statements generated by errtrace in a list of statements.
+func (r *remover) stmtList(stmts []ast.Stmt) {
+	for i, stmt := range stmts {
+		switch stmt := stmt.(type) {
+		case *ast.BlockStmt:
+			rhs, ok := r.returnBlock(stmt)
+			if !ok {
+				continue
+			}
+
+			// { r1, r2 := x, y; return r1, errtrace.Wrap(r2) } => return x, y
+			r.edits = append(r.edits,
+				Edit{Pos: stmt.Lbrace, End: rhs[0].Pos(), NewText: "return "},
+				Edit{Pos: rhs[len(rhs)-1].End(), End: stmt.Rbrace + 1},
+			)
+			r.deleted[stmt] = struct{}{}
+			for _, expr := range rhs {
+				ast.Inspect(expr, r.visit)
+			}
+
+		case *ast.AssignStmt:
+			if !r.isWrapInPlace(stmt) {
+				continue
+			}
+
+			// err = errtrace.Wrap(err); return => return
+			end := stmt.End()
+			if i+1 < len(stmts) {
+				end = stmts[i+1].Pos()
+			}
+			r.edits = append(r.edits, Edit{Pos: stmt.Pos(), End: end})
+			r.deleted[stmt] = struct{}{}
+		}
+	}
+}
+
+// isWrapInPlace reports whether assign is in the form:
+//
+//	x, y = errtrace.Wrap(x), errtrace.Wrap(y)
+func (r *remover) isWrapInPlace(assign *ast.AssignStmt) bool {
+	if assign.Tok != token.ASSIGN || len(assign.Lhs) != len(assign.Rhs) {
+		return false
+	}
+
+	for i, lhs := range assign.Lhs {
+		name, ok := lhs.(*ast.Ident)
+		if !ok {
+			return false
+		}
+
+		call, ok := assign.Rhs[i].(*ast.CallExpr)
+		if !ok || !r.isWrap(call) || !isIdent(call.Args[0], name.Name) {
+			return false
+		}
+	}
+	return true
+}
+
+// returnBlock reports whether b is a block generated by errtrace
+// for a return statement, and if so, returns the values it returns.
+// This matches the blocks generated for -no-wrapn:
+//
+//	{ r1, r2 := x, y; return r1, errtrace.Wrap(r2) }
+//
+// And for -nil-check:
+//
+//	{ r1, r2 := x, y; if r2 == nil { return r1, r2 }; return r1, errtrace.Wrap(r2) }
+func (r *remover) returnBlock(b *ast.BlockStmt) (rhs []ast.Expr, ok bool) {
+	if n := len(b.List); n != 2 && n != 3 {
+		return nil, false
+	}
+
+	assign, ok := b.List[0].(*ast.AssignStmt)
+	if !ok || assign.Tok != token.DEFINE {
+		return nil, false
+	}
+	vars := nVars("r", len(assign.Lhs))
+	for i, lhs := range assign.Lhs {
+		if !isIdent(lhs, vars[i]) {
+			return nil, false
+		}
+	}
+
+	ret, ok := b.List[len(b.List)-1].(*ast.ReturnStmt)
+	if !ok || len(ret.Results) != len(vars) {
+		return nil, false
+	}
+	var wrapped bool
+	for i, result := range ret.Results {
+		if call, ok := result.(*ast.CallExpr); ok && r.isWrap(call) {
+			result = call.Args[0]
+			wrapped = true
+		}
+		if !isIdent(result, vars[i]) {
+			return nil, false
+		}
+	}
+	if !wrapped {
+		return nil, false
+	}
+
+	if len(b.List) == 3 {
+		// if rN == nil { return ... }
+		ifStmt, ok := b.List[1].(*ast.IfStmt)
+		if !ok || ifStmt.Init != nil || ifStmt.Else != nil || len(ifStmt.Body.List) != 1 {
+			return nil, false
+		}
+		cond, ok := ifStmt.Cond.(*ast.BinaryExpr)
+		if !ok || cond.Op != token.EQL || !isIdent(cond.Y, "nil") || !slices.Contains(vars, identName(cond.X)) {
+			return nil, false
+		}
+		if _, ok := ifStmt.Body.List[0].(*ast.ReturnStmt); !ok {
+			return nil, false
+		}
+	}
+
+	return assign.Rhs, true
+}
+
+// isWrap reports whether call is in the form errtrace.Wrap(x)
+// or errtrace.WrapN(x).
+func (r *remover) isWrap(call *ast.CallExpr) bool {
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok || !isIdent(sel.X, r.errtracePkg) || len(call.Args) != 1 || call.Ellipsis.IsValid() {
+		return false
+	}
+
+	name := sel.Sel.Name
+	if name == "Wrap" {
+		return true
+	}
+	n, err := strconv.Atoi(strings.TrimPrefix(name, "Wrap"))
+	return strings.HasPrefix(name, "Wrap") && err == nil && n >= 2 && n <= 6
+}
+
+func identName(expr ast.Expr) string {
+	if ident, ok := expr.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
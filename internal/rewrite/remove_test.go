@@ -0,0 +1,204 @@
+package rewrite
+
+import (
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace/internal/diff"
+)
+
+// TestRemove tests removal from formatted code.
+// Unformatted output of errtrace is covered by TestGolden.
+func TestRemove(t *testing.T) {
+	tests := []struct {
+		name string
+		give []string
+		want []string // defaults to give
+	}{
+		{
+			name: "not imported",
+			give: []string{
+				"package foo",
+				"",
+				"func foo() error {",
+				"	return errtrace.Wrap(bar())",
+				"}",
+			},
+		},
+		{
+			name: "import group",
+			give: []string{
+				"package foo",
+				"",
+				"import (",
+				`	"errors"`,
+				"",
+				`	"braces.dev/errtrace"`,
+				")",
+				"",
+				"func foo() error {",
+				`	return errtrace.Wrap(errors.New("foo"))`,
+				"}",
+			},
+			want: []string{
+				"package foo",
+				"",
+				"import (",
+				`	"errors"`,
+				")",
+				"",
+				"func foo() error {",
+				`	return errors.New("foo")`,
+				"}",
+			},
+		},
+		{
+			name: "first in import group",
+			give: []string{
+				"package foo",
+				"",
+				"import (",
+				`	"braces.dev/errtrace"`,
+				`	"example.com/bar"`,
+				")",
+				"",
+				"func foo() (int, error) {",
+				"	return errtrace.Wrap2(bar.Baz())",
+				"}",
+			},
+			want: []string{
+				"package foo",
+				"",
+				"import (",
+				`	"example.com/bar"`,
+				")",
+				"",
+				"func foo() (int, error) {",
+				"	return bar.Baz()",
+				"}",
+			},
+		},
+		{
+			name: "still used",
+			give: []string{
+				"package foo",
+				"",
+				`import et "braces.dev/errtrace"`,
+				"",
+				"func foo() error {",
+				"	if bar() {",
+				`		return et.New("foo")`,
+				"	}",
+				"	return et.Wrap(baz())",
+				"}",
+			},
+			want: []string{
+				"package foo",
+				"",
+				`import et "braces.dev/errtrace"`,
+				"",
+				"func foo() error {",
+				"	if bar() {",
+				`		return et.New("foo")`,
+				"	}",
+				"	return baz()",
+				"}",
+			},
+		},
+		{
+			name: "naked return",
+			give: []string{
+				"package foo",
+				"",
+				`import "braces.dev/errtrace"`,
+				"",
+				"func foo() (x int, err error) {",
+				"	x, err = bar()",
+				"	switch {",
+				"	case x > 0:",
+				"		err = errtrace.Wrap(err)",
+				"		return",
+				"	}",
+				"	x, err = errtrace.Wrap(x), errtrace.Wrap(err)",
+				"	return",
+				"}",
+			},
+			want: []string{
+				"package foo",
+				"",
+				"func foo() (x int, err error) {",
+				"	x, err = bar()",
+				"	switch {",
+				"	case x > 0:",
+				"		return",
+				"	}",
+				"	return",
+				"}",
+			},
+		},
+		{
+			name: "return blocks",
+			give: []string{
+				"package foo",
+				"",
+				`import "braces.dev/errtrace"`,
+				"",
+				"func foo() (int, error) {",
+				"	if bar() {",
+				"		r1, r2 := baz()",
+				"		return r1, errtrace.Wrap(r2)",
+				"	}",
+				"	{",
+				"		r1, r2 := baz()",
+				"		if r2 == nil {",
+				"			return r1, nil",
+				"		}",
+				"		return r1, errtrace.Wrap(r2)",
+				"	}",
+				"}",
+			},
+			want: []string{
+				"package foo",
+				"",
+				"func foo() (int, error) {",
+				"	if bar() {",
+				"		r1, r2 := baz()",
+				"		return r1, r2",
+				"	}",
+				"	return baz()",
+				"}",
+			},
+		},
+		{
+			name: "blank import",
+			give: []string{
+				"package foo",
+				"",
+				`import _ "braces.dev/errtrace"`,
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			give := strings.Join(tt.give, "\n") + "\n"
+			want := give
+			if tt.want != nil {
+				want = strings.Join(tt.want, "\n") + "\n"
+			}
+
+			got, err := Remove("foo.go", []byte(give))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got := string(got); got != want {
+				t.Errorf("want:\n%s\ngot:\n%s\ndiff:\n%s", indent(want), indent(got), indent(diff.Lines(want, got)))
+			}
+		})
+	}
+}
+
+func indent(s string) string {
+	return "\t" + strings.ReplaceAll(s, "\n", "\n\t")
+}
@@ -0,0 +1,528 @@
+// Package rewrite implements the source transformations made by errtrace:
+// instrumenting functions that return errors with errtrace.Wrap,
+// and removing that instrumentation.
+//
+// It's shared by cmd/errtrace and the go/analysis Analyzer.
+package rewrite
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+
+	"braces.dev/errtrace"
+)
+
+const errtracePkgImport = "braces.dev/errtrace"
+
+// File is a parsed Go source file.
+type File struct {
+	Src    []byte
+	Fset   *token.FileSet
+	Syntax *ast.File
+
+	// Type information for the file.
+	// These are nil unless the file was type-checked.
+	Pkg  *types.Package
+	Info *types.Info
+}
+
+// ParseFile parses a Go source file without type information.
+func ParseFile(filename string, src []byte) (*File, error) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, filename, src, parser.ParseComments)
+	if err != nil {
+		return nil, errtrace.Wrap(err)
+	}
+	return &File{Src: src, Fset: fset, Syntax: f}, nil
+}
+
+// Options controls how errors are wrapped.
+type Options struct {
+	// NoWrapN wraps multiple return values without using errtrace.WrapN.
+	NoWrapN bool
+
+	// NilCheck checks concrete error values for nil before wrapping them.
+	// This requires type information.
+	NilCheck bool
+
+	// Sentinels lists errors that are returned as-is
+	// because callers compare them with ==,
+	// in the form "import/path.Name", e.g. "io.EOF".
+	// These are in addition to DefaultSentinels.
+	Sentinels []string
+
+	// NoDefaultSentinels wraps the errors in DefaultSentinels
+	// unless they're also listed in Sentinels.
+	NoDefaultSentinels bool
+
+	// PkgPath is the import path of the package the file belongs to.
+	// Without type information, it's used to match sentinels
+	// that are declared in the same package.
+	// Optional.
+	PkgPath string
+}
+
+// DefaultSentinels lists errors from the standard library
+// that are returned as-is unless Options.NoDefaultSentinels is set.
+// Callers compare them with == instead of errors.Is,
+// so wrapping them breaks functions like io.ReadAll and filepath.WalkDir.
+var DefaultSentinels = []string{
+	"database/sql.ErrNoRows",
+	"io.EOF",
+	"io.ErrUnexpectedEOF",
+	"io/fs.SkipAll",
+	"io/fs.SkipDir",
+	"net/http.ErrUseLastResponse",
+	"path/filepath.SkipAll",
+	"path/filepath.SkipDir",
+}
+
+// Result holds the changes to make to a file,
+// and the problems found in it.
+type Result struct {
+	src  []byte
+	fset *token.FileSet
+	file *ast.File
+
+	errtracePkg     string
+	importsErrtrace bool // includes blank imports
+	changes         [][]insert
+	importErrtrace  *insertImportErrtrace // nil if not needed
+	inserts         []insert              // all inserts, sorted by position
+
+	// Diagnostics holds problems found in the file, sorted by position.
+	// It doesn't include errors that will be wrapped; see Unwrapped.
+	Diagnostics []Diagnostic
+
+	// Notes holds decisions made about the file that aren't problems,
+	// e.g. sentinel errors left unwrapped, sorted by position.
+	Notes []Diagnostic
+
+	// UnsafePrefix is used instead of "errtrace." to call Wrap if set,
+	// and "unsafe" is imported instead of errtrace.
+	//
+	// Used for toolexec unsafe mode, when rewriting packages that don't import
+	// errtrace, so we use go:linkname wrappers.
+	UnsafePrefix string
+}
+
+// Inspect finds the changes to make to the given file.
+func Inspect(sf *File, opts Options) *Result {
+	fset, f := sf.Fset, sf.Syntax
+
+	errtracePkg := "errtrace"  // name to use for errtrace package
+	var importsErrtrace bool   // whether there's any errtrace import, including blank imports
+	needErrtraceImport := true // whether to add a new import.
+	for _, imp := range f.Imports {
+		if imp.Path.Value == `"`+errtracePkgImport+`"` {
+			importsErrtrace = true
+			if imp.Name != nil {
+				if imp.Name.Name == "_" {
+					// Can't use a blank import, keep processing imports.
+					continue
+				}
+				// If the file already imports errtrace,
+				// we'll want to use the name it's imported under.
+				errtracePkg = imp.Name.Name
+			}
+			needErrtraceImport = false
+			break
+		}
+	}
+
+	if needErrtraceImport {
+		// If the file doesn't import errtrace already,
+		// do a quick check to find an unused identifier name.
+		idents := make(map[string]struct{})
+		ast.Inspect(f, func(n ast.Node) bool {
+			if ident, ok := n.(*ast.Ident); ok {
+				idents[ident.Name] = struct{}{}
+			}
+			return true
+		})
+
+		// Pick a name that isn't already used.
+		// Prefer "errtrace" if it's available.
+		for i := 1; ; i++ {
+			candidate := errtracePkg
+			if i > 1 {
+				candidate += strconv.Itoa(i)
+			}
+
+			if _, ok := idents[candidate]; !ok {
+				errtracePkg = candidate
+				break
+			}
+		}
+	}
+
+	var (
+		changes     [][]insert
+		diagnostics []Diagnostic
+		notes       []Diagnostic
+	)
+	fileSkip := fileDirective(f)
+	funcSkips := funcDirectives(fset, f)
+	w := walker{
+		fset:        fset,
+		optouts:     optoutLines(fset, f.Comments),
+		funcSkips:   funcSkips,
+		skip:        fileSkip,
+		errtracePkg: errtracePkg,
+		changes:     &changes,
+		diagnostics: &diagnostics,
+		notes:       &notes,
+		opts:        opts,
+		info:        sf.Info,
+		fileScope:   f.Scope,
+		imports:     importNames(f),
+		sentinels:   sentinelSet(opts),
+	}
+	if sf.Pkg != nil {
+		w.qualifier = types.RelativeTo(sf.Pkg)
+	}
+	ast.Walk(&w, f)
+
+	// Look for unused optouts and warn about them.
+	funcSkipComments := make(map[*ast.Comment]*directive)
+	for _, d := range funcSkips {
+		funcSkipComments[d.Comment] = d
+	}
+	for _, cg := range f.Comments {
+		if len(cg.List) > 1 {
+			continue // see optoutLines
+		}
+
+		c := cg.List[0]
+		if _, ok := funcSkipComments[c]; ok {
+			continue // reported below
+		}
+
+		pos := fset.Position(c.Pos())
+		if used, ok := w.optouts[pos.Line]; ok && used == 0 && _errtraceSkip.MatchString(c.Text) {
+			diagnostics = append(diagnostics, Diagnostic{
+				Pos:      pos,
+				Category: CategoryUnusedSkip,
+				Message:  "unused errtrace:skip",
+			})
+		}
+	}
+	for _, d := range funcSkipComments {
+		if d.Uses == 0 {
+			diagnostics = append(diagnostics, Diagnostic{
+				Pos:      fset.Position(d.Comment.Pos()),
+				Category: CategoryUnusedSkip,
+				Message:  "unused errtrace:skip",
+			})
+		}
+	}
+	if fileSkip != nil && fileSkip.Uses == 0 {
+		diagnostics = append(diagnostics, Diagnostic{
+			Pos:      fset.Position(fileSkip.Comment.Pos()),
+			Category: CategoryUnusedSkip,
+			Message:  "unused errtrace:skipfile",
+		})
+	}
+	sort.SliceStable(diagnostics, func(i, j int) bool {
+		return diagnostics[i].Pos.Offset < diagnostics[j].Pos.Offset
+	})
+
+	// If errtrace isn't imported, but at least one insert was made,
+	// we'll need to import errtrace.
+	// Add an import declaration to the file.
+	var importErrtrace *insertImportErrtrace
+	if needErrtraceImport && len(changes) > 0 {
+		// We want to insert the import after the last existing import.
+		// If the last import is part of a group, we'll make it part of the group.
+		//
+		//	import (
+		//		"foo"
+		//	)
+		//	// becomes
+		//	import (
+		//		"foo"; "brace.dev/errtrace"
+		//	)
+		//
+		// Otherwise, we'll add a new import statement group.
+		//
+		//	import "foo"
+		//	// becomes
+		//	import "foo"; import "brace.dev/errtrace"
+		var (
+			lastImportSpec *ast.ImportSpec
+			lastImportDecl *ast.GenDecl
+		)
+		for _, imp := range f.Decls {
+			decl, ok := imp.(*ast.GenDecl)
+			if !ok || decl.Tok != token.IMPORT {
+				break
+			}
+			lastImportDecl = decl
+			if decl.Lparen.IsValid() && len(decl.Specs) > 0 {
+				// There's an import group.
+				lastImportSpec, _ = decl.Specs[len(decl.Specs)-1].(*ast.ImportSpec)
+			}
+		}
+
+		var i insertImportErrtrace
+		switch {
+		case lastImportSpec != nil:
+			// import ("foo")
+			i.At = lastImportSpec.End()
+		case lastImportDecl != nil:
+			// import "foo"
+			i.At = lastImportDecl.End()
+			i.AddKeyword = true
+		default:
+			// package foo
+			i.At = f.Name.End()
+			i.AddKeyword = true
+		}
+		importErrtrace = &i
+	}
+
+	sort.SliceStable(changes, func(i, j int) bool {
+		return changes[i][0].Pos() < changes[j][0].Pos()
+	})
+
+	var inserts []insert
+	for _, c := range changes {
+		inserts = append(inserts, c...)
+	}
+	if importErrtrace != nil {
+		inserts = append(inserts, importErrtrace)
+	}
+	sort.Slice(inserts, func(i, j int) bool {
+		return inserts[i].Pos() < inserts[j].Pos()
+	})
+
+	return &Result{
+		src:             sf.Src,
+		fset:            fset,
+		file:            f,
+		errtracePkg:     errtracePkg,
+		importsErrtrace: importsErrtrace,
+		changes:         changes,
+		importErrtrace:  importErrtrace,
+		inserts:         inserts,
+		Diagnostics:     diagnostics,
+		Notes:           notes,
+	}
+}
+
+// sentinelSet returns the sentinel errors to return as-is.
+func sentinelSet(opts Options) map[string]struct{} {
+	sentinels := opts.Sentinels
+	if !opts.NoDefaultSentinels {
+		sentinels = append(slices.Clip(sentinels), DefaultSentinels...)
+	}
+	return setOf(sentinels)
+}
+
+// ImportsErrtrace reports whether the file imports errtrace,
+// including blank imports.
+func (f *Result) ImportsErrtrace() bool {
+	return f.importsErrtrace
+}
+
+// Changed reports whether the file needs to be rewritten.
+func (f *Result) Changed() bool {
+	return len(f.inserts) > 0
+}
+
+// Unwrapped returns a diagnostic for each error
+// that errtrace would wrap in this file, sorted by position.
+func (f *Result) Unwrapped() []Diagnostic {
+	diags := make([]Diagnostic, len(f.changes))
+	for i, c := range f.changes {
+		diags[i] = f.unwrapped(c)
+	}
+	return diags
+}
+
+func (f *Result) unwrapped(change []insert) Diagnostic {
+	return Diagnostic{
+		Pos:      f.fset.Position(change[0].Pos()),
+		Category: CategoryUnwrapped,
+		Message:  "error is not wrapped with errtrace",
+	}
+}
+
+// Edit replaces the source between Pos and End with NewText.
+type Edit struct {
+	Pos, End token.Pos
+	NewText  string
+}
+
+// Change is a group of edits that wraps the errors at a single site,
+// adding the errtrace import if necessary.
+type Change struct {
+	Diagnostic Diagnostic // diagnostic for the unwrapped errors
+	Edits      []Edit     // sorted by position
+}
+
+// Changes returns the changes to make to the file,
+// one for each diagnostic reported by Unwrapped.
+//
+// Changes may be applied independently of each other.
+// All changes that need the errtrace import include the same edit for it.
+func (f *Result) Changes() []Change {
+	changes := make([]Change, len(f.changes))
+	for i, inserts := range f.changes {
+		if f.importErrtrace != nil {
+			inserts = append(slices.Clip(inserts), f.importErrtrace)
+		}
+
+		edits := make([]Edit, len(inserts))
+		for j, it := range inserts {
+			edits[j] = f.edit(it)
+		}
+		sort.SliceStable(edits, func(i, j int) bool {
+			return edits[i].Pos < edits[j].Pos
+		})
+
+		changes[i] = Change{
+			Diagnostic: f.unwrapped(inserts),
+			Edits:      edits,
+		}
+	}
+	return changes
+}
+
+// Rewrite returns the source of the file with all changes applied.
+func (f *Result) Rewrite() []byte {
+	edits := make([]Edit, len(f.inserts))
+	for i, it := range f.inserts {
+		edits[i] = f.edit(it)
+	}
+	return applyEdits(f.fset.File(f.file.Pos()), f.src, edits)
+}
+
+// applyEdits applies non-overlapping edits to src.
+// Edits at the same position are applied in order.
+func applyEdits(file *token.File, src []byte, edits []Edit) []byte {
+	sort.SliceStable(edits, func(i, j int) bool {
+		return edits[i].Pos < edits[j].Pos
+	})
+
+	var out bytes.Buffer
+	var last int
+	for _, e := range edits {
+		start, end := file.Offset(e.Pos), file.Offset(e.End)
+		_, _ = out.Write(src[last:start])
+		_, _ = out.WriteString(e.NewText)
+		last = end
+	}
+	_, _ = out.Write(src[last:])
+	return out.Bytes()
+}
+
+func (f *Result) errtracePkgPrefix() string {
+	if f.UnsafePrefix == "" {
+		return fmt.Sprintf("%s.", f.errtracePkg)
+	}
+	return f.UnsafePrefix
+}
+
+// edit returns the edit that makes the given insert.
+func (f *Result) edit(it insert) Edit {
+	e := Edit{Pos: it.Pos(), End: it.Pos()}
+
+	var out strings.Builder
+	switch it := it.(type) {
+	case *insertImportErrtrace:
+		_, _ = out.WriteString("; ")
+		if it.AddKeyword {
+			_, _ = out.WriteString("import ")
+		}
+
+		if f.UnsafePrefix != "" {
+			// unsafe mode uses go:link, which requires importing "unsafe" instead
+			// of errtrace.  Since duplicate blank imports are allowed by Go, we can
+			// always add it, without checking if it has been imported.
+			fmt.Fprint(&out, `_ "unsafe"`)
+		} else if f.errtracePkg == "errtrace" {
+			// Don't use named imports if we're using the default name.
+			fmt.Fprintf(&out, "%q", errtracePkgImport)
+		} else {
+			fmt.Fprintf(&out, "%s %q", f.errtracePkg, errtracePkgImport)
+		}
+
+	case *insertWrapOpen:
+		fmt.Fprintf(&out, "%sWrap", f.errtracePkgPrefix())
+		if it.N > 1 {
+			fmt.Fprintf(&out, "%d", it.N)
+		}
+		_, _ = out.WriteString("(")
+
+	case *insertWrapClose:
+		_, _ = out.WriteString(")")
+
+	case *insertReturnNBlockStart:
+		vars := nVars("r", it.N)
+		fmt.Fprintf(&out, "{ %s := ", strings.Join(vars, ", "))
+
+		// Replace the "return", as it's followed by
+		// the expression we want to assign to.
+		// The "return" is added in insertReturnNBlockClose.
+		e.End = it.SkipReturn
+
+	case *insertReturnNBlockClose:
+		vars := nVars("r", it.N) // must match insertReturnNBlockStart
+
+		// Last return is an error, wrap it.
+		last := &vars[len(vars)-1]
+		*last = fmt.Sprintf("%sWrap(%v)", f.errtracePkgPrefix(), *last)
+
+		fmt.Fprintf(&out, "; return %s }", strings.Join(vars, ", "))
+
+	case *insertNilCheckClose:
+		vars := nVars("r", it.N) // must match insertReturnNBlockStart
+
+		wrapped := slices.Clone(vars)
+		for _, idx := range it.Wrap {
+			wrapped[idx] = fmt.Sprintf("%sWrap(%v)", f.errtracePkgPrefix(), vars[idx])
+		}
+		// A nil value is returned unchanged, not as an untyped nil,
+		// so a typed nil error stays a typed nil error.
+		ifNil := slices.Clone(wrapped)
+		ifNil[it.NilCheck] = vars[it.NilCheck]
+
+		fmt.Fprintf(&out, "; if %s == nil { return %s }; return %s }",
+			vars[it.NilCheck], strings.Join(ifNil, ", "), strings.Join(wrapped, ", "))
+
+	case *insertWrapAssign:
+		// Turns this:
+		//	return
+		// Into this:
+		//	x, y = errtrace.Wrap(x), errtrace.Wrap(y); return
+		for i, name := range it.Names {
+			if i > 0 {
+				_, _ = out.WriteString(", ")
+			}
+			fmt.Fprintf(&out, "%s", name)
+		}
+		_, _ = out.WriteString(" = ")
+		for i, name := range it.Names {
+			if i > 0 {
+				_, _ = out.WriteString(", ")
+			}
+			fmt.Fprintf(&out, "%sWrap(%s)", f.errtracePkgPrefix(), name)
+		}
+		_, _ = out.WriteString("; ")
+
+	default:
+		panic(fmt.Sprintf("unhandled insertion type %T", it))
+	}
+
+	e.NewText = out.String()
+	return e
+}
@@ -0,0 +1,252 @@
+package rewrite
+
+import (
+	"fmt"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace/internal/diff"
+)
+
+// TestChanges verifies that the edits of all changes together
+// rewrite the golden test files of cmd/errtrace the same way as Rewrite,
+// and that each change can be applied on its own.
+func TestChanges(t *testing.T) {
+	files, err := filepath.Glob("../../cmd/errtrace/testdata/golden/*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no golden files found")
+	}
+
+	for _, file := range files {
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".go"), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			sf, err := ParseFile(file, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			result := Inspect(sf, Options{})
+			tokFile := sf.Fset.File(sf.Syntax.Pos())
+
+			var all []Edit
+			seen := make(map[Edit]struct{})
+			for _, c := range result.Changes() {
+				// Each change must produce a valid file.
+				one := applyEdits(tokFile, src, slices.Clone(c.Edits))
+				if _, err := parser.ParseFile(token.NewFileSet(), file, one, 0); err != nil {
+					t.Errorf("%v: %v", c.Diagnostic, err)
+				}
+
+				// The errtrace import is shared between changes.
+				for _, e := range c.Edits {
+					if _, ok := seen[e]; !ok {
+						seen[e] = struct{}{}
+						all = append(all, e)
+					}
+				}
+			}
+
+			want := string(result.Rewrite())
+			got := string(applyEdits(tokFile, src, all))
+			if got != want {
+				t.Errorf("want:\n%s\ngot:\n%s\ndiff:\n%s", indent(want), indent(got), indent(diff.Lines(want, got)))
+			}
+		})
+	}
+}
+
+func TestSentinels(t *testing.T) {
+	tests := []struct {
+		name    string
+		src     string
+		pkgPath string
+		want    []int // lines with unwrapped errors
+	}{
+		{
+			name: "imported",
+			src: `package foo
+import ("io"; "errors")
+func foo() error {
+	return io.EOF
+}
+func bar() (int, error) {
+	return 0, (io.EOF)
+}
+func baz() error {
+	return io.ErrUnexpectedEOF
+}
+func qux() error {
+	return errors.New("qux")
+}`,
+			want: []int{10, 13},
+		},
+		{
+			name: "renamed import",
+			src: `package foo
+import myio "io"
+func foo() error {
+	return myio.EOF
+}`,
+		},
+		{
+			name: "shadowed package",
+			src: `package foo
+import "io"
+func foo(io struct{ EOF error }) error {
+	return io.EOF
+}`,
+			want: []int{4},
+		},
+		{
+			name: "same package",
+			src: `package foo
+var ErrDone = errDone()
+func foo() error {
+	return ErrDone
+}
+func bar() error {
+	return ErrOther // declared in another file
+}
+func baz(ErrDone error) error {
+	return ErrDone
+}`,
+			pkgPath: "example.com/foo",
+			want:    []int{7, 10},
+		},
+		{
+			name: "same package unknown path",
+			src: `package foo
+var ErrDone = errDone()
+func foo() error {
+	return ErrDone
+}`,
+			want: []int{4},
+		},
+		{
+			name: "versioned import",
+			src: `package foo
+import "example.com/foo/v2"
+func bar() error {
+	return foo.ErrDone
+}`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sf, err := ParseFile("foo.go", []byte(tt.src))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := Inspect(sf, Options{
+				Sentinels: []string{
+					"io.EOF",
+					"example.com/foo.ErrDone",
+					"example.com/foo/v2.ErrDone",
+				},
+				NoDefaultSentinels: true,
+				PkgPath:            tt.pkgPath,
+			})
+
+			var got []int
+			for _, d := range result.Unwrapped() {
+				got = append(got, d.Pos.Line)
+			}
+			if !slices.Equal(tt.want, got) {
+				t.Errorf("unwrapped errors on lines %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDefaultSentinels(t *testing.T) {
+	src := `package foo
+import ("io"; "io/fs"; "path/filepath"; "example.com/store")
+func foo() error {
+	return io.EOF
+}
+func bar(d fs.DirEntry) error {
+	if d.IsDir() {
+		return filepath.SkipDir
+	}
+	return fs.SkipAll
+}
+func baz() error {
+	return store.ErrNotFound
+}`
+
+	tests := []struct {
+		name        string
+		opts        Options
+		wantWrapped []int    // lines with unwrapped errors
+		wantNotes   []string // notes as "line:message"
+	}{
+		{
+			name:        "defaults",
+			wantWrapped: []int{13},
+			wantNotes: []string{
+				"4:leaving sentinel error io.EOF unwrapped",
+				"8:leaving sentinel error path/filepath.SkipDir unwrapped",
+				"10:leaving sentinel error io/fs.SkipAll unwrapped",
+			},
+		},
+		{
+			name: "extended",
+			opts: Options{Sentinels: []string{"example.com/store.ErrNotFound"}},
+			wantNotes: []string{
+				"4:leaving sentinel error io.EOF unwrapped",
+				"8:leaving sentinel error path/filepath.SkipDir unwrapped",
+				"10:leaving sentinel error io/fs.SkipAll unwrapped",
+				"13:leaving sentinel error example.com/store.ErrNotFound unwrapped",
+			},
+		},
+		{
+			name:        "no defaults",
+			opts:        Options{NoDefaultSentinels: true, Sentinels: []string{"io.EOF"}},
+			wantWrapped: []int{8, 10, 13},
+			wantNotes:   []string{"4:leaving sentinel error io.EOF unwrapped"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sf, err := ParseFile("foo.go", []byte(src))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			result := Inspect(sf, tt.opts)
+
+			var gotWrapped []int
+			for _, d := range result.Unwrapped() {
+				gotWrapped = append(gotWrapped, d.Pos.Line)
+			}
+			if !slices.Equal(tt.wantWrapped, gotWrapped) {
+				t.Errorf("unwrapped errors on lines %v, want %v", gotWrapped, tt.wantWrapped)
+			}
+
+			var gotNotes []string
+			for _, d := range result.Notes {
+				if d.Category != CategorySentinel {
+					t.Errorf("note %v has category %q, want %q", d, d.Category, CategorySentinel)
+				}
+				gotNotes = append(gotNotes, fmt.Sprintf("%d:%s", d.Pos.Line, d.Message))
+			}
+			if !slices.Equal(tt.wantNotes, gotNotes) {
+				t.Errorf("notes = %q, want %q", gotNotes, tt.wantNotes)
+			}
+		})
+	}
+}
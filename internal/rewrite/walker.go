@@ -0,0 +1,1116 @@
+package rewrite
+
+import (
+	"fmt"
+	"go/ast"
+	"go/token"
+	"go/types"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type walker struct {
+	// Inputs
+
+	fset        *token.FileSet // file set for positional information
+	errtracePkg string         // name of the errtrace package
+	opts        Options
+
+	// Type information, if the file was type-checked (-types).
+	info      *types.Info
+	qualifier types.Qualifier
+
+	optouts   map[int]int             // map from line to number of uses
+	funcSkips map[ast.Node]*directive // directives that skip whole functions
+
+	fileScope *ast.Scope          // package-level declarations of the file
+	imports   map[string]string   // import path by package name
+	sentinels map[string]struct{} // see Options.Sentinels
+
+	// Outputs
+
+	// changes is the list of changes to make.
+	// Each change is a group of inserts
+	// that wraps the errors at a single site.
+	changes *[][]insert
+
+	// diagnostics is the list of problems found in the file.
+	diagnostics *[]Diagnostic
+
+	// notes is the list of decisions that aren't problems,
+	// e.g. sentinel errors that are left unwrapped.
+	notes *[]Diagnostic
+
+	// State
+
+	// skip is the //errtrace:skip or //errtrace:skipfile directive
+	// that applies to the current function or file, if any.
+	// Changes and problems inside it are dropped.
+	skip *directive
+
+	// Function information:
+
+	contract     *contract                // contract the function implements, if any
+	numReturns   int                      // number of return values
+	errorIdents  []*ast.Ident             // identifiers for error return values (only if unnamed returns)
+	errorObjs    map[*ast.Object]struct{} // objects for error return values (only if named returns)
+	errorIndices []int                    // indices of error return values
+
+	// Block information:
+
+	// Errors that are wrapped in this block.
+	alreadyWrapped map[*ast.Object]struct{}
+	// Value known to be nil in this block,
+	// e.g. r1 in "if r1 == nil { return r1 }" (only with -types).
+	knownNil types.Object
+	// Body of the if statement being visited, if it checks nilObj for nil.
+	nilBody *ast.BlockStmt
+	nilObj  types.Object
+	// The logic to detect re-wraps is pretty simplistic
+	// since it doesn't do any control flow analysis.
+	// If this becomes a necessity, we can add it later.
+}
+
+var _ ast.Visitor = (*walker)(nil)
+
+// report records a diagnostic at the given position.
+// An //errtrace:skip directive on the same line silences it.
+func (t *walker) report(pos token.Pos, category Category, format string, args ...interface{}) {
+	if t.optout(pos) {
+		return
+	}
+	if t.skip != nil {
+		t.skip.Uses++
+		return
+	}
+
+	*t.diagnostics = append(*t.diagnostics, Diagnostic{
+		Pos:      t.fset.Position(pos),
+		Category: category,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// note records a decision made about the code at pos.
+// Unlike report, notes aren't problems,
+// so they aren't silenced by //errtrace:skip directives.
+func (t *walker) note(pos token.Pos, category Category, format string, args ...interface{}) {
+	*t.notes = append(*t.notes, Diagnostic{
+		Pos:      t.fset.Position(pos),
+		Category: category,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// change records a group of inserts
+// that wraps the errors at a single site.
+func (t *walker) change(inserts ...insert) {
+	if t.skip != nil {
+		t.skip.Uses++
+		return
+	}
+
+	*t.changes = append(*t.changes, inserts)
+}
+
+func (t *walker) Visit(n ast.Node) ast.Visitor {
+	switch n := n.(type) {
+	case *ast.FuncDecl:
+		return t.funcType(n, n.Type)
+
+	case *ast.BlockStmt:
+		newT := *t
+		newT.alreadyWrapped = make(map[*ast.Object]struct{})
+		if n == t.nilBody {
+			newT.knownNil = t.nilObj
+		}
+		newT.nilBody, newT.nilObj = nil, nil
+		return &newT
+
+	case *ast.IfStmt:
+		if obj := t.nilCheckedObj(n); obj != nil {
+			newT := *t
+			newT.nilBody, newT.nilObj = n.Body, obj
+			return &newT
+		}
+
+	case *ast.AssignStmt:
+		t.assignStmt(n)
+
+	case *ast.DeferStmt:
+		// This is a bit inefficient;
+		// we'll recurse into the DeferStmt's function literal (if any) twice.
+		t.deferStmt(n)
+
+	case *ast.FuncLit:
+		return t.funcType(n, n.Type)
+
+	case *ast.ReturnStmt:
+		return t.returnStmt(n)
+	}
+
+	return t
+}
+
+func (t *walker) funcType(parent ast.Node, ft *ast.FuncType) ast.Visitor {
+	// Clear state in case we're recursing into a function literal
+	// inside a function that returns an error.
+	newT := *t
+	newT.errorObjs = nil
+	newT.errorIdents = nil
+	newT.errorIndices = nil
+	newT.numReturns = 0
+	newT.contract = nil
+	newT.knownNil = nil
+	if d, ok := t.funcSkips[parent]; ok {
+		// Also applies to nested function literals.
+		newT.skip = d
+	}
+	t = &newT
+
+	// If the function does not return anything,
+	// we still need to recurse into any function literals.
+	// Just return this visitor to continue recursing.
+	if ft.Results == nil {
+		return t
+	}
+
+	// If the function has return values,
+	// we need to consider the following cases:
+	//
+	//   - no error return value
+	//   - unnamed error return
+	//   - named error return
+	var (
+		objs    []*ast.Object // objects of error return values
+		idents  []*ast.Ident  // identifiers of named error return values
+		indices []int         // indices of error return values
+		count   int           // total number of return values
+		// Invariants:
+		//  len(indices) <= count
+		//  len(names) == 0 || len(names) == len(indices)
+	)
+	for _, field := range ft.Results.List {
+		isError := t.isErrorResult(field.Type)
+
+		// field.Names is nil for unnamed return values.
+		// Either all returns are named or none are.
+		if len(field.Names) > 0 {
+			for _, name := range field.Names {
+				if isError {
+					objs = append(objs, name.Obj)
+					idents = append(idents, name)
+					indices = append(indices, count)
+				}
+				count++
+			}
+		} else {
+			if isError {
+				indices = append(indices, count)
+			}
+			count++
+		}
+	}
+
+	// If there are no error return values,
+	// recurse to look for function literals.
+	if len(indices) == 0 {
+		return t
+	}
+
+	// If there's a single error return,
+	// and this function is a method named "Unwrap",
+	// don't wrap it so it plays nice with errors.Unwrap.
+	if len(indices) == 1 {
+		if decl, ok := parent.(*ast.FuncDecl); ok {
+			if decl.Recv != nil && isIdent(decl.Name, "Unwrap") {
+				return t
+			}
+		}
+	}
+
+	newT.contract = t.contractOf(parent, ft)
+	newT.errorObjs = setOf(objs)
+	newT.errorIdents = idents
+	newT.errorIndices = indices
+	newT.numReturns = count
+	return &newT
+}
+
+func (t *walker) returnStmt(n *ast.ReturnStmt) ast.Visitor {
+	// Doesn't return errors. Continue recursing.
+	if len(t.errorIndices) == 0 {
+		return t
+	}
+
+	// Naked return.
+	// We want to add assignments to the named return values.
+	if n.Results == nil {
+		if t.optout(n.Pos()) || t.keepContract(n.Pos(), nil) {
+			return nil
+		}
+
+		// Ignore errors that have already been wrapped.
+		names := make([]string, 0, len(t.errorIndices))
+		for _, ident := range t.errorIdents {
+			if _, ok := t.alreadyWrapped[ident.Obj]; ok {
+				continue
+			}
+			names = append(names, ident.Name)
+		}
+
+		if len(names) > 0 {
+			t.change(&insertWrapAssign{
+				Names:  names,
+				Before: n.Pos(),
+			})
+		}
+
+		return nil
+	}
+
+	// Return with multiple return values being automatically expanded
+	// E.g.,
+	//	func foo() (int, error) {
+	//		return bar()
+	//	}
+	// This needs to become:
+	//	func foo() (int, error) {
+	//		return Wrap2(bar())
+	//	}
+	// This is only supported if numReturns <= 6 and only the last return value is an error.
+	if len(n.Results) == 1 && t.numReturns > 1 {
+		if _, ok := n.Results[0].(*ast.CallExpr); !ok {
+			t.report(n.Pos(), CategorySkipped, "skipping function with incorrect number of return values: got %d, want %d", len(n.Results), t.numReturns)
+			return t
+		}
+
+		if !t.nilCheckReturn(n) {
+			t.wrapReturnCall(t.numReturns, n)
+		}
+		return t
+	}
+
+	if t.nilCheckReturn(n) {
+		return t
+	}
+
+	for _, idx := range t.errorIndices {
+		t.wrapExpr(1, n.Results[idx])
+	}
+
+	return t
+}
+
+func (t *walker) assignStmt(n *ast.AssignStmt) {
+	// Record assignments to named error return values.
+	// We'll use this to detect re-wraps.
+	for i, lhs := range n.Lhs {
+		ident, ok := lhs.(*ast.Ident)
+		if !ok {
+			continue // not an identifier
+		}
+
+		_, ok = t.errorObjs[ident.Obj]
+		if !ok {
+			continue // not an error assignment
+		}
+
+		if i < len(n.Rhs) && t.isErrtraceWrap(n.Rhs[i]) {
+			// Assigning to a named error return value.
+			t.alreadyWrapped[ident.Obj] = struct{}{}
+		}
+	}
+}
+
+func (t *walker) deferStmt(n *ast.DeferStmt) {
+	// If there's a defer statement with a function literal,
+	// *and* this function has named return values,
+	// we'll want to watch for assignments to those return values.
+
+	if len(t.errorIdents) == 0 {
+		return // no named returns
+	}
+
+	funcLit, ok := n.Call.Fun.(*ast.FuncLit)
+	if !ok {
+		return // not a function literal
+	}
+
+	ast.Inspect(funcLit.Body, func(n ast.Node) bool {
+		assign, ok := n.(*ast.AssignStmt)
+		if !ok {
+			return true
+		}
+		for i, lhs := range assign.Lhs {
+			ident, ok := lhs.(*ast.Ident)
+			if !ok {
+				continue // not an identifier
+			}
+
+			if _, ok := t.errorObjs[ident.Obj]; !ok {
+				continue // not an error assignment
+			}
+
+			// Assignment to an error return value.
+			// This will take one of the following forms:
+			//
+			//  (1) x, y, err = f1(), f2(), f3()
+			//  (2) x, y, err = f() // returns multiple values
+			//  (3) x, err, z = f() // returns multiple values
+			//
+			// For (1), we can wrap just the function
+			// that returns the error. (f3 in this case)
+			//
+			// For (2), we can use a WrapN function
+			// to wrap the entire function call.
+			//
+			// For (3), we could use an inline function call,
+			// but that's not implemented yet.
+
+			if i < len(assign.Rhs) && len(assign.Lhs) == len(assign.Rhs) {
+				// Case (1):
+				// Wrap the function that returns the error.
+				t.wrapExpr(1, assign.Rhs[i])
+			} else if i == len(assign.Lhs)-1 && len(assign.Rhs) == 1 {
+				// Case (2):
+				// Wrap the entire function call.
+				t.wrapExpr(len(assign.Lhs), assign.Rhs[0])
+			} else {
+				t.report(assign.Pos(), CategorySkipped, "skipping assignment: error is not the last return value")
+			}
+		}
+
+		return true
+	})
+}
+
+func (t *walker) wrapReturnCall(n int, ret *ast.ReturnStmt) {
+	// Common validation
+	switch {
+	case len(t.errorIndices) != 1:
+		t.report(ret.Pos(), CategorySkipped, "skipping function with multiple error returns")
+		return
+	case t.errorIndices[0] != t.numReturns-1:
+		t.report(ret.Pos(), CategorySkipped, "skipping function with non-final error return")
+		return
+	case t.isErrtraceWrap(ret.Results[0]):
+		return
+	case t.optout(ret.Pos()):
+		return
+	case t.keepContract(ret.Results[0].Pos(), ret.Results[0]):
+		return
+	}
+
+	if t.opts.NoWrapN {
+		t.change(
+			&insertReturnNBlockStart{N: n, Before: ret.Pos(), SkipReturn: ret.Results[0].Pos()},
+			&insertReturnNBlockClose{N: n, After: ret.End()},
+		)
+		return
+	}
+
+	if n > 6 {
+		t.report(ret.Pos(), CategorySkipped, "skipping function with too many return values")
+		return
+	}
+
+	t.wrapExpr(n, ret.Results[0])
+}
+
+func (t *walker) wrapExpr(n int, expr ast.Expr) {
+	switch {
+	case t.isErrtraceWrap(expr):
+		return // already wrapped
+
+	case t.isNil(expr), t.isKnownNil(expr):
+		// Optimization: ignore if it's "nil".
+		return
+
+	case t.optout(expr.Pos()):
+		return
+
+	case t.keepSentinel(expr):
+		return
+
+	case t.keepContract(expr.Pos(), expr):
+		return
+	}
+
+	t.change(
+		&insertWrapOpen{N: n, Before: expr.Pos()},
+		&insertWrapClose{After: expr.End()},
+	)
+}
+
+// isErrorResult reports whether a result of the given type
+// is an error that should be wrapped.
+//
+// Without type information, this only matches results spelled 'error'.
+// With type information, it also matches aliases of error,
+// and named interfaces that errtrace.Wrap's result can be assigned to.
+// Other types that implement error are reported and skipped.
+func (t *walker) isErrorResult(typ ast.Expr) bool {
+	if t.info == nil {
+		return isIdent(typ, "error")
+	}
+
+	tv, ok := t.info.Types[typ]
+	if !ok || !types.Implements(tv.Type, _errorInterface) {
+		// Also covers files that declare their own 'error' type.
+		return false
+	}
+
+	switch {
+	case isTypeParam(tv.Type):
+		// func foo[E error]() E
+		t.report(typ.Pos(), CategorySkipped, "skipping %v result: type parameters can't hold wrapped errors",
+			types.TypeString(tv.Type, t.qualifier))
+		return false
+
+	case !types.IsInterface(tv.Type):
+		// func foo() *MyError
+		t.report(typ.Pos(), CategoryConcreteError, "skipping %v result: concrete error types can't hold wrapped errors",
+			types.TypeString(tv.Type, t.qualifier))
+		return false
+
+	case !types.AssignableTo(_errorType, tv.Type):
+		// Interfaces that embed error and add other methods:
+		//	type TemporaryError interface { error; Temporary() bool }
+		t.report(typ.Pos(), CategorySkipped, "skipping %v result: errtrace.Wrap returns error",
+			types.TypeString(tv.Type, t.qualifier))
+		return false
+	}
+
+	return true
+}
+
+// nilCheckReturn reports concrete error values (e.g. *MyError)
+// that are returned as errors by the given return statement.
+// A nil *MyError returned as an error is not a nil error.
+//
+// With -nil-check, it rewrites the statement to check the value for nil
+// before wrapping it, and reports whether it did so.
+// A nil value is returned unchanged so the function's behavior doesn't change:
+// a nil *MyError is still returned as a non-nil error.
+// Without -nil-check, the value is wrapped as usual.
+func (t *walker) nilCheckReturn(ret *ast.ReturnStmt) bool {
+	if t.info == nil {
+		return false
+	}
+
+	// Types of the returned values,
+	// expanding a call that returns multiple values.
+	var results []types.Type
+	if len(ret.Results) == t.numReturns {
+		for _, expr := range ret.Results {
+			results = append(results, t.info.TypeOf(expr))
+		}
+	} else if tuple, ok := t.info.TypeOf(ret.Results[0]).(*types.Tuple); ok && tuple.Len() == t.numReturns {
+		for i := 0; i < tuple.Len(); i++ {
+			results = append(results, tuple.At(i).Type())
+		}
+	} else {
+		return false
+	}
+
+	nilCheck := -1      // index of the concrete error value
+	var wrap []int      // indices of error values to wrap
+	var keep []ast.Expr // sentinel errors returned as-is
+	for _, idx := range t.errorIndices {
+		typ := results[idx]
+		if !isNilableError(typ) {
+			if len(results) != len(ret.Results) {
+				continue
+			}
+			switch expr := ret.Results[idx]; {
+			case t.isNil(expr), t.isErrtraceWrap(expr):
+				// leave as-is
+			case t.isSentinel(expr):
+				keep = append(keep, expr)
+			default:
+				wrap = append(wrap, idx)
+			}
+			continue
+		}
+
+		if len(results) == len(ret.Results) && t.isKnownNil(ret.Results[idx]) {
+			// Already checked for nil, e.g. by an earlier -nil-check.
+			continue
+		}
+
+		if !t.opts.NilCheck {
+			typ := types.TypeString(typ, t.qualifier)
+			t.report(ret.Pos(), CategoryConcreteError, "returning %v as error: a nil %v is not a nil error (use -nil-check)", typ, typ)
+			continue
+		}
+
+		if nilCheck >= 0 {
+			t.report(ret.Pos(), CategorySkipped, "skipping nil check: multiple concrete error values")
+			return false
+		}
+		nilCheck = idx
+		wrap = append(wrap, idx)
+	}
+
+	if nilCheck < 0 {
+		return false
+	}
+	if t.optout(ret.Pos()) {
+		return true
+	}
+
+	for _, expr := range keep {
+		t.keepSentinel(expr)
+	}
+	t.change(
+		&insertReturnNBlockStart{N: t.numReturns, Before: ret.Pos(), SkipReturn: ret.Results[0].Pos()},
+		&insertNilCheckClose{N: t.numReturns, After: ret.End(), Wrap: wrap, NilCheck: nilCheck},
+	)
+	return true
+}
+
+// nilCheckedObj returns the variable that n checks for nil
+// if n is in the form "if x == nil { return ... }",
+// the form generated by -nil-check.
+// The body can't assign to x before it's returned.
+func (t *walker) nilCheckedObj(n *ast.IfStmt) types.Object {
+	if t.info == nil || n.Init != nil || len(n.Body.List) != 1 {
+		return nil
+	}
+	if _, ok := n.Body.List[0].(*ast.ReturnStmt); !ok {
+		return nil
+	}
+	bin, ok := n.Cond.(*ast.BinaryExpr)
+	if !ok || bin.Op != token.EQL || !t.isNil(bin.Y) {
+		return nil
+	}
+	ident, ok := bin.X.(*ast.Ident)
+	if !ok {
+		return nil
+	}
+	return t.info.Uses[ident]
+}
+
+// isKnownNil reports whether expr is a variable known to be nil,
+// e.g. r1 in "if r1 == nil { return r1 }".
+func (t *walker) isKnownNil(expr ast.Expr) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && t.knownNil != nil && t.info.Uses[ident] == t.knownNil
+}
+
+// isNil reports whether expr is the predeclared nil.
+func (t *walker) isNil(expr ast.Expr) bool {
+	if t.info == nil {
+		return isIdent(expr, "nil")
+	}
+	return t.info.Types[expr].IsNil()
+}
+
+var (
+	_errorType      = types.Universe.Lookup("error").Type()
+	_errorInterface = _errorType.Underlying().(*types.Interface)
+)
+
+// isNilableError reports whether typ is a concrete error type
+// that can be nil, e.g. *MyError.
+func isNilableError(typ types.Type) bool {
+	if typ == nil || types.IsInterface(typ) || !types.Implements(typ, _errorInterface) {
+		return false
+	}
+
+	switch typ.Underlying().(type) {
+	case *types.Pointer, *types.Slice, *types.Map, *types.Chan, *types.Signature:
+		return true
+	default:
+		return false
+	}
+}
+
+func isTypeParam(typ types.Type) bool {
+	_, ok := typ.(*types.TypeParam)
+	return ok
+}
+
+// Detects if an expression is in the form errtrace.Wrap(e) or errtrace.Wrap{n}(e).
+func (t *walker) isErrtraceWrap(expr ast.Expr) bool {
+	call, ok := expr.(*ast.CallExpr)
+	if !ok {
+		return false
+	}
+
+	// Ignore if it's already errtrace.Wrap(...).
+	sel, ok := call.Fun.(*ast.SelectorExpr)
+	if !ok {
+		return false
+	}
+
+	if !isIdent(sel.X, t.errtracePkg) {
+		return false
+	}
+
+	return strings.HasPrefix(sel.Sel.Name, "Wrap") ||
+		sel.Sel.Name == "New" ||
+		sel.Sel.Name == "Errorf"
+}
+
+// keepSentinel reports whether expr refers to one of the sentinel errors
+// that should be returned as-is, and notes the decision if so.
+func (t *walker) keepSentinel(expr ast.Expr) bool {
+	name, ok := t.sentinelName(expr)
+	if ok {
+		t.note(expr.Pos(), CategorySentinel, "leaving sentinel error %v unwrapped", name)
+	}
+	return ok
+}
+
+// isSentinel reports whether expr refers to one of the sentinel errors
+// that should be returned as-is.
+func (t *walker) isSentinel(expr ast.Expr) bool {
+	_, ok := t.sentinelName(expr)
+	return ok
+}
+
+// sentinelName returns the qualified name of the sentinel error
+// that expr refers to, if any.
+func (t *walker) sentinelName(expr ast.Expr) (string, bool) {
+	// A directive that skips the whole function takes precedence
+	// so that it's counted as used.
+	if len(t.sentinels) == 0 || t.skip != nil {
+		return "", false
+	}
+
+	name, ok := t.qualifiedName(expr)
+	if !ok {
+		return "", false
+	}
+	_, ok = t.sentinels[name]
+	return name, ok
+}
+
+// qualifiedName returns the name of the package-level variable
+// that expr refers to in the form "import/path.Name".
+func (t *walker) qualifiedName(expr ast.Expr) (string, bool) {
+	expr = unparen(expr)
+	if t.info != nil {
+		var ident *ast.Ident
+		switch expr := expr.(type) {
+		case *ast.Ident:
+			ident = expr
+		case *ast.SelectorExpr:
+			ident = expr.Sel
+		default:
+			return "", false
+		}
+
+		v, ok := t.info.Uses[ident].(*types.Var)
+		if !ok || v.Pkg() == nil || v.Parent() != v.Pkg().Scope() {
+			return "", false
+		}
+		return v.Pkg().Path() + "." + v.Name(), true
+	}
+
+	switch expr := expr.(type) {
+	case *ast.Ident:
+		// Identifiers declared in other files of the package aren't resolved.
+		// Anything else must be declared at the top level of this file.
+		if t.opts.PkgPath == "" || (expr.Obj != nil && t.fileScope.Lookup(expr.Name) != expr.Obj) {
+			return "", false
+		}
+		return t.opts.PkgPath + "." + expr.Name, true
+
+	case *ast.SelectorExpr:
+		return t.importedName(expr)
+	}
+
+	return "", false
+}
+
+// importedName returns the name that sel refers to
+// in the form "import/path.Name" if sel.X is the name of an import.
+// It doesn't use type information.
+func (t *walker) importedName(sel *ast.SelectorExpr) (string, bool) {
+	pkg, ok := sel.X.(*ast.Ident)
+	if !ok || pkg.Obj != nil {
+		return "", false // not a package name
+	}
+	path, ok := t.imports[pkg.Name]
+	if !ok {
+		return "", false
+	}
+	return path + "." + sel.Sel.Name, true
+}
+
+// importNames returns the import paths of the file
+// keyed by the name they're referred to with.
+//
+// Without an explicit name, the package name is assumed to be
+// the last element of the import path, ignoring a major version suffix,
+// e.g. "yaml" for "gopkg.in/yaml.v3" or "errors" for "example.com/errors/v2".
+func importNames(f *ast.File) map[string]string {
+	names := make(map[string]string, len(f.Imports))
+	for _, imp := range f.Imports {
+		path, err := strconv.Unquote(imp.Path.Value)
+		if err != nil {
+			continue
+		}
+
+		var name string
+		if imp.Name != nil {
+			name = imp.Name.Name
+		} else {
+			name = guessPackageName(path)
+		}
+		if name == "_" || name == "." {
+			continue
+		}
+		names[name] = path
+	}
+	return names
+}
+
+func guessPackageName(path string) string {
+	elems := strings.Split(path, "/")
+	name := elems[len(elems)-1]
+	if len(elems) > 1 && isMajorVersion(name) {
+		name = elems[len(elems)-2]
+	}
+	name = strings.TrimPrefix(name, "go-")
+	if i := strings.IndexByte(name, '.'); i > 0 {
+		name = name[:i]
+	}
+	return name
+}
+
+// isMajorVersion reports whether elem is a major version suffix, e.g. "v2".
+func isMajorVersion(elem string) bool {
+	if len(elem) < 2 || elem[0] != 'v' {
+		return false
+	}
+	_, err := strconv.Atoi(elem[1:])
+	return err == nil
+}
+
+// optout reports whether the line at the given position
+// is opted out of tracing, incrementing uses if so.
+func (t *walker) optout(pos token.Pos) bool {
+	line := t.fset.Position(pos).Line
+	_, ok := t.optouts[line]
+	if ok {
+		t.optouts[line]++
+	}
+	return ok
+}
+
+// insert is a request to add something to the source code.
+type insert interface {
+	Pos() token.Pos // position to insert at
+	String() string // description for debugging
+}
+
+// insertImportErrtrace adds an import declaration to the file
+// right after the given node.
+type insertImportErrtrace struct {
+	AddKeyword bool      // whether the "import" keyword should be added
+	At         token.Pos // position to insert at
+}
+
+func (e *insertImportErrtrace) Pos() token.Pos {
+	return e.At
+}
+
+func (e *insertImportErrtrace) String() string {
+	if e.AddKeyword {
+		return "add import statement"
+	}
+	return "add import"
+}
+
+// insertWrapOpen adds a errtrace.Wrap call before an expression.
+//
+//	foo() -> errtrace.Wrap(foo()
+//
+// This needs a corresponding insertWrapClose to close the call.
+type insertWrapOpen struct {
+	// N specifies the number of parameters the Wrap function takes.
+	// Defaults to 1.
+	N int
+
+	Before token.Pos // position to insert before
+}
+
+func (e *insertWrapOpen) Pos() token.Pos {
+	return e.Before
+}
+
+func (e *insertWrapOpen) String() string {
+	return "<errtrace.Wrap>"
+}
+
+// insertWrapClose closes a errtrace.Wrap call.
+//
+//	foo() -> foo())
+//
+// This needs a corresponding insertWrapOpen to open the call.
+type insertWrapClose struct {
+	After token.Pos // position to insert after
+}
+
+func (e *insertWrapClose) Pos() token.Pos {
+	return e.After
+}
+
+func (e *insertWrapClose) String() string {
+	return "</errtrace.Wrap>"
+}
+
+type insertReturnNBlockStart struct {
+	N          int       // number of returns
+	Before     token.Pos // position to insert before
+	SkipReturn token.Pos // skipped content, used to drop "return"
+}
+
+func (i *insertReturnNBlockStart) Pos() token.Pos {
+	return i.Before
+}
+
+func (i *insertReturnNBlockStart) String() string {
+	return "<return-block-errtrace.Wrap>"
+}
+
+type insertReturnNBlockClose struct {
+	N     int       // number of returns
+	After token.Pos // position to insert after
+}
+
+func (i *insertReturnNBlockClose) Pos() token.Pos {
+	return i.After
+}
+
+func (i *insertReturnNBlockClose) String() string {
+	return "</return-block-enrrtrace-Wrap>"
+}
+
+// insertNilCheckClose closes a block started by insertReturnNBlockStart
+// for a return of a concrete error value (-nil-check).
+//
+// For example, it will turn this:
+//
+//	return 42, newMyError()
+//
+// Into this:
+//
+//	{ r1, r2 := 42, newMyError(); if r2 == nil { return r1, r2 }; return r1, errtrace.Wrap(r2) }
+type insertNilCheckClose struct {
+	N        int       // number of returns
+	After    token.Pos // position to insert after
+	Wrap     []int     // indices of values to wrap
+	NilCheck int       // index of the value to check for nil
+}
+
+func (i *insertNilCheckClose) Pos() token.Pos {
+	return i.After
+}
+
+func (i *insertNilCheckClose) String() string {
+	return "</return-block-errtrace-nil-check>"
+}
+
+// insertWrapAssign wraps a variable in-place with an errtrace.Wrap call.
+// This is used for naked returns in functions with named return values
+//
+// For example, it will turn this:
+//
+//	func foo() (err error) {
+//		// ...
+//		return
+//	}
+//
+// Into this:
+//
+//	func foo() (err error) {
+//		// ...
+//		err = errtrace.Wrap(err); return
+//	}
+type insertWrapAssign struct {
+	Names  []string  // names of variables to wrap
+	Before token.Pos // position to insert before
+}
+
+func (e *insertWrapAssign) Pos() token.Pos {
+	return e.Before
+}
+
+func (e *insertWrapAssign) String() string {
+	return fmt.Sprintf("assign errors before %v", e.Names)
+}
+
+func unparen(expr ast.Expr) ast.Expr {
+	for {
+		paren, ok := expr.(*ast.ParenExpr)
+		if !ok {
+			return expr
+		}
+		expr = paren.X
+	}
+}
+
+func isIdent(expr ast.Expr, name string) bool {
+	ident, ok := expr.(*ast.Ident)
+	return ok && ident.Name == name
+}
+
+func setOf[T comparable](xs []T) map[T]struct{} {
+	if len(xs) == 0 {
+		return nil
+	}
+
+	set := make(map[T]struct{})
+	for _, x := range xs {
+		set[x] = struct{}{}
+	}
+	return set
+}
+
+var (
+	_errtraceSkip = regexp.MustCompile(`(^| )//errtrace:skip($|[ \(])`)
+
+	// Directives for functions and files must start the comment,
+	// so that comments can mention them.
+	_errtraceSkipFunc = regexp.MustCompile(`^//errtrace:skip($|[ \(])`)
+	_errtraceSkipFile = regexp.MustCompile(`^//errtrace:skipfile($|[ \(])`)
+)
+
+// directive is an //errtrace:skip or //errtrace:skipfile comment
+// that applies to a whole function or file.
+type directive struct {
+	Comment *ast.Comment
+	Uses    int // number of changes and problems dropped
+}
+
+// fileDirective returns the //errtrace:skipfile directive in the file,
+// or nil if there isn't one.
+func fileDirective(f *ast.File) *directive {
+	for _, cg := range f.Comments {
+		for _, c := range cg.List {
+			if _errtraceSkipFile.MatchString(c.Text) {
+				return &directive{Comment: c}
+			}
+		}
+	}
+	return nil
+}
+
+// funcDirectives returns the //errtrace:skip directives
+// that apply to whole functions, keyed by the function's node.
+//
+// For function declarations, the directive is part of the doc comment:
+//
+//	// foo does things.
+//	//
+//	//errtrace:skip
+//	func foo() error {
+//
+// For function literals, the directive is in a comment
+// on the lines right before the one with the literal:
+//
+//	//errtrace:skip
+//	fn := func() error {
+func funcDirectives(fset *token.FileSet, f *ast.File) map[ast.Node]*directive {
+	// Position of the first node on each line,
+	// to tell comments on their own lines apart from trailing comments.
+	firstPos := make(map[int]token.Pos)
+	ast.Inspect(f, func(n ast.Node) bool {
+		switch n.(type) {
+		case nil, *ast.CommentGroup:
+			return false
+		}
+
+		line := fset.Position(n.Pos()).Line
+		if pos, ok := firstPos[line]; !ok || n.Pos() < pos {
+			firstPos[line] = n.Pos()
+		}
+		return true
+	})
+
+	directives := make(map[*ast.Comment]*directive)
+	directiveOf := func(cg *ast.CommentGroup) *directive {
+		if cg == nil {
+			return nil
+		}
+		for _, c := range cg.List {
+			if !_errtraceSkipFunc.MatchString(c.Text) {
+				continue
+			}
+			d, ok := directives[c]
+			if !ok {
+				d = &directive{Comment: c}
+				directives[c] = d
+			}
+			return d
+		}
+		return nil
+	}
+
+	// Comment groups on their own lines, by the line that follows them.
+	above := make(map[int]*ast.CommentGroup)
+	for _, cg := range f.Comments {
+		if pos, ok := firstPos[fset.Position(cg.Pos()).Line]; ok && pos < cg.Pos() {
+			continue // trailing comment
+		}
+		above[fset.Position(cg.End()).Line+1] = cg
+	}
+
+	funcs := make(map[ast.Node]*directive)
+	ast.Inspect(f, func(n ast.Node) bool {
+		var d *directive
+		switch n := n.(type) {
+		case *ast.FuncDecl:
+			d = directiveOf(n.Doc)
+		case *ast.FuncLit:
+			d = directiveOf(above[fset.Position(n.Pos()).Line])
+		}
+		if d != nil {
+			funcs[n] = d
+		}
+		return true
+	})
+	return funcs
+}
+
+// optoutLines returns the line numbers
+// that have a comment in the form:
+//
+//	//errtrace:skip
+//
+// It may be followed by other text, e.g.,
+//
+//	//errtrace:skip // for reasons
+func optoutLines(
+	fset *token.FileSet,
+	comments []*ast.CommentGroup,
+) map[int]int {
+	lines := make(map[int]int)
+	for _, cg := range comments {
+		if len(cg.List) > 1 {
+			// skip multiline comments which are full line comments, not tied to a return.
+			continue
+		}
+
+		c := cg.List[0]
+		if _errtraceSkip.MatchString(c.Text) {
+			lineNo := fset.Position(c.Pos()).Line
+			lines[lineNo] = 0
+		}
+	}
+	return lines
+}
+
+func nVars(prefix string, n int) []string {
+	vars := make([]string, n)
+	for i := 0; i < n; i++ {
+		vars[i] = fmt.Sprintf("%s%d", prefix, i+1)
+	}
+	return vars
+}
@@ -0,0 +1,58 @@
+package rewrite
+
+import (
+	"go/parser"
+	"go/token"
+	"reflect"
+	"sort"
+	"testing"
+
+	"braces.dev/errtrace/internal/diff"
+)
+
+func TestOptoutLines(t *testing.T) {
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "", `package foo
+func _() {
+	_ = "line 3" //errtrace:skip
+	_ = "this line not counted" // errtrace:skip
+	_ = "line 5" //errtrace:skip // has a reason
+	_ = "line 6" //nolint:somelinter //errtrace:skip // stuff
+}`, parser.ParseComments)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []int
+	for line := range optoutLines(fset, f.Comments) {
+		got = append(got, line)
+	}
+	sort.Ints(got)
+
+	if want := []int{3, 5, 6}; !reflect.DeepEqual(want, got) {
+		t.Errorf("got: %v\nwant: %v\ndiff:\n%s", got, want, diff.Diff(want, got))
+	}
+}
+
+func TestSkipFileUnused(t *testing.T) {
+	sf, err := ParseFile("foo.go", []byte(`//errtrace:skipfile
+
+package foo
+
+func foo() error {
+	return nil
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result := Inspect(sf, Options{})
+	want := []Diagnostic{{
+		Pos:      token.Position{Filename: "foo.go", Offset: 0, Line: 1, Column: 1},
+		Category: CategoryUnusedSkip,
+		Message:  "unused errtrace:skipfile",
+	}}
+	if !reflect.DeepEqual(want, result.Diagnostics) {
+		t.Errorf("got: %v\nwant: %v", result.Diagnostics, want)
+	}
+}
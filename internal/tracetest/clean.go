@@ -3,24 +3,14 @@
 package tracetest
 
 import (
-	"fmt"
 	"path/filepath"
-	"regexp"
 	"runtime"
-	"slices"
-	"strconv"
-	"strings"
+
+	"braces.dev/errtrace/errtracetest"
 )
 
 const _fixedDir = "/path/to/errtrace"
 
-// _fileLineMatcher matches file:line where file starts with the fixedDir.
-// Capture groups:
-//
-//  1. file path
-//  2. line number
-var _fileLineMatcher = regexp.MustCompile("(" + regexp.QuoteMeta(_fixedDir) + `[^:]+):(\d+)`)
-
 // MustClean makes traces more deterministic for tests by:
 //
 //   - replacing the environment-specific path to errtrace
@@ -28,25 +18,11 @@ var _fileLineMatcher = regexp.MustCompile("(" + regexp.QuoteMeta(_fixedDir) + `[
 //   - replacing line numbers with the lowest values
 //     that maintain relative ordering within the file
 //
-// Note that lines numbers are replaced with increasing values starting at 1,
-// with earlier positions in the file getting lower numbers.
-// The relative ordering of lines within a file is maintained.
+// Unlike [errtracetest.Clean], paths are relative to the errtrace repository
+// even for tests in other modules inside it (e.g. benchext).
+// See [errtracetest.CleanDir] for details.
 func MustClean(trace string) string {
-	// Get deterministic file paths first.
-	trace = strings.ReplaceAll(trace, getErrtraceDir(), _fixedDir)
-
-	replacer := make(fileLineReplacer)
-	for _, m := range _fileLineMatcher.FindAllStringSubmatch(trace, -1) {
-		file := m[1]
-		lineStr := m[2]
-		line, err := strconv.Atoi(lineStr)
-		if err != nil {
-			panic(fmt.Sprintf("matched bad line number in %q: %v", m[0], err))
-		}
-		replacer.Add(file, line)
-	}
-
-	return strings.NewReplacer(replacer.Replacements()...).Replace(trace)
+	return errtracetest.CleanDir(trace, getErrtraceDir(), _fixedDir)
 }
 
 func getErrtraceDir() string {
@@ -58,38 +34,3 @@ func getErrtraceDir() string {
 	// To get back the original separator, truncate the original string.
 	return file[:len(dir)]
 }
-
-// fileLineReplacer maintains a mapping from
-// file name to line numbers in that file that are referenced.
-// This is used to generate the replacements to be applied to the trace.
-type fileLineReplacer map[string][]int
-
-// Add adds a file:line pair to the replacer.
-func (r fileLineReplacer) Add(file string, line int) {
-	r[file] = append(r[file], line)
-}
-
-// Replacements generates a slice of pairs of Replacements
-// to be applied to the trace.
-//
-// The first element in each pair is the original file:line
-// and the second element is the replacement file:line.
-// This returned slice can be fed into strings.NewReplacer.
-func (r fileLineReplacer) Replacements() []string {
-	var allReplacements []string
-	for file, fileLines := range r {
-		// Sort the lines in the file, and remove duplicates.
-		// The result will be a slice of unique line numbers.
-		// The index of each line in this slice + 1 will be its new line number.
-		slices.Sort(fileLines)
-		fileLines = slices.Compact(fileLines)
-
-		for idx, origLine := range fileLines {
-			replaceLine := idx + 1
-			allReplacements = append(allReplacements,
-				fmt.Sprintf("%v:%v", file, origLine),
-				fmt.Sprintf("%v:%v", file, replaceLine))
-		}
-	}
-	return allReplacements
-}
@@ -1,3 +1,5 @@
+//go:build !errtrace_off
+
 package tracetest
 
 import "braces.dev/errtrace"
@@ -16,6 +18,10 @@ func f2() error {
 	return nil
 }
 
+// f3 is not inlined into f2 so that the trace doesn't depend
+// on whether inlining is enabled.
+//
+//go:noinline
 func f3() error {
 	return errtrace.New("err")
 }
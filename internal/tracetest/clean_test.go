@@ -1,3 +1,5 @@
+//go:build !errtrace_off
+
 package tracetest
 
 import (
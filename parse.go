@@ -0,0 +1,369 @@
+package errtrace
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// TraceTree is a return trace parsed from its textual representation
+// with [ParseTrace].
+//
+// It mirrors the structure printed by [Format]:
+// a message, the return trace for that message,
+// and if the error was a multi-error (e.g. with [errors.Join]),
+// a child tree for each of the errors inside it.
+type TraceTree struct {
+	// Message is the error message.
+	// It may contain newlines.
+	Message string
+
+	// Trace is the return trace for the error
+	// down until the first multi-error was encountered.
+	//
+	// The trace is in the order printed by [Format]:
+	// the first element is the deepest call in the stack,
+	// and the last element is the shallowest call in the stack.
+	//
+	// Only the Function, File, and Line fields are set.
+	Trace []runtime.Frame
+
+	// Repeats is nil if every frame in Trace was returned through once.
+	// Otherwise, Repeats[i] is the number of consecutive times
+	// the error was returned through Trace[i],
+	// as printed by [Format] with "(returned N times)".
+	Repeats []int
+
+	// Inlined is nil if no frame in Trace was inlined.
+	// Otherwise, Inlined[i] reports whether Trace[i]
+	// was inlined by the compiler into Trace[i+1],
+	// as printed by [Format] with "(inlined)".
+	Inlined []bool
+
+	// Truncated is nil if the trace wasn't truncated.
+	// Otherwise, Truncated[i] reports whether [Wrap] stopped tracing
+	// the error after Trace[i] because the trace was too long,
+	// as printed by [Format] with "(trace truncated)".
+	Truncated []bool
+
+	// Children are the trees for each of the errors
+	// inside the multi-error.
+	Children []TraceTree
+}
+
+// ParseTrace parses a return trace printed by [Format]
+// (or formatted with the %+v verb) back into a [TraceTree].
+//
+// The reader must contain a single trace, and nothing else.
+// Trailing whitespace on lines (e.g. stripped by a log processor)
+// and Windows-style line endings are tolerated.
+//
+// The text format is not fully unambiguous:
+// error messages that themselves look like trace frames
+// or tree drawing (e.g. "+- ") may be misinterpreted.
+func ParseTrace(r io.Reader) (TraceTree, error) {
+	var lines []string
+	scan := bufio.NewScanner(r)
+	scan.Buffer(nil, 1<<20) // allow long lines
+	for scan.Scan() {
+		lines = append(lines, normalizeTraceLine(scan.Text()))
+	}
+	if err := scan.Err(); err != nil {
+		return TraceTree{}, err
+	}
+
+	// Trailing empty lines are not part of the trace.
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) == 0 {
+		return TraceTree{}, errors.New("empty trace")
+	}
+
+	p := traceParser{lines: lines}
+	return p.parseTree(nil /* path */)
+}
+
+// traceParser parses the output of treeWriter.
+//
+// Traces are printed depth-first with children before their parent,
+// and every line of a non-root node is prefixed with pipes
+// that encode the node's path in the tree (see [treeWriter.pipes]).
+// The parser walks the same path,
+// so it always knows which prefixes to expect.
+type traceParser struct {
+	lines []string
+	idx   int // index of the next line to consume
+}
+
+func (p *traceParser) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", p.idx+1, fmt.Sprintf(format, args...))
+}
+
+func (p *traceParser) peek() (string, bool) {
+	if p.idx >= len(p.lines) {
+		return "", false
+	}
+	return p.lines[p.idx], true
+}
+
+// parseTree parses the node at the given path,
+// along with all its children.
+func (p *traceParser) parseTree(path []int) (TraceTree, error) {
+	var tree TraceTree
+
+	// Children are printed before the node itself.
+	// The first line of a child's subtree is the first line
+	// of its leftmost leaf.
+	ancestors := ancestorsPrefix(path)
+	for i := 0; ; i++ {
+		line, ok := p.peek()
+		if !ok {
+			break
+		}
+		rest, ok := strings.CutPrefix(line, ancestors)
+		if !ok || !isSubtreeStart(rest, i == 0) {
+			break
+		}
+
+		child, err := p.parseTree(append(path, i))
+		if err != nil {
+			return TraceTree{}, err
+		}
+		tree.Children = append(tree.Children, child)
+	}
+
+	content, err := p.nodeContent(path)
+	if err != nil {
+		return TraceTree{}, err
+	}
+
+	// Message and trace are separated by an empty line,
+	// and frames never contain empty lines,
+	// so only the last empty line can start a trace.
+	msgLines := content
+	for i := len(content) - 1; i >= 0; i-- {
+		if content[i] != "" {
+			continue
+		}
+
+		if frames, ok := parseFrames(content[i+1:]); ok {
+			tree.Trace = frames.Trace
+			tree.Repeats = frames.Repeats
+			tree.Inlined = frames.Inlined
+			tree.Truncated = frames.Truncated
+			msgLines = content[:i]
+		}
+		break
+	}
+	tree.Message = strings.Join(msgLines, "\n")
+
+	return tree, nil
+}
+
+// nodeContent consumes the lines for the message and trace
+// of the node at the given path, returning them without prefixes.
+func (p *traceParser) nodeContent(path []int) ([]string, error) {
+	// The root node is unprefixed and extends to the end of the input.
+	if len(path) == 0 {
+		if p.idx >= len(p.lines) {
+			return nil, p.errorf("expected message, got end of trace")
+		}
+		content := p.lines[p.idx:]
+		p.idx = len(p.lines)
+		return content, nil
+	}
+
+	parent := ancestorsPrefix(path[:len(path)-1])
+	first, ok := p.peek()
+	if !ok {
+		return nil, p.errorf("expected message, got end of trace")
+	}
+	msg, ok := strings.CutPrefix(first, parent+"+- ")
+	if !ok {
+		return nil, p.errorf("expected message with prefix %q, got %q", parent+"+- ", first)
+	}
+	p.idx++
+
+	// Nodes in a tree are always terminated by an empty line.
+	// That's the first empty line followed by something
+	// that is not part of this node:
+	// the end of the trace, a sibling, or the parent.
+	content := []string{msg}
+	cont := parent + "|  "
+	for {
+		line, ok := p.peek()
+		if !ok {
+			return nil, p.errorf("expected end of message, got end of trace")
+		}
+		rest, ok := strings.CutPrefix(line, cont)
+		if !ok {
+			return nil, p.errorf("expected line with prefix %q, got %q", cont, line)
+		}
+		p.idx++
+
+		if rest == "" {
+			next, ok := p.peek()
+			if !ok {
+				break
+			}
+			nextRest, ok := strings.CutPrefix(next, cont)
+			if !ok || isSubtreeStart(nextRest, true /* first */) {
+				break
+			}
+		}
+
+		content = append(content, rest)
+	}
+
+	return content, nil
+}
+
+// parseFrames parses a list of function and file:line pairs
+// in the format printed by treeWriter.
+// Only the Trace, Repeats, Inlined, and Truncated fields
+// of the returned tree are set.
+// It reports false if lines are not a non-empty list of frames.
+func parseFrames(lines []string) (t TraceTree, ok bool) {
+	if len(lines) == 0 || len(lines)%2 != 0 {
+		return TraceTree{}, false
+	}
+
+	// flag records a per-frame flag in *flags,
+	// allocating it the first time a frame has the flag set.
+	flag := func(flags *[]bool, set bool) {
+		if set && *flags == nil {
+			*flags = make([]bool, len(t.Trace), len(lines)/2)
+		}
+		if *flags != nil {
+			*flags = append(*flags, set)
+		}
+	}
+
+	t.Trace = make([]runtime.Frame, 0, len(lines)/2)
+	for i := 0; i < len(lines); i += 2 {
+		fn, loc := lines[i], lines[i+1]
+		if fn == "" || strings.HasPrefix(fn, "\t") {
+			return TraceTree{}, false
+		}
+
+		loc, ok := strings.CutPrefix(loc, "\t")
+		if !ok {
+			return TraceTree{}, false
+		}
+		idx := strings.LastIndexByte(loc, ':')
+		if idx < 0 {
+			return TraceTree{}, false
+		}
+		line, err := strconv.Atoi(loc[idx+1:])
+		if err != nil {
+			return TraceTree{}, false
+		}
+
+		fn, isTruncated := strings.CutSuffix(fn, " (trace truncated)")
+		fn, count := cutRepeatCount(fn)
+		fn, isInlined := strings.CutSuffix(fn, " (inlined)")
+		flag(&t.Inlined, isInlined)
+		flag(&t.Truncated, isTruncated)
+
+		if count > 1 && t.Repeats == nil {
+			t.Repeats = make([]int, len(t.Trace), len(lines)/2)
+			for j := range t.Repeats {
+				t.Repeats[j] = 1
+			}
+		}
+		if t.Repeats != nil {
+			t.Repeats = append(t.Repeats, count)
+		}
+
+		t.Trace = append(t.Trace, runtime.Frame{
+			Function: fn,
+			File:     loc[:idx],
+			Line:     line,
+		})
+	}
+	return t, true
+}
+
+// cutRepeatCount removes the " (returned N times)" suffix
+// added by treeWriter to a function name, returning the count.
+// The count is 1 if there's no suffix.
+func cutRepeatCount(fn string) (string, int) {
+	rest, ok := strings.CutSuffix(fn, " times)")
+	if !ok {
+		return fn, 1
+	}
+
+	idx := strings.LastIndex(rest, " (returned ")
+	if idx < 0 {
+		return fn, 1
+	}
+
+	count, err := strconv.Atoi(rest[idx+len(" (returned "):])
+	if err != nil || count < 2 {
+		return fn, 1
+	}
+	return rest[:idx], count
+}
+
+// ancestorsPrefix returns the pipes drawn for all components of path
+// as if they were intermediate positions of a longer path.
+// See [treeWriter.pipes] for details.
+func ancestorsPrefix(path []int) string {
+	var s strings.Builder
+	for _, idx := range path {
+		if idx == 0 {
+			s.WriteString("   ")
+		} else {
+			s.WriteString("|  ")
+		}
+	}
+	return s.String()
+}
+
+// isSubtreeStart reports whether the given line
+// (with ancestor prefixes already removed)
+// is the first line of a subtree at the current depth.
+//
+// The first line of a subtree is the first line of its leftmost leaf,
+// so it takes one of the following forms:
+//
+//	+- msg           the subtree is a leaf
+//	   ...   +- msg  leftmost leaf of the first child (first is true)
+//	|     ...+- msg  leftmost leaf of a later child (first is false)
+func isSubtreeStart(rest string, first bool) bool {
+	if !first {
+		rest = strings.TrimPrefix(rest, "|  ")
+	}
+	for {
+		r, ok := strings.CutPrefix(rest, "   ")
+		if !ok {
+			break
+		}
+		rest = r
+	}
+	return strings.HasPrefix(rest, "+- ")
+}
+
+// normalizeTraceLine drops Windows line endings,
+// and restores trailing whitespace for lines that only contain tree drawing
+// in case it was stripped by a log processor.
+func normalizeTraceLine(line string) string {
+	line = strings.TrimSuffix(line, "\r")
+
+	trimmed := strings.TrimRight(line, " ")
+	drawing := strings.TrimSuffix(trimmed, "+-")
+	if strings.Trim(drawing, " |") != "" {
+		return line
+	}
+
+	// Pad to the next multiple of the width of a tree component.
+	if n := len(trimmed) % 3; n > 0 {
+		trimmed += strings.Repeat(" ", 3-n)
+	}
+	return trimmed
+}
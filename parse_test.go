@@ -0,0 +1,392 @@
+//go:build !errtrace_off
+
+package errtrace
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace/internal/diff"
+)
+
+func TestParseTrace(t *testing.T) {
+	tests := []struct {
+		name string
+		give []string // lines
+		want TraceTree
+	}{
+		{
+			name: "message only",
+			give: []string{"great sadness"},
+			want: TraceTree{Message: "great sadness"},
+		},
+		{
+			name: "single error",
+			give: []string{
+				"test error",
+				"",
+				"foo",
+				"	foo.go:42",
+				"bar",
+				"	bar.go:24",
+			},
+			want: TraceTree{
+				Message: "test error",
+				Trace: []runtime.Frame{
+					{Function: "foo", File: "foo.go", Line: 42},
+					{Function: "bar", File: "bar.go", Line: 24},
+				},
+			},
+		},
+		{
+			name: "repeated frame",
+			give: []string{
+				"test error",
+				"",
+				"foo",
+				"	foo.go:42",
+				"bar (returned 3 times)",
+				"	bar.go:24",
+				"baz (returned many times)",
+				"	baz.go:12",
+			},
+			want: TraceTree{
+				Message: "test error",
+				Trace: []runtime.Frame{
+					{Function: "foo", File: "foo.go", Line: 42},
+					{Function: "bar", File: "bar.go", Line: 24},
+					{Function: "baz (returned many times)", File: "baz.go", Line: 12},
+				},
+				Repeats: []int{1, 3, 1},
+			},
+		},
+		{
+			name: "inlined frame",
+			give: []string{
+				"test error",
+				"",
+				"foo (inlined)",
+				"	foo.go:42",
+				"bar (inlined) (returned 2 times)",
+				"	bar.go:24",
+				"baz",
+				"	baz.go:12",
+			},
+			want: TraceTree{
+				Message: "test error",
+				Trace: []runtime.Frame{
+					{Function: "foo", File: "foo.go", Line: 42},
+					{Function: "bar", File: "bar.go", Line: 24},
+					{Function: "baz", File: "baz.go", Line: 12},
+				},
+				Repeats: []int{1, 2, 1},
+				Inlined: []bool{true, true, false},
+			},
+		},
+		{
+			name: "truncated trace",
+			give: []string{
+				"test error",
+				"",
+				"foo",
+				"	foo.go:42",
+				"bar (returned 2 times) (trace truncated)",
+				"	bar.go:24",
+			},
+			want: TraceTree{
+				Message: "test error",
+				Trace: []runtime.Frame{
+					{Function: "foo", File: "foo.go", Line: 42},
+					{Function: "bar", File: "bar.go", Line: 24},
+				},
+				Repeats:   []int{1, 2},
+				Truncated: []bool{false, true},
+			},
+		},
+		{
+			name: "multi-line message",
+			give: []string{
+				"first line",
+				"",
+				"third line",
+				"",
+				"foo",
+				"	C:/path/to/foo.go:42",
+			},
+			want: TraceTree{
+				Message: "first line\n\nthird line",
+				Trace: []runtime.Frame{
+					{Function: "foo", File: "C:/path/to/foo.go", Line: 42},
+				},
+			},
+		},
+		{
+			name: "multi error",
+			give: []string{
+				"+- err a",
+				"|  ",
+				"|  foo",
+				"|  	foo.go:42",
+				"|  ",
+				"+- err b",
+				"|  ",
+				"err a",
+				"err b",
+				"",
+				"bar",
+				"	bar.go:24",
+			},
+			want: TraceTree{
+				Message: "err a\nerr b",
+				Trace: []runtime.Frame{
+					{Function: "bar", File: "bar.go", Line: 24},
+				},
+				Children: []TraceTree{
+					{
+						Message: "err a",
+						Trace: []runtime.Frame{
+							{Function: "foo", File: "foo.go", Line: 42},
+						},
+					},
+					{Message: "err b"},
+				},
+			},
+		},
+		{
+			name: "nested with trailing spaces stripped",
+			give: []string{
+				"   +- err a",
+				"   |",
+				"   +- err b",
+				"   |",
+				"   |  quux",
+				"   |  	quux.go:24",
+				"   |",
+				"+- err a",
+				"|  err b",
+				"|",
+				"|  +- err c",
+				"|  |",
+				"|  +- err d",
+				"|  |",
+				"+- err c",
+				"|  err d",
+				"|",
+				"err a",
+				"err b",
+				"err c",
+				"err d",
+			},
+			want: TraceTree{
+				Message: "err a\nerr b\nerr c\nerr d",
+				Children: []TraceTree{
+					{
+						Message: "err a\nerr b",
+						Children: []TraceTree{
+							{Message: "err a"},
+							{
+								Message: "err b",
+								Trace: []runtime.Frame{
+									{Function: "quux", File: "quux.go", Line: 24},
+								},
+							},
+						},
+					},
+					{
+						Message: "err c\nerr d",
+						Children: []TraceTree{
+							{Message: "err c"},
+							{Message: "err d"},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, eol := range []string{"\n", "\r\n"} {
+				got, err := ParseTrace(strings.NewReader(strings.Join(tt.give, eol) + eol))
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if !reflect.DeepEqual(tt.want, got) {
+					t.Errorf("eol %q: tree mismatch\nwant: %+v\ngot:  %+v", eol, tt.want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestParseTrace_errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		give    string
+		wantErr string
+	}{
+		{name: "empty", give: "", wantErr: "empty trace"},
+		{name: "blank lines", give: "\n\n", wantErr: "empty trace"},
+		{
+			name:    "missing root",
+			give:    "+- err a\n|  \n",
+			wantErr: "line 3: expected message, got end of trace",
+		},
+		{
+			name:    "unterminated child",
+			give:    "+- err a\n|  foo",
+			wantErr: "line 3: expected end of message, got end of trace",
+		},
+		{
+			name:    "bad prefix",
+			give:    "   +- err a\n|  \nerr a\n",
+			wantErr: `line 2: expected line with prefix "   |  "`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ParseTrace(strings.NewReader(tt.give))
+			if err == nil {
+				t.Fatal("expected error")
+			}
+
+			if got := err.Error(); !strings.Contains(got, tt.wantErr) {
+				t.Errorf("error %q does not contain %q", got, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestParseTrace_format(t *testing.T) {
+	errs := map[string]error{
+		"single":       errorCaller(),
+		"multi":        errorMultiCaller(),
+		"wrapped":      Wrap(errorMultiCaller()),
+		"multi-nested": Wrap(errors.Join(errorMultiCaller(), errorCaller(), errors.New("plain"))),
+		"repeated":     errorRetryCaller(3),
+	}
+
+	for name, err := range errs {
+		t.Run(name, func(t *testing.T) {
+			got, parseErr := ParseTrace(strings.NewReader(FormatString(err)))
+			if parseErr != nil {
+				t.Fatal(parseErr)
+			}
+
+			if want := toTraceTree(buildTraceTree(err)); !reflect.DeepEqual(want, got) {
+				t.Errorf("tree mismatch\nwant: %+v\ngot:  %+v", want, got)
+			}
+		})
+	}
+}
+
+// TestParseTrace_roundTrip verifies that parsing the output of writeTree
+// for randomly generated trees reproduces the original tree.
+func TestParseTrace_roundTrip(t *testing.T) {
+	seed := rand.Int63()
+	t.Logf("seed: %v", seed)
+	r := rand.New(rand.NewSource(seed))
+
+	for i := 0; i < 500; i++ {
+		tree := randomTraceTree(r, 0 /* depth */)
+
+		var s strings.Builder
+		if err := writeTree(&s, tree); err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := ParseTrace(strings.NewReader(s.String()))
+		if err != nil {
+			t.Fatalf("parse:\n%s\nerror: %v", s.String(), err)
+		}
+
+		if want := toTraceTree(tree); !reflect.DeepEqual(want, got) {
+			var gotS strings.Builder
+			if err := writeTree(&gotS, fromTraceTree(got)); err != nil {
+				t.Fatal(err)
+			}
+			t.Fatalf("round trip mismatch for:\n%s\ndiff:\n%s", s.String(), diff.Lines(s.String(), gotS.String()))
+		}
+	}
+}
+
+func randomTraceTree(r *rand.Rand, depth int) traceTree {
+	words := []string{"great", "sadness", "failed", "to", "open", "file", "x:", "42", "|", "+-"}
+	msgLines := make([]string, 1+r.Intn(3))
+	for i := range msgLines {
+		// Empty lines are only allowed in the middle of a message.
+		if i > 0 && i < len(msgLines)-1 && r.Intn(4) == 0 {
+			continue
+		}
+
+		ws := make([]string, 1+r.Intn(4))
+		for j := range ws {
+			ws[j] = words[r.Intn(len(words))]
+		}
+		// Messages starting with tree drawing are ambiguous.
+		ws[0] = words[r.Intn(len(words)-2)]
+		msgLines[i] = strings.Join(ws, " ")
+	}
+
+	tree := traceTree{Err: errors.New(strings.Join(msgLines, "\n"))}
+	for i := r.Intn(4); i > 0; i-- {
+		tree.Trace = append(tree.Trace, runtime.Frame{
+			Function: fmt.Sprintf("example.com/pkg%d.(*T).fn%d", r.Intn(10), r.Intn(10)),
+			File:     fmt.Sprintf("/path/to/pkg/file %d.go", r.Intn(10)),
+			Line:     r.Intn(1000),
+		})
+	}
+	if len(tree.Trace) > 0 && r.Intn(3) == 0 {
+		tree.Repeats = make([]int, len(tree.Trace))
+		for i := range tree.Repeats {
+			tree.Repeats[i] = 1 + r.Intn(3)
+		}
+		tree.Repeats[r.Intn(len(tree.Repeats))] = 2 // at least one repeat
+	}
+	if len(tree.Trace) > 1 && r.Intn(3) == 0 {
+		// Only the last frame can't be inlined.
+		tree.Inlined = make([]bool, len(tree.Trace))
+		tree.Inlined[r.Intn(len(tree.Trace)-1)] = true
+	}
+	if len(tree.Trace) > 0 && r.Intn(4) == 0 {
+		tree.Truncated = make([]bool, len(tree.Trace))
+		tree.Truncated[r.Intn(len(tree.Trace))] = true
+	}
+
+	if depth < 3 && r.Intn(3) == 0 {
+		for i := 1 + r.Intn(3); i > 0; i-- {
+			tree.Children = append(tree.Children, randomTraceTree(r, depth+1))
+		}
+	}
+	return tree
+}
+
+func toTraceTree(t traceTree) TraceTree {
+	tree := TraceTree{Message: t.Err.Error(), Repeats: t.Repeats, Inlined: t.Inlined, Truncated: t.Truncated}
+	for _, f := range t.Trace {
+		tree.Trace = append(tree.Trace, runtime.Frame{
+			Function: f.Function,
+			File:     f.File,
+			Line:     f.Line,
+		})
+	}
+	for _, child := range t.Children {
+		tree.Children = append(tree.Children, toTraceTree(child))
+	}
+	return tree
+}
+
+func fromTraceTree(t TraceTree) traceTree {
+	tree := traceTree{Err: errors.New(t.Message), Trace: t.Trace, Repeats: t.Repeats, Inlined: t.Inlined, Truncated: t.Truncated}
+	for _, child := range t.Children {
+		tree.Children = append(tree.Children, fromTraceTree(child))
+	}
+	return tree
+}
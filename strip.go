@@ -0,0 +1,163 @@
+package errtrace
+
+import "reflect"
+
+// Strip returns err with all errtrace trace information removed.
+//
+// Errors returned by [Wrap], [WrapFrame], and friends
+// are replaced by the errors they wrap.
+// Other wrappers are kept in place:
+// if a wrapper holds an error with a trace,
+// Strip replaces it with an error that has the same message,
+// matches the original wrapper with [errors.Is] and [errors.As],
+// and unwraps to the stripped inner error (or errors for multi-errors).
+// Wrappers that don't hold traced errors are returned as-is.
+//
+// Strip is intended to help migrate code that compares errors with ==
+// or type-asserts them directly:
+//
+//	if errtrace.Strip(err) == io.EOF {
+//	if pathErr, ok := errtrace.Strip(err).(*fs.PathError); ok {
+//
+// Because a wrapper that holds a traced error is replaced,
+// a direct type assertion only finds it
+// if none of the errors it wraps have traces.
+// For example, with &fs.PathError{Err: errtrace.Wrap(fs.ErrNotExist)},
+// Strip(err).(*fs.PathError) fails,
+// and errors.As must be used to get the *fs.PathError.
+//
+// Prefer [errors.Is] and [errors.As] for new code.
+func Strip(err error) error {
+	stripped, _ := strip(err)
+	return stripped
+}
+
+// strip removes errTrace layers from err,
+// reporting whether there were any.
+func strip(err error) (error, bool) {
+	switch e := err.(type) {
+	case *errTrace:
+		inner, _ := strip(e.err)
+		return inner, true
+
+	case *frameTrace:
+		inner, _ := strip(e.err)
+		return inner, true
+
+	case interface{ Unwrap() error }:
+		inner, ok := strip(e.Unwrap())
+		if !ok {
+			return err, false
+		}
+		return &strippedWrapper{wrapper: err, inner: inner}, true
+
+	case interface{ Unwrap() []error }:
+		errs := e.Unwrap()
+		inner := make([]error, len(errs))
+		var stripped bool
+		for i, err := range errs {
+			var ok bool
+			inner[i], ok = strip(err)
+			stripped = stripped || ok
+		}
+		if !stripped {
+			return err, false
+		}
+		return &strippedMultiWrapper{wrapper: err, inner: inner}, true
+
+	default:
+		return err, false
+	}
+}
+
+// Cause returns the innermost error in err's chain:
+// the first error found by repeatedly unwrapping err
+// that doesn't wrap another error.
+// Errors returned by [Wrap] and friends always wrap another error,
+// so the result never carries a trace of its own.
+//
+// Multi-errors (e.g. from [errors.Join]) have no single cause.
+// If one is found, it's returned with traces removed as if by [Strip].
+//
+// Cause returns nil if err is nil.
+func Cause(err error) error {
+	for {
+		switch e := err.(type) {
+		case interface{ Unwrap() error }:
+			inner := e.Unwrap()
+			if inner == nil {
+				return err
+			}
+			err = inner
+
+		case interface{ Unwrap() []error }:
+			return Strip(err)
+
+		default:
+			return err
+		}
+	}
+}
+
+// strippedWrapper stands in for an error wrapper
+// whose wrapped error had traces removed by Strip.
+type strippedWrapper struct {
+	wrapper error // original wrapper
+	inner   error // wrapper.Unwrap() without traces
+}
+
+func (e *strippedWrapper) Error() string { return e.wrapper.Error() }
+func (e *strippedWrapper) Unwrap() error { return e.inner }
+
+func (e *strippedWrapper) Is(target error) bool {
+	return wrapperIs(e.wrapper, target)
+}
+
+func (e *strippedWrapper) As(target any) bool {
+	return wrapperAs(e.wrapper, target)
+}
+
+// strippedMultiWrapper stands in for a multi-error
+// whose wrapped errors had traces removed by Strip.
+type strippedMultiWrapper struct {
+	wrapper error   // original multi-error
+	inner   []error // wrapper.Unwrap() without traces
+}
+
+func (e *strippedMultiWrapper) Error() string   { return e.wrapper.Error() }
+func (e *strippedMultiWrapper) Unwrap() []error { return e.inner }
+
+func (e *strippedMultiWrapper) Is(target error) bool {
+	return wrapperIs(e.wrapper, target)
+}
+
+func (e *strippedMultiWrapper) As(target any) bool {
+	return wrapperAs(e.wrapper, target)
+}
+
+// wrapperIs reports whether the original wrapper matches target
+// without looking at the errors it wraps,
+// the same way errors.Is would check a single error in the chain.
+func wrapperIs(wrapper, target error) bool {
+	if reflect.TypeOf(target).Comparable() && wrapper == target {
+		return true
+	}
+	if x, ok := wrapper.(interface{ Is(error) bool }); ok {
+		return x.Is(target)
+	}
+	return false
+}
+
+// wrapperAs is the errors.As counterpart of wrapperIs.
+// errors.As has already verified that target is a non-nil pointer.
+func wrapperAs(wrapper error, target any) bool {
+	val := reflect.ValueOf(target)
+	if reflect.TypeOf(wrapper).AssignableTo(val.Type().Elem()) {
+		val.Elem().Set(reflect.ValueOf(wrapper))
+		return true
+	}
+	if x, ok := wrapper.(interface{ As(any) bool }); ok {
+		return x.As(target)
+	}
+	return false
+}
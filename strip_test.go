@@ -0,0 +1,144 @@
+package errtrace_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+func TestStrip(t *testing.T) {
+	if err := errtrace.Strip(nil); err != nil {
+		t.Errorf("Strip(nil) = %v, want nil", err)
+	}
+
+	plain := fmt.Errorf("read: %w", io.EOF)
+	if got := errtrace.Strip(plain); got != plain {
+		t.Errorf("Strip(plain) = %#v, want unchanged", got)
+	}
+
+	if got := errtrace.Strip(errtrace.Wrap(errtrace.Wrap(io.EOF))); got != io.EOF {
+		t.Errorf("Strip(Wrap(EOF)) = %#v, want io.EOF", got)
+	}
+}
+
+func TestStrip_wrapper(t *testing.T) {
+	traced := errtrace.Wrap(fmt.Errorf("read config: %w", errtrace.Wrap(io.EOF)))
+	err := errtrace.Strip(traced)
+
+	if got, want := err.Error(), "read config: EOF"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if got := errors.Unwrap(err); got != io.EOF {
+		t.Errorf("Unwrap() = %#v, want io.EOF", got)
+	}
+	if got, want := errtrace.FormatString(err), err.Error()+"\n"; got != want {
+		t.Errorf("FormatString() = %q, want %q", got, want)
+	}
+}
+
+func TestStrip_wrapperType(t *testing.T) {
+	pathErr := &fs.PathError{Op: "open", Path: "foo", Err: errtrace.Wrap(fs.ErrNotExist)}
+	err := errtrace.Strip(errtrace.Wrap(pathErr))
+
+	var got *fs.PathError
+	if !errors.As(err, &got) || got != pathErr {
+		t.Errorf("As(*fs.PathError) = %v, want %v", got, pathErr)
+	}
+	if !errors.Is(err, pathErr) {
+		t.Errorf("Is(pathErr) = false, want true")
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Is(fs.ErrNotExist) = false, want true")
+	}
+	if got := errors.Unwrap(err); got != fs.ErrNotExist {
+		t.Errorf("Unwrap() = %#v, want fs.ErrNotExist", got)
+	}
+}
+
+func TestStrip_typeAssertion(t *testing.T) {
+	// Wrappers that don't hold traced errors keep their identity.
+	plain := &fs.PathError{Op: "open", Path: "foo", Err: fs.ErrNotExist}
+	if got, ok := errtrace.Strip(errtrace.Wrap(plain)).(*fs.PathError); !ok || got != plain {
+		t.Errorf("Strip(Wrap(plain)).(*fs.PathError) = %v, %v; want %v, true", got, ok, plain)
+	}
+
+	// Wrappers that hold traced errors are replaced,
+	// so only errors.As finds them.
+	// With -tags errtrace_off, there's nothing to strip.
+	traced := &fs.PathError{Op: "open", Path: "foo", Err: errtrace.Wrap(fs.ErrNotExist)}
+	err := errtrace.Strip(errtrace.Wrap(traced))
+	if _, ok := err.(*fs.PathError); ok && errtrace.Enabled() {
+		t.Errorf("Strip(Wrap(traced)).(*fs.PathError) succeeded, want failure")
+	}
+	var got *fs.PathError
+	if !errors.As(err, &got) || got != traced {
+		t.Errorf("As(*fs.PathError) = %v, want %v", got, traced)
+	}
+}
+
+func TestStrip_join(t *testing.T) {
+	errA := errors.New("a")
+	errB := errors.New("b")
+	traced := errtrace.Wrap(errors.Join(errtrace.Wrap(errA), errB))
+	err := errtrace.Strip(traced)
+
+	if got, want := err.Error(), "a\nb"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+
+	multi, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		t.Fatalf("Strip() = %#v, want a multi-error", err)
+	}
+	errs := multi.Unwrap()
+	if len(errs) != 2 || errs[0] != errA || errs[1] != errB {
+		t.Errorf("Unwrap() = %v, want [a b]", errs)
+	}
+}
+
+func TestCause(t *testing.T) {
+	joined := errors.Join(errtrace.Wrap(io.EOF), os.ErrClosed)
+
+	tests := []struct {
+		name string
+		give error
+		want error
+	}{
+		{name: "nil"},
+		{name: "plain", give: io.EOF, want: io.EOF},
+		{name: "wrapped", give: errtrace.Wrap(io.EOF), want: io.EOF},
+		{
+			name: "nested",
+			give: errtrace.Wrap(fmt.Errorf("read: %w", errtrace.Wrap(io.EOF))),
+			want: io.EOF,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errtrace.Cause(tt.give); got != tt.want {
+				t.Errorf("Cause() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+
+	t.Run("new", func(t *testing.T) {
+		got := errtrace.Cause(errtrace.Wrap(errtrace.New("great sadness")))
+		if _, _, ok := errtrace.UnwrapFrame(got); ok || got.Error() != "great sadness" {
+			t.Errorf("Cause() = %#v, want untraced error", got)
+		}
+	})
+
+	t.Run("multi", func(t *testing.T) {
+		got := errtrace.Cause(errtrace.Wrap(joined))
+		errs := got.(interface{ Unwrap() []error }).Unwrap()
+		if len(errs) != 2 || errs[0] != io.EOF || errs[1] != os.ErrClosed {
+			t.Errorf("Cause() = %v, want stripped multi-error", errs)
+		}
+	})
+}
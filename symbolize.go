@@ -0,0 +1,141 @@
+package errtrace
+
+import (
+	"runtime"
+	"sync"
+)
+
+// _frameCacheSize is the number of program counters kept in each generation
+// of the frame cache. At most twice this many are cached.
+const _frameCacheSize = 2048
+
+var _frameCache = newFrameCache(_frameCacheSize)
+
+// symbolize resolves the given program counters into frames.
+// frames[i] is the frame for pcs[i],
+// or the zero frame if pcs[i] did not yield a frame.
+// For program counters inside inlined calls,
+// it's the frame for the innermost call.
+func symbolize(pcs []uintptr) []runtime.Frame {
+	frames := make([]runtime.Frame, len(pcs))
+	for i, pc := range pcs {
+		if fs := resolvePC(pc); len(fs) > 0 {
+			frames[i] = fs[0]
+		}
+	}
+	return frames
+}
+
+// symbolizeInlined resolves the given program counters into frames
+// similar to symbolize,
+// but expands program counters inside inlined calls
+// into a frame for each call.
+//
+// frames[i] holds the frames for pcs[i], starting with the innermost call.
+// Every frame except the last was inlined into the frame following it.
+// frames[i] is empty if pcs[i] did not yield a frame.
+// The frames must not be modified.
+func symbolizeInlined(pcs []uintptr) [][]runtime.Frame {
+	frames := make([][]runtime.Frame, len(pcs))
+	for i, pc := range pcs {
+		frames[i] = resolvePC(pc)
+	}
+	return frames
+}
+
+// resolvePC returns the frames for pc from the cache,
+// resolving and caching them if needed.
+func resolvePC(pc uintptr) []runtime.Frame {
+	if frames, ok := _frameCache.Get(pc); ok {
+		return frames
+	}
+
+	frames := resolveFrames(pc)
+	_frameCache.Put(pc, frames)
+	return frames
+}
+
+// resolveFrames resolves a program counter into a frame
+// for each call that it's inside, starting with the innermost call.
+// PCs that are not inside a known function yield no frames at all.
+//
+// Each PC gets its own runtime.CallersFrames
+// so that frames can't be attributed to the wrong PC:
+// the frames of inlined calls each report a different Frame.PC,
+// so they can't be matched to the PC that they came from.
+func resolveFrames(pc uintptr) []runtime.Frame {
+	// runtime.CallersFrames only reports the innermost call
+	// for the last PC it's given,
+	// so follow pc with a zero PC, which yields no frames.
+	var frames []runtime.Frame
+	iter := runtime.CallersFrames([]uintptr{pc, 0})
+	for {
+		f, more := iter.Next()
+		if f.PC != 0 {
+			frames = append(frames, f)
+		}
+		if !more {
+			break
+		}
+	}
+	return frames[:len(frames):len(frames)]
+}
+
+// frameCache is a bounded, concurrency-safe cache of the frames for each PC.
+//
+// Entries are kept in two generations.
+// New entries are added to the current generation,
+// and when it fills up, it becomes the previous generation
+// and the old previous generation is dropped.
+// Entries found in the previous generation are moved to the current one,
+// so frequently used frames are retained.
+type frameCache struct {
+	size int // max entries per generation
+
+	mu   sync.RWMutex
+	cur  map[uintptr][]runtime.Frame
+	prev map[uintptr][]runtime.Frame
+}
+
+func newFrameCache(size int) *frameCache {
+	return &frameCache{
+		size: size,
+		cur:  make(map[uintptr][]runtime.Frame),
+	}
+}
+
+// Get returns the cached frames for pc.
+func (c *frameCache) Get(pc uintptr) ([]runtime.Frame, bool) {
+	c.mu.RLock()
+	frames, ok := c.cur[pc]
+	if ok {
+		c.mu.RUnlock()
+		return frames, true
+	}
+	frames, ok = c.prev[pc]
+	c.mu.RUnlock()
+
+	if ok {
+		c.Put(pc, frames)
+	}
+	return frames, ok
+}
+
+// Put adds the frames for pc to the cache.
+func (c *frameCache) Put(pc uintptr, frames []runtime.Frame) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(c.cur) >= c.size {
+		c.prev = c.cur
+		c.cur = make(map[uintptr][]runtime.Frame, c.size)
+	}
+	c.cur[pc] = frames
+}
+
+// Len reports the number of PCs in the cache.
+func (c *frameCache) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.cur) + len(c.prev)
+}
@@ -0,0 +1,198 @@
+//go:build !errtrace_off
+
+package errtrace
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"sync"
+	"testing"
+
+	"braces.dev/errtrace/internal/diff"
+)
+
+func TestSymbolize(t *testing.T) {
+	pcs := make([]uintptr, 64)
+	pcs = pcs[:runtime.Callers(0, pcs)]
+	if len(pcs) < 3 {
+		t.Fatalf("too few callers: %v", len(pcs))
+	}
+
+	entryPC := reflect.ValueOf(TestSymbolize).Pointer()
+	pcs = append(pcs,
+		pcs[0],  // duplicate
+		0,       // bad PC
+		entryPC, // function entry, not a return address
+		pcs[1],  // another duplicate
+	)
+
+	// Expected frames are resolved one at a time.
+	want := make([]runtime.Frame, len(pcs))
+	for i, pc := range pcs {
+		want[i], _ = runtime.CallersFrames([]uintptr{pc}).Next()
+	}
+
+	// Resolve twice: once with an empty cache, once from the cache.
+	for _, name := range []string{"cold", "cached"} {
+		if name == "cold" {
+			_frameCache = newFrameCache(_frameCacheSize)
+		}
+
+		got := symbolize(pcs)
+		for i := range want {
+			if got[i] != want[i] {
+				t.Errorf("%v: frame %d (pc %#x):\nwant %+v\ngot  %+v", name, i, pcs[i], want[i], got[i])
+			}
+		}
+	}
+}
+
+// tracePCError is a third-party error that reports a PC
+// without going through wrap.
+type tracePCError struct {
+	error
+	pc uintptr
+}
+
+func (e *tracePCError) TracePC() uintptr { return e.pc }
+func (e *tracePCError) Unwrap() error    { return e.error }
+
+func TestSymbolizeInlined(t *testing.T) {
+	pc := inlinedWrapCaller().(*errTrace).pc
+
+	// Frame.Func is nil only for inlined calls.
+	f, _ := runtime.CallersFrames([]uintptr{pc}).Next()
+	want := []string{"braces.dev/errtrace.inlinedWrap"}
+	if f.Func == nil {
+		want = append(want, "braces.dev/errtrace.inlinedWrapCaller")
+	}
+
+	funcs := func(frames []runtime.Frame) []string {
+		var names []string
+		for _, f := range frames {
+			names = append(names, f.Function)
+		}
+		return names
+	}
+
+	t.Run("symbolizeInlined", func(t *testing.T) {
+		// inlinedWrapCaller has returned, so pc is no longer on the stack.
+		frames := symbolizeInlined([]uintptr{pc, 0})
+		if d := diff.Diff(want, funcs(frames[0])); d != "" {
+			t.Errorf("frames mismatch (-want +got):\n%s", d)
+		}
+		if len(frames[1]) != 0 {
+			t.Errorf("frames for zero PC = %v, want none", frames[1])
+		}
+	})
+
+	t.Run("TracePC", func(t *testing.T) {
+		err := &tracePCError{error: errors.New("great sadness"), pc: pc}
+		frames, _, ok := UnwrapFrames(err)
+		if !ok {
+			t.Fatalf("UnwrapFrames() did not find frames")
+		}
+		if d := diff.Diff(want, funcs(frames)); d != "" {
+			t.Errorf("frames mismatch (-want +got):\n%s", d)
+		}
+	})
+}
+
+//go:noinline
+func inlinedWrapPair() []error {
+	a := inlinedWrap(errors.New("a"))
+	b := inlinedWrap(errors.New("b"))
+	return []error{a, b}
+}
+
+func TestSymbolizeInlined_adjacent(t *testing.T) {
+	var pcs []uintptr
+	for _, err := range inlinedWrapPair() {
+		pcs = append(pcs, err.(*errTrace).pc)
+	}
+	if f, _ := runtime.CallersFrames(pcs[:1]).Next(); f.Func != nil {
+		t.Skip("inlinedWrap was not inlined")
+	}
+
+	// Adjacent PCs inside inlined calls, in both orders and repeated,
+	// each resolve to their own frames.
+	_frameCache = newFrameCache(_frameCacheSize)
+	batch := []uintptr{pcs[0], pcs[1], pcs[1], pcs[0]}
+	frames := symbolizeInlined(batch)
+	for i, fs := range frames {
+		if len(fs) != 2 {
+			t.Fatalf("frames[%d] = %+v, want 2 frames", i, fs)
+		}
+		if got, want := fs[0].Function, "braces.dev/errtrace.inlinedWrap"; got != want {
+			t.Errorf("frames[%d][0] = %v, want %v", i, got, want)
+		}
+		if got, want := fs[1].Function, "braces.dev/errtrace.inlinedWrapPair"; got != want {
+			t.Errorf("frames[%d][1] = %v, want %v", i, got, want)
+		}
+	}
+
+	// The caller frames report the line of each call.
+	lineA, lineB := frames[0][1].Line, frames[1][1].Line
+	if lineB != lineA+1 {
+		t.Errorf("caller lines = %d, %d; want consecutive lines", lineA, lineB)
+	}
+	if frames[2][1].Line != lineB || frames[3][1].Line != lineA {
+		t.Errorf("repeated PCs resolved to lines %d, %d; want %d, %d",
+			frames[2][1].Line, frames[3][1].Line, lineB, lineA)
+	}
+
+	if got := symbolize(batch); got[0] != frames[0][0] || got[1] != frames[1][0] {
+		t.Errorf("symbolize() = %+v, want innermost frames", got)
+	}
+}
+
+func TestFrameCache_bounded(t *testing.T) {
+	const size = 4
+	c := newFrameCache(size)
+
+	for pc := uintptr(1); pc <= 3*size; pc++ {
+		c.Put(pc, []runtime.Frame{{PC: pc}})
+		if got := c.Len(); got > 2*size {
+			t.Fatalf("cache has %d entries, want at most %d", got, 2*size)
+		}
+	}
+
+	// The oldest entries were dropped.
+	if _, ok := c.Get(1); ok {
+		t.Errorf("pc 1 should have been evicted")
+	}
+
+	// Recent entries from the previous generation are kept,
+	// and survive the next rotation because they were used.
+	if fs, ok := c.Get(size + 1); !ok || len(fs) != 1 || fs[0].PC != size+1 {
+		t.Fatalf("Get(%d) = %+v, %v; want frame", size+1, fs, ok)
+	}
+	for pc := uintptr(100); pc < 100+size; pc++ {
+		c.Put(pc, []runtime.Frame{{PC: pc}})
+	}
+	if _, ok := c.Get(size + 1); !ok {
+		t.Errorf("pc %d should have been retained", size+1)
+	}
+}
+
+func TestFormat_concurrent(t *testing.T) {
+	err := Wrap(errors.Join(errorCaller(), errorMultiCaller()))
+	want := FormatString(err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for j := 0; j < 100; j++ {
+				if got := FormatString(err); got != want {
+					t.Errorf("got:\n%s\nwant:\n%s", got, want)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
@@ -5,7 +5,6 @@ import (
 	"fmt"
 	"io"
 	"runtime"
-	"slices"
 	"strings"
 )
 
@@ -27,6 +26,21 @@ type traceTree struct {
 	// and the last element is the shallowest call in the stack.
 	Trace []runtime.Frame
 
+	// Repeats is nil if every frame in Trace was returned through once.
+	// Otherwise, Repeats[i] is the number of consecutive times
+	// the error was returned through Trace[i].
+	Repeats []int
+
+	// Inlined is nil if no frame in Trace was inlined.
+	// Otherwise, Inlined[i] reports whether Trace[i]
+	// was inlined into Trace[i+1] by the compiler.
+	Inlined []bool
+
+	// Truncated is nil if no trace was truncated.
+	// Otherwise, Truncated[i] reports whether Wrap stopped tracing
+	// the error after Trace[i] because the trace was too long.
+	Truncated []bool
+
 	// Children are the traces for each of the errors
 	// inside the multi-error.
 	Children []traceTree
@@ -39,13 +53,96 @@ type traceTree struct {
 // if a multi-error is found,
 // a separate trace is built from each of its errors
 // and they're all considered children of this error.
+//
+// Program counters for the entire tree are symbolized together
+// after the tree is built.
+// Program counters inside inlined calls expand into multiple frames.
 func buildTraceTree(err error) traceTree {
-	current := traceTree{Err: err}
+	var b pcTreeBuilder
+	tree := b.Build(err)
+
+	frames := symbolizeInlined(b.pcs)
+	for i, f := range b.frames {
+		frames[i] = []runtime.Frame{f}
+	}
+	return tree.resolve(frames, b.counts, b.truncated)
+}
+
+// pcTree is the shape of a traceTree
+// before program counters are symbolized into frames.
+type pcTree struct {
+	Err error
+
+	// Program counters for this node are pcs[Start:End]
+	// in the pcTreeBuilder that built it,
+	// in the order they were unwrapped (shallowest call first).
+	// Their repeat counts are counts[Start:End].
+	Start, End int
+
+	Children []pcTree
+}
+
+// pcTreeBuilder builds a pcTree from an error,
+// gathering program counters for all nodes in a single slice.
+//
+// Consecutive identical program counters in a node
+// are collapsed into one with a higher count.
+//
+// Errors with explicit frames (see [WrapFrame]) take up a slot in pcs
+// with a zero program counter, and the frame is recorded separately.
+type pcTreeBuilder struct {
+	pcs       []uintptr
+	counts    []int                 // counts[i] is the repeat count of pcs[i]
+	frames    map[int]runtime.Frame // index in pcs => explicit frame
+	truncated map[int]bool          // index in pcs => trace truncated after it
+}
+
+// add records that the error was returned through pc count times
+// in the node whose program counters start at start.
+func (b *pcTreeBuilder) add(start int, pc uintptr, count int) {
+	if last := len(b.pcs) - 1; last >= start && pc != 0 && b.pcs[last] == pc {
+		b.counts[last] += count
+		return
+	}
+
+	b.pcs = append(b.pcs, pc)
+	b.counts = append(b.counts, count)
+}
+
+// addFrame records an explicit frame.
+func (b *pcTreeBuilder) addFrame(f runtime.Frame) {
+	if b.frames == nil {
+		b.frames = make(map[int]runtime.Frame)
+	}
+	b.frames[len(b.pcs)] = f
+	b.pcs = append(b.pcs, 0)
+	b.counts = append(b.counts, 1)
+}
+
+func (b *pcTreeBuilder) Build(err error) pcTree {
+	current := pcTree{Err: err, Start: len(b.pcs)}
 loop:
 	for {
-		if frame, inner, ok := UnwrapFrame(err); ok {
-			current.Trace = append(current.Trace, frame)
-			err = inner
+		if e, ok := err.(interface{ TraceFrame() runtime.Frame }); ok {
+			b.addFrame(e.TraceFrame())
+			err = errors.Unwrap(err)
+			continue
+		}
+
+		if e, ok := err.(interface{ TracePC() uintptr }); ok {
+			count, truncated := 1, false
+			if et, ok := e.(*errTrace); ok {
+				count = int(et.count)
+				truncated = et.depth >= _maxTraceDepth
+			}
+			b.add(current.Start, e.TracePC(), count)
+			if truncated {
+				if b.truncated == nil {
+					b.truncated = make(map[int]bool)
+				}
+				b.truncated[len(b.pcs)-1] = true
+			}
+			err = errors.Unwrap(err)
 			continue
 		}
 
@@ -59,13 +156,13 @@ loop:
 		case interface{ Unwrap() []error }:
 			// Encountered a multi-error.
 			// Everything else is a child of current.
+			current.End = len(b.pcs)
 			errs := x.Unwrap()
-			current.Children = make([]traceTree, 0, len(errs))
+			current.Children = make([]pcTree, 0, len(errs))
 			for _, err := range errs {
-				current.Children = append(current.Children, buildTraceTree(err))
+				current.Children = append(current.Children, b.Build(err))
 			}
-
-			break loop
+			return current
 
 		default:
 			// Reached a terminal error.
@@ -73,10 +170,94 @@ loop:
 		}
 	}
 
-	slices.Reverse(current.Trace)
+	current.End = len(b.pcs)
 	return current
 }
 
+// resolve builds a traceTree using frames symbolized
+// from the program counters of the pcTreeBuilder.
+// frames[i] holds the frames for the i-th program counter,
+// starting with the innermost inlined call.
+//
+// If a program counter was returned through multiple times,
+// its count is attributed to its innermost frame.
+// If the trace was truncated after a program counter,
+// its outermost frame is marked as truncated.
+//
+// A function that an inlined call was made from
+// often returned the error itself on the same line,
+// e.g. with 'return Wrap(inlined())'.
+// Its frame is only reported once in that case.
+func (t *pcTree) resolve(frames [][]runtime.Frame, counts []int, truncated map[int]bool) traceTree {
+	tree := traceTree{Err: t.Err}
+
+	var (
+		trace               []runtime.Frame
+		repeats             []int
+		inlined             []bool
+		cut                 []bool
+		repeated, anyInline bool
+		anyCut              bool
+	)
+	// Traces are in the reverse order of the call stack,
+	// so walk program counters starting with the deepest call.
+	// Frames that didn't resolve (unlikely) are dropped.
+	for i := t.End - 1; i >= t.Start; i-- {
+		fs := frames[i]
+		for j, f := range fs {
+			n := 1
+			if j == 0 {
+				n = counts[i]
+			}
+			isInlined := j < len(fs)-1
+			isCut := !isInlined && truncated[i]
+
+			if last := len(trace) - 1; j == 0 && last > 0 && inlined[last-1] && sameFrame(trace[last], f) {
+				// The function that the previous frame was inlined into
+				// returned the error on the same line.
+				isCut = isCut || cut[last]
+				trace = trace[:last]
+				repeats = repeats[:last]
+				inlined = inlined[:last]
+				cut = cut[:last]
+			}
+
+			trace = append(trace, f)
+			repeats = append(repeats, n)
+			inlined = append(inlined, isInlined)
+			cut = append(cut, isCut)
+			repeated = repeated || n > 1
+			anyInline = anyInline || isInlined
+			anyCut = anyCut || isCut
+		}
+	}
+	if len(trace) > 0 {
+		tree.Trace = trace
+		if repeated {
+			tree.Repeats = repeats
+		}
+		if anyInline {
+			tree.Inlined = inlined
+		}
+		if anyCut {
+			tree.Truncated = cut
+		}
+	}
+
+	if t.Children != nil {
+		tree.Children = make([]traceTree, len(t.Children))
+		for i := range t.Children {
+			tree.Children[i] = t.Children[i].resolve(frames, counts, truncated)
+		}
+	}
+	return tree
+}
+
+// sameFrame reports whether two frames are for the same location.
+func sameFrame(a, b runtime.Frame) bool {
+	return a.Function == b.Function && a.File == b.File && a.Line == b.Line
+}
+
 func writeTree(w io.Writer, tree traceTree) error {
 	return (&treeWriter{W: w}).WriteTree(tree)
 }
@@ -106,10 +287,10 @@ func (p *treeWriter) writeTree(t traceTree, path []int) {
 		p.writeTree(child, append(path, i))
 	}
 
-	p.writeTrace(t.Err, t.Trace, path)
+	p.writeTrace(t, path)
 }
 
-func (p *treeWriter) writeTrace(err error, trace []runtime.Frame, path []int) {
+func (p *treeWriter) writeTrace(t traceTree, path []int) {
 	// A trace for a single error takes
 	// the same form as a stack trace:
 	//
@@ -120,6 +301,27 @@ func (p *treeWriter) writeTrace(err error, trace []runtime.Frame, path []int) {
 	// func2
 	// 	path/to/file.go:34
 	//
+	// If the error was returned through the same frame
+	// multiple times in a row, the frame is printed once
+	// with the number of times:
+	//
+	// func3 (returned 5 times)
+	// 	path/to/file.go:56
+	//
+	// Frames for functions that the compiler inlined into their caller
+	// are marked as such, and followed by the caller:
+	//
+	// func4 (inlined)
+	// 	path/to/file.go:78
+	// func5
+	// 	path/to/file.go:90
+	//
+	// If Wrap stopped tracing the error because its trace was too long,
+	// the last frame it traced is marked as truncated:
+	//
+	// func6 (trace truncated)
+	// 	path/to/file.go:12
+	//
 	// However, when path isn't empty, we're part of a tree,
 	// so we need to add prefixes containers around the trace
 	// to indicate the tree structure.
@@ -159,7 +361,7 @@ func (p *treeWriter) writeTrace(err error, trace []runtime.Frame, path []int) {
 	//
 	// The message may have newlines in it,
 	// so we need to print each line separately.
-	for i, line := range strings.Split(err.Error(), "\n") {
+	for i, line := range strings.Split(t.Err.Error(), "\n") {
 		if i == 0 {
 			p.pipes(path, "+- ")
 		} else {
@@ -169,14 +371,23 @@ func (p *treeWriter) writeTrace(err error, trace []runtime.Frame, path []int) {
 		p.writeString("\n")
 	}
 
-	if len(trace) > 0 {
+	if len(t.Trace) > 0 {
 		// Empty line between the message and the trace.
 		p.pipes(path, "|  ")
 		p.writeString("\n")
 
-		for _, frame := range trace {
+		for i, frame := range t.Trace {
 			p.pipes(path, "|  ")
 			p.writeString(frame.Function)
+			if t.Inlined != nil && t.Inlined[i] {
+				p.writeString(" (inlined)")
+			}
+			if t.Repeats != nil && t.Repeats[i] > 1 {
+				p.printf(" (returned %d times)", t.Repeats[i])
+			}
+			if t.Truncated != nil && t.Truncated[i] {
+				p.writeString(" (trace truncated)")
+			}
 			p.writeString("\n")
 
 			p.pipes(path, "|  ")
@@ -1,7 +1,10 @@
+//go:build !errtrace_off
+
 package errtrace
 
 import (
 	"errors"
+	"fmt"
 	"runtime"
 	"strings"
 	"testing"
@@ -24,6 +27,16 @@ func errorMultiCaller() error {
 	)
 }
 
+// errorRetryCaller wraps an error at the same site n times,
+// like a retry loop would.
+func errorRetryCaller(n int) error {
+	err := errorCaller()
+	for i := 0; i < n; i++ {
+		err = Wrap(err)
+	}
+	return err
+}
+
 func TestBuildTreeSingle(t *testing.T) {
 	tree := buildTraceTree(errorCaller())
 	trace := tree.Trace
@@ -67,6 +80,143 @@ func TestBuildTreeMulti(t *testing.T) {
 	}
 }
 
+func TestBuildTreeRepeated(t *testing.T) {
+	// Wrapped at the same site with other errors in between.
+	interleaved := errorCaller()
+	for i := 0; i < 3; i++ {
+		interleaved = fmt.Errorf("retry: %w", Wrap(interleaved))
+	}
+
+	errs := map[string]error{
+		"collapsed":   errorRetryCaller(3),
+		"interleaved": interleaved,
+	}
+	for name, err := range errs {
+		t.Run(name, func(t *testing.T) {
+			tree := buildTraceTree(err)
+
+			var got []string
+			for _, f := range tree.Trace {
+				got = append(got, f.Function)
+			}
+			want := []string{
+				"braces.dev/errtrace.errorCallee",
+				"braces.dev/errtrace.errorCaller",
+				"braces.dev/errtrace.TestBuildTreeRepeated",
+			}
+			if name == "collapsed" {
+				want[2] = "braces.dev/errtrace.errorRetryCaller"
+			}
+			if d := diff.Diff(want, got); d != "" {
+				t.Errorf("trace mismatch (-want +got):\n%s", d)
+			}
+
+			if d := diff.Diff([]int{1, 1, 3}, tree.Repeats); d != "" {
+				t.Errorf("repeats mismatch (-want +got):\n%s", d)
+			}
+		})
+	}
+}
+
+// inlinedWrap is small enough to be inlined into its callers
+// unless inlining is disabled with -gcflags=-l.
+func inlinedWrap(err error) error {
+	return Wrap(err)
+}
+
+//go:noinline
+func inlinedWrapCaller() error {
+	return inlinedWrap(errors.New("great sadness"))
+}
+
+func TestBuildTreeInlined(t *testing.T) {
+	err := inlinedWrapCaller()
+
+	// Frame.Func is nil only for inlined calls.
+	f, _ := runtime.CallersFrames([]uintptr{err.(*errTrace).pc}).Next()
+	inlined := f.Func == nil
+	t.Logf("inlined: %v", inlined)
+
+	want := []string{"braces.dev/errtrace.inlinedWrap"}
+	var wantInlined []bool
+	if inlined {
+		want = append(want, "braces.dev/errtrace.inlinedWrapCaller")
+		wantInlined = []bool{true, false}
+	}
+
+	tree := buildTraceTree(err)
+	var got []string
+	for _, f := range tree.Trace {
+		got = append(got, f.Function)
+	}
+	if d := diff.Diff(want, got); d != "" {
+		t.Errorf("trace mismatch (-want +got):\n%s", d)
+	}
+	if d := diff.Diff(wantInlined, tree.Inlined); d != "" {
+		t.Errorf("inlined mismatch (-want +got):\n%s", d)
+	}
+
+	frames, inner, ok := UnwrapFrames(err)
+	if !ok || inner == nil {
+		t.Fatalf("UnwrapFrames() = _, %v, %v; want inner error", inner, ok)
+	}
+	if d := diff.Diff(tree.Trace, frames); d != "" {
+		t.Errorf("UnwrapFrames mismatch (-want +got):\n%s", d)
+	}
+	if f, _, _ := UnwrapFrame(err); f != frames[0] {
+		t.Errorf("UnwrapFrame() = %+v, want %+v", f, frames[0])
+	}
+
+	s := FormatString(err)
+	if got, want := strings.Contains(s, "errtrace.inlinedWrap (inlined)\n"), inlined; got != want {
+		t.Errorf("inlined marker present = %v, want %v:\n%s", got, want, s)
+	}
+}
+
+func TestBuildTreeInlinedCallerReturned(t *testing.T) {
+	// The caller that inlinedWrap was inlined into
+	// also returned the error on the same line,
+	// so it's only reported once.
+	err := inlinedWrapCallerWrap()
+
+	var got []string
+	for _, f := range buildTraceTree(err).Trace {
+		got = append(got, f.Function)
+	}
+	want := []string{
+		"braces.dev/errtrace.inlinedWrap",
+		"braces.dev/errtrace.inlinedWrapCallerWrap",
+	}
+	if d := diff.Diff(want, got); d != "" {
+		t.Errorf("trace mismatch (-want +got):\n%s", d)
+	}
+}
+
+//go:noinline
+func inlinedWrapCallerWrap() error {
+	return Wrap(inlinedWrap(errors.New("great sadness")))
+}
+
+func TestBuildTreeExplicitFrames(t *testing.T) {
+	remote := runtime.Frame{Function: "example.com/remote.Handler", File: "handler.go", Line: 42}
+	err := Wrap(WrapFrame(WrapFrame(errorCaller(), remote), remote))
+
+	var got []string
+	for _, f := range buildTraceTree(err).Trace {
+		got = append(got, f.Function)
+	}
+	want := []string{
+		"braces.dev/errtrace.errorCallee",
+		"braces.dev/errtrace.errorCaller",
+		"example.com/remote.Handler",
+		"example.com/remote.Handler",
+		"braces.dev/errtrace.TestBuildTreeExplicitFrames",
+	}
+	if d := diff.Diff(want, got); d != "" {
+		t.Errorf("trace mismatch (-want +got):\n%s", d)
+	}
+}
+
 func TestWriteTree(t *testing.T) {
 	type testFrame struct {
 		Function string
@@ -116,6 +266,54 @@ func TestWriteTree(t *testing.T) {
 				"	bar.go:24",
 			},
 		},
+		{
+			name: "repeated frame",
+			give: func() traceTree {
+				t := tree(
+					errors.New("test error"),
+					frames{
+						{"foo", "foo.go", 42},
+						{"bar", "bar.go", 24},
+					},
+				)
+				t.Repeats = []int{1, 5}
+				return t
+			}(),
+			want: []string{
+				"test error",
+				"",
+				"foo",
+				"	foo.go:42",
+				"bar (returned 5 times)",
+				"	bar.go:24",
+			},
+		},
+		{
+			name: "inlined frame",
+			give: func() traceTree {
+				t := tree(
+					errors.New("test error"),
+					frames{
+						{"foo", "foo.go", 42},
+						{"bar", "bar.go", 24},
+						{"baz", "baz.go", 12},
+					},
+				)
+				t.Repeats = []int{3, 1, 1}
+				t.Inlined = []bool{true, false, false}
+				return t
+			}(),
+			want: []string{
+				"test error",
+				"",
+				"foo (inlined) (returned 3 times)",
+				"	foo.go:42",
+				"bar",
+				"	bar.go:24",
+				"baz",
+				"	baz.go:12",
+			},
+		},
 		{
 			name: "multi error without trace",
 			give: tree(
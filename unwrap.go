@@ -3,6 +3,7 @@ package errtrace
 import (
 	"errors"
 	"runtime"
+	"slices"
 )
 
 // UnwrapFrame unwraps the outermost frame from the given error,
@@ -12,17 +13,29 @@ import (
 //
 // You can use this for structured access to trace information.
 //
-// Any error that has a method `TracePC() uintptr` will
+// Any error that has a method `TracePC() uintptr`
+// or `TraceFrame() runtime.Frame` (see [WrapFrame]) will
 // contribute a frame to the trace.
+//
+// Repeated wraps at the same location are collapsed by [Wrap],
+// so their frame is returned only once.
+//
+// If the function that returned the error was inlined into its caller,
+// frame is the frame for the function that returned the error.
+// Use [UnwrapFrames] to also get the callers it was inlined into.
 func UnwrapFrame(err error) (frame runtime.Frame, inner error, ok bool) { //nolint:revive // error is intentionally middle return
+	if e, ok := err.(interface{ TraceFrame() runtime.Frame }); ok {
+		f := e.TraceFrame()
+		return f, errors.Unwrap(err), f != (runtime.Frame{})
+	}
+
 	e, ok := err.(interface{ TracePC() uintptr })
 	if !ok {
 		return runtime.Frame{}, err, false
 	}
 
 	inner = errors.Unwrap(err)
-	frames := runtime.CallersFrames([]uintptr{e.TracePC()})
-	f, _ := frames.Next()
+	f := symbolize([]uintptr{e.TracePC()})[0]
 	if f == (runtime.Frame{}) {
 		// Unlikely, but if PC didn't yield a frame,
 		// just return the inner error.
@@ -31,3 +44,39 @@ func UnwrapFrame(err error) (frame runtime.Frame, inner error, ok bool) { //noli
 
 	return f, inner, true
 }
+
+// UnwrapFrames is similar to [UnwrapFrame],
+// but expands the outermost frame into all the frames it's comprised of
+// if the compiler inlined the function that returned the error
+// into its caller.
+//
+// frames[0] is the frame for the function that returned the error.
+// Every frame except the last was inlined into the frame following it,
+// so the last frame is the function that the compiler actually emitted.
+// If no inlining took place, frames has a single element.
+//
+// ok is false if the error is not an errtrace error,
+// or if its frame could not be resolved.
+func UnwrapFrames(err error) (frames []runtime.Frame, inner error, ok bool) { //nolint:revive // error is intentionally middle return
+	if e, ok := err.(interface{ TraceFrame() runtime.Frame }); ok {
+		f := e.TraceFrame()
+		if f == (runtime.Frame{}) {
+			return nil, errors.Unwrap(err), false
+		}
+		return []runtime.Frame{f}, errors.Unwrap(err), true
+	}
+
+	e, ok := err.(interface{ TracePC() uintptr })
+	if !ok {
+		return nil, err, false
+	}
+
+	inner = errors.Unwrap(err)
+	frames = symbolizeInlined([]uintptr{e.TracePC()})[0]
+	if len(frames) == 0 {
+		return nil, inner, false
+	}
+
+	// The cached frames must not be modified by callers.
+	return slices.Clone(frames), inner, true
+}
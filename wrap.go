@@ -1,13 +1,30 @@
+//go:build !errtrace_off
+
 package errtrace
 
 import (
 	"braces.dev/errtrace/internal/pc"
 )
 
+// _tracingBuilt reports whether errtrace was built with tracing support.
+// It's false with the errtrace_off build tag.
+const _tracingBuilt = true
+
 // Wrap adds information about the program counter of the caller to the error.
 // This is intended to be used at all return points in a function.
 // If err is nil, Wrap returns nil.
 //
+// Wrapping an error again at the same return site
+// (e.g. in a retry loop) doesn't grow its trace:
+// the repeated frame is reported once with the number of times
+// the error was returned through it.
+// [errors.Is] still matches the errors returned by earlier wraps.
+//
+// Traces are capped at 1000 frames, not counting repeats.
+// Once an error's trace has that many,
+// Wrap returns it unchanged, so newer frames are not recorded,
+// and [Format] marks the last recorded frame with "(trace truncated)".
+//
 //go:noinline so caller's PC is saved in the stack frame for asm GetCaller.
 func Wrap(err error) error {
 	if err == nil {
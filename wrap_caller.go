@@ -1,6 +1,13 @@
+//go:build !errtrace_off
+
 package errtrace
 
-import "braces.dev/errtrace/internal/pc"
+import (
+	"errors"
+	"fmt"
+
+	"braces.dev/errtrace/internal/pc"
+)
 
 // Caller represents a single caller frame, and is intended for error helpers
 // to capture caller information for wrapping. See [GetCaller] for details.
@@ -26,8 +33,65 @@ func GetCaller() Caller {
 	return Caller{pc.GetCallerSkip1()}
 }
 
+// GetCallerSkip is similar to [GetCaller],
+// but captures the program counter of a caller n frames up the stack
+// from the function calling GetCallerSkip.
+// GetCallerSkip(0) captures the location of the GetCallerSkip call itself,
+// and GetCallerSkip(1) is equivalent to GetCaller.
+// Negative values of n are treated as 0.
+//
+// Use this in error helpers that are called through other helpers,
+// e.g. assertion or logging libraries,
+// to attribute errors to the code that called the outermost helper.
+// As with GetCaller, all helpers between GetCallerSkip
+// and the frame being captured should be marked '//go:noinline'.
+//
+//	//go:noinline
+//	func Fail(msg string) error {
+//		return fail(msg)
+//	}
+//
+//	//go:noinline
+//	func fail(msg string) error {
+//		// Skip fail and Fail to capture the caller of Fail.
+//		return errtrace.GetCallerSkip(2).New(msg)
+//	}
+//
+//go:noinline
+func GetCallerSkip(n int) Caller {
+	return Caller{pc.GetCallerSkip(max(n, 0))}
+}
+
 // Wrap adds the program counter captured in Caller to the error,
 // similar to [Wrap], but relying on previously captured caller inforamtion.
 func (c Caller) Wrap(err error) error {
 	return wrap(err, c.callerPC)
 }
+
+// New returns an error with the supplied text
+// and the program counter captured in Caller,
+// similar to [New].
+func (c Caller) New(text string) error {
+	return wrap(errors.New(text), c.callerPC)
+}
+
+// Errorf creates an error message according to a format specifier
+// with the program counter captured in Caller,
+// similar to [Errorf].
+func (c Caller) Errorf(format string, args ...any) error {
+	return wrap(fmt.Errorf(format, args...), c.callerPC)
+}
+
+// Wrapf adds context to err with a message according to a format specifier,
+// and the program counter captured in Caller.
+// The message of the returned error is "<message>: <err>",
+// and it unwraps to err.
+// If err is nil, Wrapf returns nil.
+func (c Caller) Wrapf(err error, format string, args ...any) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	return wrap(fmt.Errorf("%s: %w", msg, err), c.callerPC)
+}
@@ -1,4 +1,4 @@
-//go:build safe || !(amd64 || arm64)
+//go:build !errtrace_off && (safe || !(amd64 || arm64 || riscv64))
 
 //
 // Build tag must match pc_safe.go
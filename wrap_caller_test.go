@@ -1,3 +1,5 @@
+//go:build !errtrace_off
+
 package errtrace_test
 
 import (
@@ -100,6 +102,154 @@ func retCallerNoInline() errtrace.Caller {
 	return errtrace.GetCaller()
 }
 
+func TestGetCallerSkip_Helpers(t *testing.T) {
+	err := callFailHelper()
+	wantErr(t, err, "callFailHelper")
+	if got, want := err.Error(), "great sadness"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+}
+
+func callFailHelper() error {
+	return failHelper("great sadness")
+}
+
+// failHelper and failHelperInner are an assertion library's helpers.
+//
+//go:noinline
+func failHelper(msg string) error {
+	return failHelperInner(msg)
+}
+
+//go:noinline
+func failHelperInner(msg string) error {
+	return errtrace.GetCallerSkip(2).New(msg)
+}
+
+func TestGetCallerSkip_Levels(t *testing.T) {
+	// GetCallerSkip(n) called in skipLevel3 skips n frames
+	// starting with skipLevel3.
+	tests := []struct {
+		n    int
+		want string
+	}{
+		{-1, "skipLevel3"},
+		{0, "skipLevel3"},
+		{1, "skipLevel2"},
+		{2, "skipLevel1"},
+		{3, "TestGetCallerSkip_Levels"},
+	}
+
+	for _, tt := range tests {
+		err := skipLevel1(tt.n)
+		wantErr(t, err, tt.want)
+	}
+}
+
+//go:noinline
+func skipLevel1(n int) error {
+	return skipLevel2(n)
+}
+
+//go:noinline
+func skipLevel2(n int) error {
+	return skipLevel3(n)
+}
+
+//go:noinline
+func skipLevel3(n int) error {
+	return errtrace.GetCallerSkip(n).Wrap(errFoo)
+}
+
+// TestGetCallerSkip_Inlinable uses helpers that aren't marked noinline.
+// Run with -gcflags=-l to disable inlining,
+// in which case the asm implementation must report the right frame.
+func TestGetCallerSkip_Inlinable(t *testing.T) {
+	err, inlined := callInlinableHelper()
+
+	wantFn := "callInlinableHelper"
+	if !safe && inlined {
+		// The asm implementation counts physical frames,
+		// so it skips past the helper's caller if a helper was inlined.
+		f, _, _ := errtrace.UnwrapFrame(err)
+		if !strings.HasSuffix(f.Function, "."+wantFn) {
+			t.Logf("helpers were inlined, got %v", f.Function)
+			return
+		}
+	}
+	wantErr(t, err, wantFn)
+}
+
+func callInlinableHelper() (error, bool) { //nolint:revive // error is intentionally first return
+	return inlinableHelper()
+}
+
+func inlinableHelper() (error, bool) { //nolint:revive // error is intentionally first return
+	return inlinableHelperInner()
+}
+
+func inlinableHelperInner() (error, bool) { //nolint:revive // error is intentionally first return
+	err := errtrace.GetCallerSkip(2).Errorf("great %v", "sadness")
+	return err, isInlined(1) || isInlined(2)
+}
+
+// isInlined reports whether the function skip frames above its caller
+// was inlined into its own caller.
+//
+//go:noinline
+func isInlined(skip int) bool {
+	var pcs [1]uintptr
+	if runtime.Callers(skip+2, pcs[:]) == 0 { // runtime.Callers, isInlined
+		return false
+	}
+	f, _ := runtime.CallersFrames(pcs[:]).Next()
+	return f.Func == nil
+}
+
+func TestCallerWrapf(t *testing.T) {
+	if err := errtrace.GetCaller().Wrapf(nil, "open %v", "foo"); err != nil {
+		t.Errorf("Wrapf(nil) = %v, want nil", err)
+	}
+
+	err := callWrapf()
+	wantErr(t, err, "callWrapf")
+	if got, want := err.Error(), "open foo: foo"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, errFoo) {
+		t.Errorf("Is(): want true")
+	}
+}
+
+func callWrapf() error {
+	return wrapf(errFoo, "open %v", "foo")
+}
+
+//go:noinline
+func wrapf(err error, format string, args ...any) error {
+	return errtrace.GetCaller().Wrapf(err, format, args...)
+}
+
+func TestCallerErrorf(t *testing.T) {
+	err := callErrorf()
+	wantErr(t, err, "callErrorf")
+	if got, want := err.Error(), "wrapped: foo"; got != want {
+		t.Errorf("Error() = %q, want %q", got, want)
+	}
+	if !errors.Is(err, errFoo) {
+		t.Errorf("Is(): want true")
+	}
+}
+
+func callErrorf() error {
+	return errorf("wrapped: %w", errFoo)
+}
+
+//go:noinline
+func errorf(format string, args ...any) error {
+	return errtrace.GetCaller().Errorf(format, args...)
+}
+
 func wantErr(t testing.TB, err error, fn string) runtime.Frame {
 	if err == nil {
 		t.Fatalf("expected err")
@@ -111,3 +261,32 @@ func wantErr(t testing.TB, err error, fn string) runtime.Frame {
 	}
 	return f
 }
+
+func TestGetCallerMode(t *testing.T) {
+	want := errtrace.CallerModeAsm
+	if safe {
+		want = errtrace.CallerModeSafe
+	}
+
+	if got := errtrace.GetCallerMode(); got != want {
+		t.Errorf("GetCallerMode() = %v, want %v", got, want)
+	}
+}
+
+func TestCallerModeString(t *testing.T) {
+	tests := []struct {
+		give errtrace.CallerMode
+		want string
+	}{
+		{errtrace.CallerModeAsm, "asm"},
+		{errtrace.CallerModeSafe, "safe"},
+		{errtrace.CallerModeFallback, "fallback"},
+		{errtrace.CallerMode(0), "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.give.String(); got != tt.want {
+			t.Errorf("%d.String() = %q, want %q", int(tt.give), got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+//go:build errtrace_off
+
+// This file replaces wrap.go, errors.go, and wrap_caller.go
+// when errtrace is built with the errtrace_off build tag.
+// The functions here don't add trace information,
+// and are small enough to be inlined into their callers,
+// so instrumented code compiles down to the uninstrumented version.
+
+package errtrace
+
+import (
+	"errors"
+	"fmt"
+)
+
+// _tracingBuilt reports whether errtrace was built with tracing support.
+// It's false with the errtrace_off build tag.
+const _tracingBuilt = false
+
+// Wrap returns err unchanged because errtrace was built
+// with the errtrace_off build tag.
+func Wrap(err error) error {
+	return err
+}
+
+// Wrap2 returns its arguments unchanged because errtrace was built
+// with the errtrace_off build tag.
+func Wrap2[T any](t T, err error) (T, error) {
+	return t, err
+}
+
+// Wrap3 returns its arguments unchanged because errtrace was built
+// with the errtrace_off build tag.
+func Wrap3[T1, T2 any](t1 T1, t2 T2, err error) (T1, T2, error) {
+	return t1, t2, err
+}
+
+// Wrap4 returns its arguments unchanged because errtrace was built
+// with the errtrace_off build tag.
+func Wrap4[T1, T2, T3 any](t1 T1, t2 T2, t3 T3, err error) (T1, T2, T3, error) {
+	return t1, t2, t3, err
+}
+
+// Wrap5 returns its arguments unchanged because errtrace was built
+// with the errtrace_off build tag.
+func Wrap5[T1, T2, T3, T4 any](t1 T1, t2 T2, t3 T3, t4 T4, err error) (T1, T2, T3, T4, error) {
+	return t1, t2, t3, t4, err
+}
+
+// Wrap6 returns its arguments unchanged because errtrace was built
+// with the errtrace_off build tag.
+func Wrap6[T1, T2, T3, T4, T5 any](t1 T1, t2 T2, t3 T3, t4 T4, t5 T5, err error) (T1, T2, T3, T4, T5, error) {
+	return t1, t2, t3, t4, t5, err
+}
+
+// New is equivalent to [errors.New] because errtrace was built
+// with the errtrace_off build tag.
+func New(text string) error {
+	return errors.New(text)
+}
+
+// Errorf is equivalent to [fmt.Errorf] because errtrace was built
+// with the errtrace_off build tag.
+func Errorf(format string, args ...any) error {
+	return fmt.Errorf(format, args...)
+}
+
+// Caller represents a single caller frame, and is intended for error helpers
+// to capture caller information for wrapping. See [GetCaller] for details.
+//
+// Caller holds no information because errtrace was built
+// with the errtrace_off build tag.
+type Caller struct{}
+
+// GetCaller returns an empty Caller because errtrace was built
+// with the errtrace_off build tag.
+func GetCaller() Caller {
+	return Caller{}
+}
+
+// GetCallerSkip returns an empty Caller because errtrace was built
+// with the errtrace_off build tag.
+func GetCallerSkip(n int) Caller {
+	return Caller{}
+}
+
+// Wrap returns err unchanged because errtrace was built
+// with the errtrace_off build tag.
+func (c Caller) Wrap(err error) error {
+	return err
+}
+
+// New is equivalent to [errors.New] because errtrace was built
+// with the errtrace_off build tag.
+func (c Caller) New(text string) error {
+	return errors.New(text)
+}
+
+// Errorf is equivalent to [fmt.Errorf] because errtrace was built
+// with the errtrace_off build tag.
+func (c Caller) Errorf(format string, args ...any) error {
+	return fmt.Errorf(format, args...)
+}
+
+// Wrapf adds context to err with a message according to a format specifier.
+// The message of the returned error is "<message>: <err>",
+// and it unwraps to err.
+// If err is nil, Wrapf returns nil.
+func (c Caller) Wrapf(err error, format string, args ...any) error {
+	if err == nil {
+		return nil
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	return fmt.Errorf("%s: %w", msg, err)
+}
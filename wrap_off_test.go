@@ -0,0 +1,104 @@
+//go:build errtrace_off
+
+package errtrace_test
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"braces.dev/errtrace"
+)
+
+func TestOff_unchanged(t *testing.T) {
+	orig := errors.New("great sadness")
+
+	if got := errtrace.Wrap(orig); got != orig {
+		t.Errorf("Wrap() = %#v, want unchanged %#v", got, orig)
+	}
+	if _, got := errtrace.Wrap2(1, orig); got != orig {
+		t.Errorf("Wrap2() = %#v, want unchanged %#v", got, orig)
+	}
+	if _, _, got := errtrace.Wrap3(1, 2, orig); got != orig {
+		t.Errorf("Wrap3() = %#v, want unchanged %#v", got, orig)
+	}
+	if _, _, _, got := errtrace.Wrap4(1, 2, 3, orig); got != orig {
+		t.Errorf("Wrap4() = %#v, want unchanged %#v", got, orig)
+	}
+	if _, _, _, _, got := errtrace.Wrap5(1, 2, 3, 4, orig); got != orig {
+		t.Errorf("Wrap5() = %#v, want unchanged %#v", got, orig)
+	}
+	if _, _, _, _, _, got := errtrace.Wrap6(1, 2, 3, 4, 5, orig); got != orig {
+		t.Errorf("Wrap6() = %#v, want unchanged %#v", got, orig)
+	}
+	if got := errtrace.GetCaller().Wrap(orig); got != orig {
+		t.Errorf("Caller.Wrap() = %#v, want unchanged %#v", got, orig)
+	}
+	if got := errtrace.GetCallerSkip(2).Wrap(orig); got != orig {
+		t.Errorf("GetCallerSkip().Wrap() = %#v, want unchanged %#v", got, orig)
+	}
+	if got := errtrace.GetCaller().Wrapf(nil, "wrapped"); got != nil {
+		t.Errorf("Caller.Wrapf(nil) = %#v, want nil", got)
+	}
+	if got, want := errtrace.GetCaller().Wrapf(orig, "open %v", "foo").Error(), "open foo: great sadness"; got != want {
+		t.Errorf("Caller.Wrapf() = %q, want %q", got, want)
+	}
+
+	for _, err := range []error{
+		errtrace.New("great sadness"),
+		errtrace.Errorf("wrapped: %w", orig),
+		errtrace.GetCaller().New("great sadness"),
+		errtrace.GetCaller().Errorf("wrapped: %w", orig),
+		errtrace.GetCaller().Wrapf(orig, "wrapped"),
+	} {
+		if _, _, ok := errtrace.UnwrapFrame(err); ok {
+			t.Errorf("error %q has a trace, want none", err)
+		}
+	}
+	if got, want := errtrace.Errorf("wrapped: %w", orig).Error(), "wrapped: great sadness"; got != want {
+		t.Errorf("Errorf() = %q, want %q", got, want)
+	}
+
+	errtrace.Helper()
+	errtrace.RegisterHelper(TestOff_unchanged)
+	if got := errtrace.Wrap(orig); got != orig {
+		t.Errorf("Wrap() in helper = %#v, want unchanged %#v", got, orig)
+	}
+
+	errtrace.SetEnabled(true)
+	if errtrace.Enabled() {
+		t.Errorf("Enabled() = true, want false with errtrace_off")
+	}
+}
+
+func TestOff_noAllocs(t *testing.T) {
+	orig := errors.New("great sadness")
+	allocs := testing.AllocsPerRun(100, func() {
+		_ = errtrace.Wrap(orig)
+		_, _ = errtrace.Wrap2(42, orig)
+		_ = errtrace.GetCaller().Wrap(orig)
+	})
+	if allocs != 0 {
+		t.Errorf("Wrap allocated %v times, want 0", allocs)
+	}
+}
+
+func TestOff_inlined(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping compiler invocation in short mode")
+	}
+
+	out, err := exec.Command("go", "build", "-tags", "errtrace_off", "-gcflags=-m", ".").CombinedOutput()
+	if err != nil {
+		t.Fatalf("go build: %v\n%s", err, out)
+	}
+
+	// Errorf is not listed: inlining fmt.Errorf into it
+	// puts it over the compiler's inlining budget.
+	for _, fn := range []string{"Wrap", "New", "GetCaller", "GetCallerSkip", "Caller.Wrap", "Caller.New"} {
+		if want := "can inline " + fn + "\n"; !strings.Contains(string(out), want) {
+			t.Errorf("%v is not inlinable:\n%s", fn, out)
+		}
+	}
+}